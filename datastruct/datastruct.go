@@ -5,8 +5,41 @@ import "sync"
 const(
 	KEY_RequestIds = "requestIds"
 	KEY_StrategyItems ="StrategyItems"
+	KEY_StrategyProfile = "StrategyProfile"
+	KEY_Operator = "operator"
 	ERRORMSG_DecoderError = "cannot decoder body"
 	ERRORMSG_BlankBody = "body is blank"
+
+	MSGCATALOG_Data  = 0x01 //数据类消息
+	MSGCATALOG_Alert = 0x20 //报警类消息
+	MSGCATALOG_Test  = 0x30 //心跳类消息
+
+	MSGCATALOG_CoreData = 22 //核心数据类消息
+)
+
+//车道报警消息类别，与monitor协议中的消息类别一致，供统计模块使用
+const (
+	MSGTYPE_EntryCard      = 0x05 //入口通行卡存量报警
+	MSGTYPE_ExitCard       = 0x06 //出口通行卡存量报警
+	MSGTYPE_NotePrint      = 0x07 //出口打印票存量报警
+	MSGTYPE_NoteHand       = 0x08 //出口定额票余额报警
+	MSGTYPE_OpeCardFail    = 0x0A //卡操作失败
+	MSGTYPE_ReaderInitFail = 0x0B //卡机初始化失败
+	MSGTYPE_NoteModeChange = 0x0D //出口票据模式切换
+	MSGTYPE_NoteAgain      = 0x0E //出口票据重打
+	MSGTYPE_MotoStart      = 0x15 //车队开始
+	MSGTYPE_MotoEnd        = 0x16 //车队结束
+	MSGTYPE_ReaderErr      = 0x18 //卡机故障
+	MSGTYPE_Heart          = 0x22 //心跳，内容为变化的LaneInfo项
+)
+
+//统计模块产生的消息类别，不得与车道消息类别重复
+const (
+	MSGTYPE_Motorcade       = 0x1A //车队放行事件，数据类消息
+	MSGTYPE_CardForecast    = 0x1D //通行卡存量预警
+	MSGTYPE_ReprintRate     = 0x1E //票据重打率异常
+	MSGTYPE_ReaderMaintain  = 0x1F //卡机维护报警
+	MSGTYPE_MotorcadeExceed = 0x23 //车队放行超限
 )
 //Node 节点信息
 type Node struct {
@@ -68,6 +101,22 @@ func NewMsgSend() MsgSend {
 	return MsgSend{MsgContent: make(map[string]interface{}),}
 }
 
+//获取消息内容中的整型字段，不存在或类型不符时返回0
+func (m MsgSend) GetInt(key string) int {
+	if v, ok := m.MsgContent[key].(int); ok {
+		return v
+	}
+	return 0
+}
+
+//获取消息内容中的字符串字段，不存在或类型不符时返回空字符串
+func (m MsgSend) GetString(key string) string {
+	if v, ok := m.MsgContent[key].(string); ok {
+		return v
+	}
+	return ""
+}
+
 //监控时间数据结构
 type MetricValue struct {
 	Endpoint  string      `json:"endpoint"`
@@ -90,6 +139,7 @@ type LaneInfo struct {
 func NewLaneInfo(node Node) LaneInfo {
 	a := make(map[string]interface{})
 	a["ConnectStatus"] = false
+	a["readerHealth"] = 100
 	return LaneInfo{Info: a, Node: node, lock: &sync.Mutex{}}
 }
 
@@ -107,6 +157,19 @@ func (l LaneInfo) GetInfo() map[string]interface{} {
 	return l.Info
 }
 
+//Copy 复制车道数据，用于在锁外序列化，为同步锁操作
+func (l LaneInfo) Copy() LaneInfo {
+	info := make(map[string]interface{}, len(l.Info))
+	if l.lock != nil {
+		l.lock.Lock()
+		defer l.lock.Unlock()
+	}
+	for k, v := range l.Info {
+		info[k] = v
+	}
+	return LaneInfo{Node: l.Node, Info: info, lock: &sync.Mutex{}}
+}
+
 //车道核心数据结构
 type CoreData struct {
 	Node     Node                   `json:"node"`
@@ -133,6 +196,30 @@ func (c CoreData) Data() map[string]interface{} {
 	return c.CoreData
 }
 
+//Copy 复制核心数据，用于在锁外序列化，为同步锁操作
+func (c CoreData) Copy() CoreData {
+	data := make(map[string]interface{}, len(c.CoreData))
+	if c.lock != nil {
+		c.lock.Lock()
+		defer c.lock.Unlock()
+	}
+	for k, v := range c.CoreData {
+		data[k] = v
+	}
+	return CoreData{Node: c.Node, CoreData: data, lock: &sync.Mutex{}}
+}
+
+//获取核心数据项，为同步锁操作
+func (c CoreData) GetData(key string) (interface{}, bool) {
+	if c.lock == nil {
+		return nil, false
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	v, ok := c.CoreData[key]
+	return v, ok
+}
+
 //报警策略数据结构
 type StrategyItem struct {
 	Type        int    `json:"type"`
@@ -140,3 +227,22 @@ type StrategyItem struct {
 	IsChecked   bool   `json:"isChecked"`
 	Level       int    `json:"level"`
 }
+
+//报警策略方案数据结构，方案保存在服务端，可指定给操作员或收费站
+//Operators 指定该方案的操作员；Stations 指定该方案的收费站节点(16位)
+type StrategyProfile struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	Items       []StrategyItem `json:"items"`
+	Operators   []string       `json:"operators"`
+	Stations    []string       `json:"stations"`
+}
+
+//获取方案内报警类别-报警策略映射
+func (p StrategyProfile) TypeToItems() map[int]StrategyItem {
+	items := make(map[int]StrategyItem)
+	for _, item := range p.Items {
+		items[item.Type] = item
+	}
+	return items
+}
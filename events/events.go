@@ -0,0 +1,98 @@
+package events
+
+import (
+	"sort"
+	"time"
+	"tollsys/tollmon/datastruct"
+	"tollsys/tollmon/g"
+	"tollsys/tollmon/parameters"
+	"tollsys/tollmon/store"
+)
+
+//报警事件记录
+//发布的每条报警写入本地存储，供查询及导出
+
+const (
+	KIND       = "event"
+	timeFormat = "2006-01-02 15:04:05"
+)
+
+//Event 报警事件
+type Event struct {
+	LaneID      string                 `json:"laneID"`
+	LaneName    string                 `json:"laneName"`
+	Type        int                    `json:"type"`
+	Description string                 `json:"description"`
+	Level       int                    `json:"level"`
+	Time        string                 `json:"time"`
+	Content     map[string]interface{} `json:"content"`
+}
+
+//Query 报警事件查询条件，字段为空值时不过滤
+//From/To 为日期范围
+type Query struct {
+	StationID string
+	LaneID    string
+	Types     []int
+	From      time.Time
+	To        time.Time
+}
+
+//Record 记录报警事件，以报警时间归档
+func Record(msg datastruct.MsgSend) {
+	e := Event{LaneID: msg.MsgLane, Type: msg.MsgType, Time: msg.MsgTime, Content: msg.MsgContent}
+	if node, ok := parameters.GetNodeByID(msg.MsgLane); ok {
+		e.LaneName = node.NodeName
+	}
+	if item, ok := parameters.GetTypeToStrategyItems()[msg.MsgType]; ok {
+		e.Description = item.Description
+		e.Level = item.Level
+	}
+	day, err := time.ParseInLocation(timeFormat, msg.MsgTime, time.Local)
+	if err != nil {
+		day = time.Now()
+	}
+	if err := store.Append(KIND, day, e); err != nil {
+		g.LogError("save event err:", msg.MsgLane, err.Error())
+	}
+}
+
+//GetEvents 查询报警事件，按报警时间排序
+func GetEvents(q Query) []Event {
+	list := make([]Event, 0)
+	err := store.Load(KIND, q.From, q.To, func(b []byte) {
+		e := Event{}
+		if err := g.Json.Unmarshal(b, &e); err != nil {
+			g.LogError("parse event err:", err.Error())
+			return
+		}
+		if q.match(&e) {
+			list = append(list, e)
+		}
+	})
+	if err != nil {
+		g.LogError("load event err:", err.Error())
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		return list[i].Time < list[j].Time
+	})
+	return list
+}
+
+func (q Query) match(e *Event) bool {
+	if q.StationID != "" && (len(e.LaneID) < 16 || e.LaneID[0:16] != q.StationID[0:16]) {
+		return false
+	}
+	if q.LaneID != "" && e.LaneID != q.LaneID {
+		return false
+	}
+	if len(q.Types) == 0 {
+		return true
+	}
+	for _, t := range q.Types {
+		if e.Type == t {
+			return true
+		}
+	}
+	return false
+}
@@ -21,18 +21,29 @@ type NodeConfig struct {
 type HttpConfig struct {
 	Listen string `json:"listen"`
 }
+//WebSocketConfig WebSocket服务配置
+//Interval 为心跳间隔及核心数据合并推送周期(秒)；QueueSize 为每个客户端的发送队列长度；
+//SlowPolicy 为发送队列满时的处理方式：drop 丢弃该条数据(缺省)，disconnect 断开该客户端；
+//ReplaySize 为每个收费站缓存用于断线回放的实时数据条数
 type WebSocketConfig struct {
-	Listen string `json:"listen"`
-	Interval int `json:"interval"`
+	Listen     string `json:"listen"`
+	Interval   int    `json:"interval"`
+	QueueSize  int    `json:"queueSize"`
+	SlowPolicy string `json:"slowPolicy"`
+	ReplaySize int    `json:"replaySize"`
 }
 type MonitorConfig struct {
 	Host string `json:"host"`
 	Port int    `json:"port"`
 }
+//RedisConfig Redis配置
+//PubSub 为true时通过Redis发布订阅在多个实例间转发实时数据；Channel 为频道前缀，按收费站节点区分频道
 type RedisConfig struct {
 	ConnectType string `json:"connectType"`
 	Host        string `json:"host"`
 	MaxPoolSize int    `json:"maxPoolSize"`
+	PubSub      bool   `json:"pubSub"`
+	Channel     string `json:"channel"`
 }
 type DBConfig struct {
 	Host        string `json:"host"`
@@ -51,6 +62,116 @@ type RoadConfig struct {
 type CoreDataConfig struct {
 	List map[string]int `json:"list"`
 }
+
+//NotifyFilter 报警通知过滤条件，字段为空时不过滤
+//Stations 收费站节点(16位)；Types 报警类别；MinLevel 最低报警等级
+type NotifyFilter struct {
+	Stations []string `json:"stations"`
+	Types    []int    `json:"types"`
+	MinLevel int      `json:"minLevel"`
+}
+
+//SmtpConfig 邮件通知配置
+//Recipients 收费站节点(16位) -> 收件人列表，"*" 为所有收费站的收件人
+//Subject/Body 为text/template模板，为空时使用默认模板
+type SmtpConfig struct {
+	Enabled    bool                `json:"enabled"`
+	Host       string              `json:"host"`
+	Port       int                 `json:"port"`
+	User       string              `json:"user"`
+	Pwd        string              `json:"pwd"`
+	From       string              `json:"from"`
+	Recipients map[string][]string `json:"recipients"`
+	Subject    string              `json:"subject"`
+	Body       string              `json:"body"`
+	Filter     *NotifyFilter       `json:"filter"`
+}
+
+//SyslogConfig RFC5424 syslog转发配置
+//Network 为udp或tcp；Template 为text/template模板，为空时使用默认模板
+type SyslogConfig struct {
+	Enabled  bool          `json:"enabled"`
+	Network  string        `json:"network"`
+	Addr     string        `json:"addr"`
+	Facility int           `json:"facility"`
+	AppName  string        `json:"appName"`
+	Template string        `json:"template"`
+	Filter   *NotifyFilter `json:"filter"`
+}
+type NotifyConfig struct {
+	Smtp   *SmtpConfig   `json:"smtp"`
+	Syslog *SyslogConfig `json:"syslog"`
+}
+
+//StoreConfig 本地数据存储配置，Path 为数据存放目录
+type StoreConfig struct {
+	Path string `json:"path"`
+}
+//TrafficConfig 车道流量统计配置，MinuteDays 为分钟级数据保留天数
+type TrafficConfig struct {
+	MinuteDays int `json:"minuteDays"`
+}
+//InventoryConfig 通行卡存量预测配置
+//RateMinutes 为计算发卡、收卡速率的统计时长；WarnMinutes 为预计到达阈值前提前预警的时长
+type InventoryConfig struct {
+	RateMinutes int `json:"rateMinutes"`
+	WarnMinutes int `json:"warnMinutes"`
+}
+//PrinterConfig 票据打印机状态配置
+//WindowMinutes 内重打次数不少于MinReprints且占出口流量比例超过MaxReprintRate(%)时报警
+//HandModes 为出口票据模式中属于手工票的模式值
+type PrinterConfig struct {
+	WindowMinutes  int     `json:"windowMinutes"`
+	MinReprints    int     `json:"minReprints"`
+	MaxReprintRate float64 `json:"maxReprintRate"`
+	HandModes      []int   `json:"handModes"`
+}
+//ReaderConfig 卡机状态配置
+//WindowMinutes 内某卡类型操作失败次数不少于MinFailures且占车道流量比例超过MaxFailRate(%)，
+//或卡机初始化失败、卡机故障次数不少于MaxFaults时报警
+type ReaderConfig struct {
+	WindowMinutes int     `json:"windowMinutes"`
+	MinFailures   int     `json:"minFailures"`
+	MaxFailRate   float64 `json:"maxFailRate"`
+	MaxFaults     int     `json:"maxFaults"`
+}
+//MotorcadeConfig 车队放行配置，MaxMinutes 为车队最长持续时间，MaxFlow 为车队最大车辆数，为0时不限制
+type MotorcadeConfig struct {
+	MaxMinutes int `json:"maxMinutes"`
+	MaxFlow    int `json:"maxFlow"`
+}
+
+//SummaryConfig 收费站每日汇总报表配置
+//Schedule 为生成前一日报表的cron表达式(分 时 日 月 周)；Top 为报警最多的员工数；KeepDays 为报表保留天数，为0时不清理
+type SummaryConfig struct {
+	Enabled  bool   `json:"enabled"`
+	Schedule string `json:"schedule"`
+	Top      int    `json:"top"`
+	KeepDays int    `json:"keepDays"`
+}
+//UpstreamConfig 上级转发配置
+//Enabled 为true时将本地收费站的节点树、实时数据及LaneInfo/CoreData变化转发至上级tollmon；
+//URL 为上级接入地址(ws://host:port/v1/ingest)；Token 为认证令牌；Retry 为断线重连间隔(秒)
+type UpstreamConfig struct {
+	Enabled bool   `json:"enabled"`
+	URL     string `json:"url"`
+	Token   string `json:"token"`
+	Retry   int    `json:"retry"`
+}
+//IngestConfig 下级接入配置，Tokens 为允许接入的下级认证令牌
+type IngestConfig struct {
+	Enabled bool     `json:"enabled"`
+	Tokens  []string `json:"tokens"`
+}
+//SpoolConfig 外发数据磁盘缓冲配置，用于上级转发及通知发送
+//SegmentSize 为单个分段文件大小(MB)；MaxSize 为每个队列最大积压(MB)，超过时丢弃最旧的分段；Retry 为投递失败重试间隔(秒)；
+//MaxAttempts 为通知等按记录投递的队列中单条记录的最大投递次数，超过后写入死信文件
+type SpoolConfig struct {
+	SegmentSize int `json:"segmentSize"`
+	MaxSize     int `json:"maxSize"`
+	Retry       int `json:"retry"`
+	MaxAttempts int `json:"maxAttempts"`
+}
 type GlobalConfig struct {
 	Log       *LogConfig       `json:"log"`
 	Node      *NodeConfig      `json:"node"`
@@ -61,6 +182,17 @@ type GlobalConfig struct {
 	Monitor   *MonitorConfig   `json:"monitor"`
 	Session   *SessionConfig   `json:"session"`
 	CoreData  *CoreDataConfig  `json:"coredata"`
+	Notify    *NotifyConfig    `json:"notify"`
+	Store     *StoreConfig     `json:"store"`
+	Traffic   *TrafficConfig   `json:"traffic"`
+	Inventory *InventoryConfig `json:"inventory"`
+	Printer   *PrinterConfig   `json:"printer"`
+	Reader    *ReaderConfig    `json:"reader"`
+	Motorcade *MotorcadeConfig `json:"motorcade"`
+	Summary   *SummaryConfig   `json:"summary"`
+	Upstream  *UpstreamConfig  `json:"upstream"`
+	Ingest    *IngestConfig    `json:"ingest"`
+	Spool     *SpoolConfig     `json:"spool"`
 }
 
 var (
@@ -12,7 +12,11 @@ var (
 	logger log.LogContextInterface
 )
 
+//init 按./config/seelog.xml配置日志，文件不存在时(如在包目录下运行测试)使用seelog默认的控制台日志
 func init() {
+	if _, err := os.Stat("./config/seelog.xml"); os.IsNotExist(err) {
+		return
+	}
 	logger, err := log.LoggerFromConfigAsFile("./config/seelog.xml")
 	if err != nil {
 		log.Critical("err parsing config log file", err)
@@ -0,0 +1,130 @@
+package h
+
+import (
+	"sync"
+	"time"
+	"tollsys/tollmon/datastruct"
+)
+
+//核心数据合并推送
+//push 接口上报及统计模块产生(如收入)的核心数据按收费站、车道合并，每个WebSocket.Interval周期每个收费站推送一条增量消息，
+//同一车道同一数据项在周期内多次上报时仅推送最新值
+
+//MSGTYPE_CoreDataDelta 核心数据增量消息的消息类别
+const MSGTYPE_CoreDataDelta = 0
+
+//coreDataDelta 收费站核心数据增量消息
+//MsgStation 为收费站节点；MsgContent 为车道节点 -> 核心数据项 -> 最新值；MsgTime 为周期内最后上报时间
+//types 为核心数据项对应的消息类别，用于按订阅条件过滤，不下发
+type coreDataDelta struct {
+	MsgCatalog int
+	MsgType    int
+	MsgTime    string
+	MsgStation string
+	MsgContent map[string]map[string]interface{}
+	types      map[string]int
+}
+
+func newCoreDataDelta(stationID string) *coreDataDelta {
+	return &coreDataDelta{
+		MsgCatalog: datastruct.MSGCATALOG_CoreData,
+		MsgType:    MSGTYPE_CoreDataDelta,
+		MsgStation: stationID,
+		MsgContent: make(map[string]map[string]interface{}),
+		types:      make(map[string]int),
+	}
+}
+
+//coalescer 待推送的核心数据
+type coalescer struct {
+	lock    *sync.Mutex
+	pending map[string]*coreDataDelta
+}
+
+var coreDataCoalescer = &coalescer{
+	lock:    &sync.Mutex{},
+	pending: make(map[string]*coreDataDelta),
+}
+
+//add 合并一项核心数据，laneID为车道节点
+func (c *coalescer) add(laneID string, metric string, msgType int, value interface{}, mTime string) {
+	stationID := laneID[0:16]
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	d, ok := c.pending[stationID]
+	if !ok {
+		d = newCoreDataDelta(stationID)
+		c.pending[stationID] = d
+	}
+	lane, ok := d.MsgContent[laneID]
+	if !ok {
+		lane = make(map[string]interface{})
+		d.MsgContent[laneID] = lane
+	}
+	lane[metric] = value
+	d.types[metric] = msgType
+	if mTime > d.MsgTime {
+		d.MsgTime = mTime
+	}
+}
+
+//PushCoreData 提交统计模块产生的一项核心数据，按车道合并后周期推送
+func PushCoreData(laneID string, metric string, msgType int, value interface{}, mTime string) {
+	coreDataCoalescer.add(laneID, metric, msgType, value, mTime)
+}
+
+//flush 推送各收费站合并后的核心数据
+func (c *coalescer) flush() {
+	c.lock.Lock()
+	pending := c.pending
+	c.pending = make(map[string]*coreDataDelta)
+	c.lock.Unlock()
+	for stationID, d := range pending {
+		PushRealData(stationID, d)
+	}
+}
+
+//coalesceCoreData 按WebSocket.Interval周期推送合并后的核心数据
+func coalesceCoreData() {
+	ticker := time.NewTicker(interval())
+	defer ticker.Stop()
+	for range ticker.C {
+		coreDataCoalescer.flush()
+	}
+}
+
+//filter 按订阅条件过滤增量消息中的车道及数据项，全部满足时返回原消息，均不满足时返回nil
+func (d *coreDataDelta) filter(s *subscription) *coreDataDelta {
+	if !s.catalogs.match(d.MsgCatalog) {
+		return nil
+	}
+	f := newCoreDataDelta(d.MsgStation)
+	f.MsgTime = d.MsgTime
+	f.types = d.types
+	all := true
+	for laneID, lane := range d.MsgContent {
+		if !s.matchNode(d.MsgStation, laneID) {
+			all = false
+			continue
+		}
+		values := make(map[string]interface{}, len(lane))
+		for metric, v := range lane {
+			if s.types.match(d.types[metric]) {
+				values[metric] = v
+			}
+		}
+		if len(values) != len(lane) {
+			all = false
+		}
+		if len(values) != 0 {
+			f.MsgContent[laneID] = values
+		}
+	}
+	if all {
+		return d
+	}
+	if len(f.MsgContent) == 0 {
+		return nil
+	}
+	return f
+}
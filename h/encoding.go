@@ -0,0 +1,55 @@
+package h
+
+import (
+	"tollsys/tollmon/g"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/vmihailenco/msgpack"
+)
+
+//WebSocket消息编码
+//客户端可通过子协议(Sec-WebSocket-Protocol: msgpack)或连接参数encoding=msgpack选择MessagePack编码，缺省为JSON；
+//MessagePack按json标签编码字段，内容与JSON一致，以二进制帧发送；客户端控制消息可为JSON文本帧或MessagePack二进制帧
+
+const (
+	EncodingJSON    = "json"
+	EncodingMsgpack = "msgpack"
+)
+
+//negotiateEncoding 获取连接协商的编码方式，子协议优先于连接参数
+func negotiateEncoding(c *gin.Context, ws *websocket.Conn) string {
+	if p := ws.Subprotocol(); p != "" {
+		return p
+	}
+	if c.Query("encoding") == EncodingMsgpack {
+		return EncodingMsgpack
+	}
+	return EncodingJSON
+}
+
+//writeMsgpack 以MessagePack编码写入一条二进制消息
+func writeMsgpack(ws *websocket.Conn, i interface{}) error {
+	w, err := ws.NextWriter(websocket.BinaryMessage)
+	if err != nil {
+		return err
+	}
+	err = msgpack.NewEncoder(w).UseJSONTag(true).UseCompactEncoding(true).Encode(i)
+	if err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+//decodeMessage 将客户端消息转换为JSON，二进制帧按MessagePack解码
+func decodeMessage(messageType int, b []byte) ([]byte, error) {
+	if messageType != websocket.BinaryMessage {
+		return b, nil
+	}
+	var v interface{}
+	if err := msgpack.Unmarshal(b, &v); err != nil {
+		return nil, err
+	}
+	return g.Json.Marshal(v)
+}
@@ -0,0 +1,47 @@
+package h
+
+import (
+	"net/http"
+	"tollsys/tollmon/datastruct"
+	"tollsys/tollmon/events"
+
+	"github.com/gin-gonic/gin"
+)
+
+//configEventRoute 报警事件路由配置
+//查询参数：stationId 收费站节点；laneId 车道节点；types 报警类别，多个以逗号分隔；from/to 日期(2006-01-02)，缺省为当日
+func configEventRoute() {
+	//Events GET 查询报警事件
+	v1.GET("/Events", func(c *gin.Context) {
+		q, ok := eventQuery(c)
+		if !ok {
+			return
+		}
+		sender := datastruct.NewCommonMessage()
+		sender.Data = events.GetEvents(q)
+		c.JSON(http.StatusOK, sender)
+	})
+}
+
+//eventQuery 解析报警事件查询参数，失败时直接响应错误
+func eventQuery(c *gin.Context) (events.Query, bool) {
+	q := events.Query{}
+	var err error
+	if q.StationID, err = queryNodeID(c, "stationId", 16); err != nil {
+		responseError(c, http.StatusBadRequest, err.Error())
+		return q, false
+	}
+	if q.LaneID, err = queryNodeID(c, "laneId", 26); err != nil {
+		responseError(c, http.StatusBadRequest, err.Error())
+		return q, false
+	}
+	if q.Types, err = queryInts(c, "types"); err != nil {
+		responseError(c, http.StatusBadRequest, err.Error())
+		return q, false
+	}
+	if q.From, q.To, err = queryDateRange(c); err != nil {
+		responseError(c, http.StatusBadRequest, err.Error())
+		return q, false
+	}
+	return q, true
+}
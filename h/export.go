@@ -0,0 +1,539 @@
+package h
+
+import (
+	"encoding/csv"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"tollsys/tollmon/events"
+	"tollsys/tollmon/g"
+	"tollsys/tollmon/inventory"
+	"tollsys/tollmon/motorcade"
+	"tollsys/tollmon/parameters"
+	"tollsys/tollmon/printer"
+	"tollsys/tollmon/shift"
+	"tollsys/tollmon/timeline"
+
+	"github.com/360EntSecGroup-Skylar/excelize"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/transform"
+)
+
+//导出表格
+//将查询结果转换为以中文列名为表头的表格，按format参数导出为csv或xlsx文件，
+//csv文件按encoding参数编码为gbk或utf-8(带BOM，缺省)
+
+const (
+	sheetName = "Sheet1"
+)
+
+//column 导出列，Key 为记录的json字段，嵌套字段以"."分隔；Title 为中文列名
+type column struct {
+	Key   string
+	Title string
+}
+
+//table 导出表格，Rows 为记录转换的json对象
+type table struct {
+	Name    string
+	Columns []column
+	Rows    []map[string]interface{}
+}
+
+//exportBuilder 根据查询参数生成导出表格，失败时已响应错误
+type exportBuilder func(c *gin.Context) (table, bool)
+
+var (
+	//contentTitles 报警消息内容字段的中文名称
+	contentTitles = map[string]string{
+		"Shift":        "班次",
+		"EmpID":        "工号",
+		"EmpName":      "姓名",
+		"EnClass":      "入口车型",
+		"ExClass":      "出口车型",
+		"ExPreClass":   "出口原车型",
+		"Class":        "车型",
+		"EnType":       "入口车种",
+		"ExType":       "出口车种",
+		"Type":         "车种",
+		"CardType":     "卡类型",
+		"Threshold":    "阈值",
+		"Current":      "当前存量",
+		"OrigMode":     "原模式",
+		"CurrMode":     "当前模式",
+		"PrintTimes":   "打印次数",
+		"PrintNoteNo":  "票号",
+		"Flow":         "车辆数",
+		"Offset":       "偏移量",
+		"Status":       "状态",
+		"Pass":         "通行费",
+		"Loan":         "借款",
+		"Forfeit":      "罚款",
+		"ETCCar":       "ETC车辆",
+		"onDutyTime":   "上班时间",
+		"OffDutyTime":  "下班时间",
+		"Kind":         "类别",
+		"Minutes":      "预计分钟数",
+		"Reprints":     "重打次数",
+		"Exits":        "出口流量",
+		"Rate":         "比率(%)",
+		"Traffic":      "流量",
+		"Failures":     "失败次数",
+		"Faults":       "故障次数",
+		"Score":        "健康度",
+		"Reason":       "原因",
+		"StartTime":    "开始时间",
+		"Duration":     "持续秒数",
+		"ETCErrorNote": "ETC异常说明",
+	}
+	//contentOrder 报警消息内容字段的导出顺序，未列出的字段按名称排序置于最后
+	contentOrder = []string{
+		"Shift", "EmpID", "EmpName",
+		"EnClass", "ExPreClass", "ExClass", "Class", "EnType", "ExType", "Type", "CardType",
+		"Kind", "Threshold", "Current", "Minutes",
+		"OrigMode", "CurrMode", "PrintTimes", "PrintNoteNo", "Reprints", "Exits",
+		"Traffic", "Failures", "Faults", "Rate", "Score",
+		"Reason", "StartTime", "Duration", "Flow",
+		"Pass", "Loan", "Forfeit", "ETCCar", "ETCErrorNote",
+		"Offset", "Status", "onDutyTime", "OffDutyTime",
+	}
+
+	shiftColumns = []column{
+		{"laneID", "车道编码"}, {"laneName", "车道名称"}, {"shift", "班次"}, {"empID", "工号"}, {"empName", "姓名"},
+		{"onDutyTime", "上班时间"}, {"offDutyTime", "下班时间"}, {"open", "当班中"}, {"inferred", "推断班次"},
+		{"entryCount", "入口流量"}, {"exitCount", "出口流量"}, {"pass", "通行费"}, {"loan", "借款"}, {"forfeit", "罚款"},
+		{"alertCount", "报警次数"},
+	}
+	shiftReportColumns = []column{
+		{"shifts", "班次数"}, {"dutySeconds", "在岗秒数"},
+		{"entryCount", "入口流量"}, {"exitCount", "出口流量"}, {"pass", "通行费"}, {"loan", "借款"}, {"forfeit", "罚款"},
+		{"alertCount", "报警次数"},
+	}
+	slaColumns = []column{
+		{"kind", "类别"}, {"nodeID", "节点编码"}, {"nodeName", "节点名称"}, {"lanes", "车道数"},
+		{"availability", "可用率(%)"}, {"outages", "中断次数"}, {"mttr", "平均恢复秒数"},
+		{"uptime", "可用秒数"}, {"downtime", "不可用秒数"}, {"known", "有记录秒数"},
+	}
+
+	exportBuilders = map[string]exportBuilder{
+		"events":            eventTable,
+		"shifts":            shiftTable,
+		"shiftLanes":        shiftLaneTable,
+		"shiftEmployees":    shiftEmployeeTable,
+		"motorcades":        motorcadeTable,
+		"availability":      availabilityTable,
+		"dailyAvailability": dailyAvailabilityTable,
+		"reprints":          reprintTable,
+		"inventory":         inventoryTable,
+	}
+)
+
+//configExportRoute 导出路由配置
+//查询参数与对应的查询接口相同；format=csv|xlsx 文件格式，缺省为csv；encoding=gbk|utf-8 csv文件编码，缺省为utf-8
+func configExportRoute() {
+	//Export/:name GET 导出表格
+	//name: events 报警事件；shifts 班次记录；shiftLanes/shiftEmployees 按车道/员工汇总班次；motorcades 车队放行；
+	//availability 可用率报表；dailyAvailability 车道每日可用率；reprints 票据重打；inventory 通行卡存量记录
+	v1.GET("/Export/:name", func(c *gin.Context) {
+		build, ok := exportBuilders[c.Param("name")]
+		if !ok {
+			responseError(c, http.StatusNotFound, "unknown export "+c.Param("name"))
+			return
+		}
+		if t, ok := build(c); ok {
+			responseTable(c, t)
+		}
+	})
+}
+
+//responseTable 按format、encoding参数以文件返回表格
+func responseTable(c *gin.Context, t table) {
+	format := c.DefaultQuery("format", "csv")
+	gbk := false
+	switch strings.ToLower(c.DefaultQuery("encoding", "utf-8")) {
+	case "gbk":
+		gbk = true
+	case "utf-8", "utf8":
+	default:
+		responseError(c, http.StatusBadRequest, "invalid encoding "+c.Query("encoding"))
+		return
+	}
+	var err error
+	switch format {
+	case "csv":
+		charset := "utf-8"
+		if gbk {
+			charset = "gbk"
+		}
+		c.Header("Content-Disposition", "attachment; filename="+t.Name+".csv")
+		c.Header("Content-Type", "text/csv; charset="+charset)
+		c.Status(http.StatusOK)
+		err = writeCSV(c.Writer, t, gbk)
+	case "xlsx":
+		c.Header("Content-Disposition", "attachment; filename="+t.Name+".xlsx")
+		c.Header("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+		c.Status(http.StatusOK)
+		err = writeXLSX(c.Writer, t)
+	default:
+		responseError(c, http.StatusBadRequest, "invalid format "+format)
+		return
+	}
+	if err != nil {
+		g.LogError("export err:", t.Name, err.Error())
+	}
+}
+
+//writeCSV 写入csv文件，gbk编码时无法编码的字符以替换字符代替，utf-8编码时写入BOM以便Excel识别
+func writeCSV(w io.Writer, t table, gbk bool) error {
+	if !gbk {
+		if _, err := w.Write([]byte("\xEF\xBB\xBF")); err != nil {
+			return err
+		}
+		return writeRecords(w, t)
+	}
+	tw := transform.NewWriter(w, encoding.ReplaceUnsupported(simplifiedchinese.GBK.NewEncoder()))
+	if err := writeRecords(tw, t); err != nil {
+		tw.Close()
+		return err
+	}
+	return tw.Close()
+}
+
+func writeRecords(w io.Writer, t table) error {
+	cw := csv.NewWriter(w)
+	header := make([]string, 0, len(t.Columns))
+	for _, col := range t.Columns {
+		header = append(header, col.Title)
+	}
+	cw.Write(header)
+	for _, row := range t.Rows {
+		record := make([]string, 0, len(t.Columns))
+		for _, col := range t.Columns {
+			record = append(record, cellString(lookup(row, col.Key)))
+		}
+		cw.Write(record)
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+//writeXLSX 写入xlsx文件，数值保留为数值单元格
+func writeXLSX(w io.Writer, t table) error {
+	f := excelize.NewFile()
+	for i, col := range t.Columns {
+		f.SetCellStr(sheetName, excelize.ToAlphaString(i)+"1", col.Title)
+	}
+	for r, row := range t.Rows {
+		axis := strconv.Itoa(r + 2)
+		for i, col := range t.Columns {
+			v := lookup(row, col.Key)
+			if n, ok := v.(float64); ok {
+				f.SetCellValue(sheetName, excelize.ToAlphaString(i)+axis, n)
+			} else {
+				f.SetCellStr(sheetName, excelize.ToAlphaString(i)+axis, cellString(v))
+			}
+		}
+	}
+	return f.Write(w)
+}
+
+//lookup 获取记录字段值，嵌套字段以"."分隔
+func lookup(row map[string]interface{}, key string) interface{} {
+	if v, ok := row[key]; ok {
+		return v
+	}
+	if i := strings.Index(key, "."); i > 0 {
+		if m, ok := row[key[0:i]].(map[string]interface{}); ok {
+			return lookup(m, key[i+1:])
+		}
+	}
+	return nil
+}
+
+func cellString(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		if v {
+			return "是"
+		}
+		return "否"
+	default:
+		b, _ := g.Json.Marshal(v)
+		return string(b)
+	}
+}
+
+//toRows 将查询结果转换为json对象列表
+func toRows(v interface{}) ([]map[string]interface{}, error) {
+	rows := make([]map[string]interface{}, 0)
+	b, err := g.Json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if err := g.Json.Unmarshal(b, &rows); err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+//newTable 生成导出表格，转换失败时响应错误
+func newTable(c *gin.Context, name string, columns []column, v interface{}) (table, bool) {
+	rows, err := toRows(v)
+	if err != nil {
+		responseError(c, http.StatusInternalServerError, err.Error())
+		return table{}, false
+	}
+	return table{Name: name, Columns: columns, Rows: rows}, true
+}
+
+func dateName(name string, from time.Time, to time.Time) string {
+	return name + "_" + from.Format("20060102") + "_" + to.Format("20060102")
+}
+
+//alertColumns 各报警类别次数列，列名为报警策略中的报警描述
+func alertColumns(rows []map[string]interface{}) []column {
+	types := make([]int, 0)
+	seen := make(map[string]bool)
+	for _, row := range rows {
+		m, _ := row["alerts"].(map[string]interface{})
+		for k := range m {
+			if t, err := strconv.Atoi(k); err == nil && !seen[k] {
+				seen[k] = true
+				types = append(types, t)
+			}
+		}
+	}
+	sort.Ints(types)
+	list := make([]column, 0, len(types))
+	for _, t := range types {
+		list = append(list, column{Key: "alerts." + strconv.Itoa(t), Title: typeTitle(t)})
+	}
+	return list
+}
+
+//typeTitle 报警类别名称，无报警策略时为类别编号
+func typeTitle(t int) string {
+	if item, ok := parameters.GetTypeToStrategyItems()[t]; ok && item.Description != "" {
+		return item.Description
+	}
+	return "报警" + strconv.Itoa(t)
+}
+
+//contentColumns 报警消息内容字段列，按contentOrder排序
+func contentColumns(rows []map[string]interface{}) []column {
+	keys := make(map[string]bool)
+	for _, row := range rows {
+		m, _ := row["content"].(map[string]interface{})
+		for k := range m {
+			keys[k] = true
+		}
+	}
+	list := make([]column, 0, len(keys))
+	for _, k := range contentOrder {
+		if keys[k] {
+			list = append(list, column{Key: "content." + k, Title: contentTitles[k]})
+			delete(keys, k)
+		}
+	}
+	others := make([]string, 0, len(keys))
+	for k := range keys {
+		others = append(others, k)
+	}
+	sort.Strings(others)
+	for _, k := range others {
+		list = append(list, column{Key: "content." + k, Title: k})
+	}
+	return list
+}
+
+func eventTable(c *gin.Context) (table, bool) {
+	q, ok := eventQuery(c)
+	if !ok {
+		return table{}, false
+	}
+	list := events.GetEvents(q)
+	for i := range list {
+		if list[i].Description == "" {
+			list[i].Description = typeTitle(list[i].Type)
+		}
+	}
+	columns := []column{
+		{"time", "报警时间"}, {"laneID", "车道编码"}, {"laneName", "车道名称"},
+		{"type", "报警类别"}, {"description", "报警描述"}, {"level", "报警级别"},
+	}
+	t, ok := newTable(c, dateName("events", q.From, q.To), columns, list)
+	if ok {
+		t.Columns = append(t.Columns, contentColumns(t.Rows)...)
+	}
+	return t, ok
+}
+
+func shiftTable(c *gin.Context) (table, bool) {
+	q, ok := shiftQuery(c)
+	if !ok {
+		return table{}, false
+	}
+	t, ok := newTable(c, dateName("shifts", q.From, q.To), shiftColumns, shift.GetShifts(q))
+	if ok {
+		t.Columns = append(append(make([]column, 0), t.Columns...), alertColumns(t.Rows)...)
+	}
+	return t, ok
+}
+
+func shiftLaneTable(c *gin.Context) (table, bool) {
+	q, ok := shiftQuery(c)
+	if !ok {
+		return table{}, false
+	}
+	columns := append([]column{{"key", "车道编码"}, {"name", "车道名称"}}, shiftReportColumns...)
+	t, ok := newTable(c, dateName("shift_lanes", q.From, q.To), columns, shift.GetLaneReports(q))
+	if ok {
+		t.Columns = append(t.Columns, alertColumns(t.Rows)...)
+	}
+	return t, ok
+}
+
+func shiftEmployeeTable(c *gin.Context) (table, bool) {
+	q, ok := shiftQuery(c)
+	if !ok {
+		return table{}, false
+	}
+	columns := append([]column{{"key", "工号"}, {"name", "姓名"}}, shiftReportColumns...)
+	t, ok := newTable(c, dateName("shift_employees", q.From, q.To), columns, shift.GetEmployeeReports(q))
+	if ok {
+		t.Columns = append(t.Columns, alertColumns(t.Rows)...)
+	}
+	return t, ok
+}
+
+func motorcadeTable(c *gin.Context) (table, bool) {
+	q := motorcade.Query{OpenOnly: c.Query("open") == "true"}
+	var err error
+	if q.StationID, err = queryNodeID(c, "stationId", 16); err != nil {
+		responseError(c, http.StatusBadRequest, err.Error())
+		return table{}, false
+	}
+	if q.LaneID, err = queryNodeID(c, "laneId", 26); err != nil {
+		responseError(c, http.StatusBadRequest, err.Error())
+		return table{}, false
+	}
+	if q.From, q.To, err = queryDateRange(c); err != nil {
+		responseError(c, http.StatusBadRequest, err.Error())
+		return table{}, false
+	}
+	columns := []column{
+		{"laneID", "车道编码"}, {"laneName", "车道名称"}, {"shift", "班次"}, {"empID", "工号"}, {"empName", "姓名"},
+		{"startTime", "开始时间"}, {"endTime", "结束时间"}, {"duration", "持续秒数"}, {"flow", "车辆数"},
+		{"open", "进行中"}, {"noEnd", "无结束"}, {"noStart", "无开始"}, {"overdue", "超时"}, {"exceedFlow", "车辆数超限"},
+	}
+	return newTable(c, dateName("motorcades", q.From, q.To), columns, motorcade.GetSessions(q))
+}
+
+func availabilityTable(c *gin.Context) (table, bool) {
+	lanes, err := queryLanes(c)
+	if err != nil {
+		responseError(c, http.StatusBadRequest, err.Error())
+		return table{}, false
+	}
+	from, to, err := queryReportRange(c)
+	if err != nil {
+		responseError(c, http.StatusBadRequest, err.Error())
+		return table{}, false
+	}
+	r := timeline.GetSLA(lanes, from, to)
+	t, ok := newTable(c, dateName("availability", from, to), slaColumns, append(r.Stations, r.Lanes...))
+	if !ok {
+		return t, false
+	}
+	for i, row := range t.Rows {
+		row["kind"] = "车道"
+		if i < len(r.Stations) {
+			row["kind"] = "收费站"
+		}
+	}
+	return t, true
+}
+
+func dailyAvailabilityTable(c *gin.Context) (table, bool) {
+	lanes, err := queryLanes(c)
+	if err != nil {
+		responseError(c, http.StatusBadRequest, err.Error())
+		return table{}, false
+	}
+	from, to, err := queryDateRange(c)
+	if err != nil {
+		responseError(c, http.StatusBadRequest, err.Error())
+		return table{}, false
+	}
+	columns := []column{
+		{"date", "日期"}, {"laneID", "车道编码"}, {"laneName", "车道名称"}, {"known", "有记录秒数"},
+		{"connected", "通讯连接率(%)"}, {"open", "开启率(%)"}, {"available", "可用率(%)"},
+	}
+	return newTable(c, dateName("daily_availability", from, to), columns, timeline.GetAvailability(lanes, from, to))
+}
+
+func reprintTable(c *gin.Context) (table, bool) {
+	lanes, err := queryLanes(c)
+	if err != nil {
+		responseError(c, http.StatusBadRequest, err.Error())
+		return table{}, false
+	}
+	from, to, err := queryDateRange(c)
+	if err != nil {
+		responseError(c, http.StatusBadRequest, err.Error())
+		return table{}, false
+	}
+	columns := []column{
+		{"time", "时间"}, {"laneID", "车道编码"}, {"laneName", "车道名称"}, {"shift", "班次"}, {"empID", "工号"},
+		{"printTimes", "打印次数"}, {"printNoteNo", "票号"},
+	}
+	t, ok := newTable(c, dateName("reprints", from, to), columns, printer.GetReprints(lanes, from, to, c.Query("printNoteNo")))
+	if ok {
+		fillLaneNames(t.Rows)
+	}
+	return t, ok
+}
+
+func inventoryTable(c *gin.Context) (table, bool) {
+	lanes, err := queryLanes(c)
+	if err != nil {
+		responseError(c, http.StatusBadRequest, err.Error())
+		return table{}, false
+	}
+	from, to, err := queryDateRange(c)
+	if err != nil {
+		responseError(c, http.StatusBadRequest, err.Error())
+		return table{}, false
+	}
+	columns := []column{
+		{"updateTime", "时间"}, {"laneID", "车道编码"}, {"laneName", "车道名称"}, {"kind", "类别"},
+		{"threshold", "阈值"}, {"current", "存量"}, {"source", "来源"},
+	}
+	t, ok := newTable(c, dateName("inventory", from, to), columns, inventory.GetHistory(lanes, from, to))
+	if ok {
+		fillLaneNames(t.Rows)
+	}
+	return t, ok
+}
+
+//fillLaneNames 为不含车道名称的记录补充车道名称
+func fillLaneNames(rows []map[string]interface{}) {
+	for _, row := range rows {
+		if id, ok := row["laneID"].(string); ok {
+			if node, ok := parameters.GetNodeByID(id); ok {
+				row["laneName"] = node.NodeName
+			}
+		}
+	}
+}
@@ -56,10 +56,36 @@ func configRoutes() {
 	configStrategyItemsRoute()
 	configPushHandle()
 	configCoreDataRoute()
+	configStrategyProfileRoute()
+	configShiftRoute()
+	configTrafficRoute()
+	configRevenueRoute()
+	configInventoryRoute()
+	configPrinterRoute()
+	configReaderRoute()
+	configMotorcadeRoute()
+	configStaffingRoute()
+	configTimelineRoute()
+	configReportRoute()
+	configEventRoute()
+	configExportRoute()
+	configSummaryRoute()
+	configStreamRoute()
+	configIngestRoute()
+	configSpoolRoute()
 }
 
-//以goroutine启动http和webSocket服务器
+//以goroutine启动http和webSocket服务器、核心数据合并推送、多实例转发订阅及上级转发
 func Start() {
+	go coalesceCoreData()
+	if g.Config().Redis.PubSub {
+		go relayPublisher()
+		go subscribeRelay()
+		go relayStateSync()
+	}
+	if c := g.Config().Upstream; c != nil && c.Enabled {
+		go runUpstream()
+	}
 	go func() {
 		g.LogInfo("HTTP Server Run At ", g.Config().Http.Listen)
 		err := router.Run(g.Config().Http.Listen)
@@ -77,8 +103,6 @@ func Start() {
 			os.Exit(1)
 		}
 	}()
-	go webSocketHeartServ()
-	//go StartRealData()
 }
 
 //中间件
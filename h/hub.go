@@ -0,0 +1,240 @@
+package h
+
+import (
+	"sync"
+	"time"
+	"tollsys/tollmon/datastruct"
+	"tollsys/tollmon/g"
+
+	"github.com/gorilla/websocket"
+)
+
+//实时数据客户端注册表
+//hub 负责客户端注册、注销及实时数据分发；每个客户端拥有独立的发送队列及发送goroutine，
+//分发时仅写入发送队列，不阻塞车道消息处理；队列满时按slowPolicy丢弃数据或断开客户端。
+//客户端可通过WebSocket或SSE连接，由transport完成实际发送
+
+const (
+	PolicyDrop       = "drop"
+	PolicyDisconnect = "disconnect"
+
+	defaultQueueSize = 1000
+	writeWait        = 10 * time.Second
+	readWaitTimes    = 3
+)
+
+//interval 心跳间隔及核心数据合并推送周期，未配置时为1秒
+func interval() time.Duration {
+	d := time.Duration(g.Config().WebSocket.Interval) * time.Second
+	if d <= 0 {
+		d = time.Second
+	}
+	return d
+}
+
+//transport 客户端连接的发送方式
+//write 发送一条数据；heartbeat 发送心跳；Close 关闭连接
+type transport interface {
+	write(i interface{}) error
+	heartbeat() error
+	Close() error
+}
+
+//wsTransport WebSocket连接，encoding 为协商的消息编码
+type wsTransport struct {
+	conn     *websocket.Conn
+	encoding string
+}
+
+//write 按协商的编码写入一条数据，超过writeWait未完成视为连接失效
+func (t *wsTransport) write(i interface{}) error {
+	t.conn.SetWriteDeadline(time.Now().Add(writeWait))
+	if t.encoding == EncodingMsgpack {
+		return writeMsgpack(t.conn, i)
+	}
+	return t.conn.WriteJSON(i)
+}
+
+//heartbeat 发送ping控制帧，客户端回复pong后延长读超时，不占用数据通道
+func (t *wsTransport) heartbeat() error {
+	return t.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeWait))
+}
+
+//Close 发送close控制帧后关闭连接，可与其它写操作并发调用，写操作未完成时最长等待writeWait
+func (t *wsTransport) Close() error {
+	msg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+	t.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(writeWait))
+	return t.conn.Close()
+}
+
+//readDeadline 设置读超时，readWaitTimes个心跳周期内未收到客户端消息或pong视为连接失效
+func (t *wsTransport) readDeadline() {
+	t.conn.SetReadDeadline(time.Now().Add(readWaitTimes * interval()))
+}
+
+//realtimeClient 实时数据客户端
+//sub 为订阅条件；strategyItems 为报警策略，均由lock保护；operator 为连接时的操作员；addr 为客户端地址
+type realtimeClient struct {
+	lock          *sync.RWMutex
+	client        transport
+	addr          string
+	operator      string
+	sub           subscription
+	strategyItems map[int]datastruct.StrategyItem
+	queue         chan interface{}
+	done          chan struct{}
+	once          sync.Once
+	dropped       int64
+}
+
+func newRealtimeClient(t transport, addr string) *realtimeClient {
+	size := defaultQueueSize
+	if g.Config().WebSocket.QueueSize > 0 {
+		size = g.Config().WebSocket.QueueSize
+	}
+	return &realtimeClient{
+		lock:          &sync.RWMutex{},
+		client:        t,
+		addr:          addr,
+		sub:           newSubscription(),
+		strategyItems: make(map[int]datastruct.StrategyItem),
+		queue:         make(chan interface{}, size),
+		done:          make(chan struct{}),
+	}
+}
+
+//enqueue 写入发送队列，队列满时按slowPolicy处理，返回是否写入成功
+func (w *realtimeClient) enqueue(i interface{}) bool {
+	select {
+	case <-w.done:
+		return false
+	default:
+	}
+	select {
+	case w.queue <- i:
+		return true
+	default:
+	}
+	w.lock.Lock()
+	w.dropped++
+	dropped := w.dropped
+	w.lock.Unlock()
+	if g.Config().WebSocket.SlowPolicy == PolicyDisconnect {
+		g.LogError("realtime:", w.addr, " send queue full, disconnect")
+		w.close()
+		return false
+	}
+	if dropped%100 == 1 {
+		g.LogError("realtime:", w.addr, " send queue full, dropped:", dropped)
+	}
+	return false
+}
+
+//writePump 客户端发送goroutine，发送队列中的数据并按配置间隔发送心跳
+//发送失败或客户端关闭时退出
+func (w *realtimeClient) writePump() {
+	ticker := time.NewTicker(interval())
+	defer ticker.Stop()
+	defer w.close()
+	for {
+		select {
+		case <-w.done:
+			return
+		case d := <-w.queue:
+			if err := w.client.write(d); err != nil {
+				g.LogError("realtime:", w.addr, " write data err:", err.Error())
+				return
+			}
+		case <-ticker.C:
+			if err := w.client.heartbeat(); err != nil {
+				g.LogDebug("heart beat err:", err.Error())
+				return
+			}
+		}
+	}
+}
+
+//close 注销客户端并停止发送，可重复调用
+//可能在分发实时数据时由enqueue调用，close握手可能等待发送goroutine的写操作，以goroutine关闭连接，不阻塞分发
+func (w *realtimeClient) close() {
+	w.once.Do(func() {
+		close(w.done)
+		wsHub.unregister(w)
+		go w.client.Close()
+		g.LogDebug("realtime", w.addr, "已终止")
+	})
+}
+
+//hub 实时数据客户端注册表
+//clients 为客户端 -> 订阅涉及的收费站；index 为收费站 -> 订阅该收费站或其广场、车道的客户端，分发时仅遍历该收费站的客户端
+type hub struct {
+	lock    *sync.RWMutex
+	clients map[*realtimeClient][]string
+	index   map[string]map[*realtimeClient]bool
+}
+
+var wsHub = &hub{
+	lock:    &sync.RWMutex{},
+	clients: make(map[*realtimeClient][]string),
+	index:   make(map[string]map[*realtimeClient]bool),
+}
+
+//reindex 注册客户端或订阅条件变更后更新收费站索引，客户端已注销时不处理
+func (h *hub) reindex(w *realtimeClient) {
+	w.lock.RLock()
+	ids := w.sub.stationKeys()
+	w.lock.RUnlock()
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	select {
+	case <-w.done:
+		return
+	default:
+	}
+	h.remove(w)
+	h.clients[w] = ids
+	for _, id := range ids {
+		m, ok := h.index[id]
+		if !ok {
+			m = make(map[*realtimeClient]bool)
+			h.index[id] = m
+		}
+		m[w] = true
+	}
+}
+
+func (h *hub) unregister(w *realtimeClient) {
+	h.lock.Lock()
+	h.remove(w)
+	h.lock.Unlock()
+}
+
+//remove 调用方需持有lock
+func (h *hub) remove(w *realtimeClient) {
+	for _, id := range h.clients[w] {
+		delete(h.index[id], w)
+		if len(h.index[id]) == 0 {
+			delete(h.index, id)
+		}
+	}
+	delete(h.clients, w)
+}
+
+//subscribers 获取订阅该收费站的客户端列表副本，分发时不持有注册表锁
+func (h *hub) subscribers(stationID string) []*realtimeClient {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	m := h.index[stationID]
+	list := make([]*realtimeClient, 0, len(m))
+	for w := range m {
+		list = append(list, w)
+	}
+	return list
+}
+
+//count 当前客户端数
+func (h *hub) count() int {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	return len(h.clients)
+}
@@ -63,7 +63,7 @@ func configStrategyItemsRoute() {
 			}
 		}
 		if items == nil || len(items) == 0 {
-			for _, item := range parameters.GetStrategyItems() {
+			for _, item := range sessionStrategyProfile(Manager.GetSession(c)).Items {
 				items = append(items, item)
 			}
 		}
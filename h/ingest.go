@@ -0,0 +1,127 @@
+package h
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+	"time"
+	"tollsys/tollmon/datastruct"
+	"tollsys/tollmon/g"
+	"tollsys/tollmon/parameters"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+//下级接入
+//ingest.enabled 时本实例作为上级，接收下级tollmon转发的节点树、实时数据及LaneInfo/CoreData(见upstream.go)：
+//节点树合并至本地节点树，LaneInfo/CoreData更新至合并的车道，实时数据分发至本地客户端，并按配置继续转发至其它实例及上级。
+//报警通知由下级发送，上级不重复发送
+
+//configIngestRoute 下级接入路由配置
+func configIngestRoute() {
+	//ingest GET 下级tollmon以WebSocket接入，需在Authorization头中携带ingest.tokens中的令牌(Bearer)
+	v1.GET("/ingest", ingestHandle)
+}
+
+//ingestAuthorized 校验下级认证令牌
+func ingestAuthorized(c *gin.Context) bool {
+	cfg := g.Config().Ingest
+	if cfg == nil || !cfg.Enabled {
+		return false
+	}
+	token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	for _, t := range cfg.Tokens {
+		if t != "" && subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+//ingestHandle 下级连接处理，当前goroutine持续读取下级转发的数据直至连接断开或超时
+func ingestHandle(c *gin.Context) {
+	if !ingestAuthorized(c) {
+		g.LogError(c.Request.RemoteAddr, " ingest unauthorized")
+		responseError(c, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	ws, err := upGrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		g.LogError("ingest upgrade err:", err.Error())
+		return
+	}
+	t := &wsTransport{conn: ws, encoding: EncodingJSON}
+	defer t.Close()
+	addr := ws.RemoteAddr().String()
+	g.LogInfo(addr, " ingest connected")
+	t.readDeadline()
+	ws.SetPingHandler(func(s string) error {
+		t.readDeadline()
+		return ws.WriteControl(websocket.PongMessage, []byte(s), time.Now().Add(writeWait))
+	})
+	for {
+		_, b, err := ws.ReadMessage()
+		if err != nil {
+			g.LogError(addr, " ingest read err:", err.Error())
+			return
+		}
+		t.readDeadline()
+		ingest(addr, b)
+	}
+}
+
+//ingest 处理一条下级转发的消息
+func ingest(addr string, b []byte) {
+	m := relayMessage{}
+	if err := g.Json.Unmarshal(b, &m); err != nil || len(m.StationID) < 16 {
+		g.LogError(addr, " ingest invalid message")
+		return
+	}
+	if m.Kind == relayNodes {
+		var station datastruct.Station
+		if err := g.Json.Unmarshal(m.Data, &station); err != nil {
+			g.LogError(addr, " ingest decode nodes err:", err.Error())
+			return
+		}
+		if n := parameters.MergeStation(station); n > 0 {
+			g.LogInfo(addr, " ingest merge station ", station.Station.NodeID, " nodes:", n)
+		}
+		return
+	}
+	if _, ok := parameters.GetNodeByID(m.StationID); !ok {
+		g.LogError(addr, " ingest unknown station ", m.StationID)
+		return
+	}
+	switch m.Kind {
+	case relayLaneInfo:
+		list := make([]datastruct.LaneInfo, 0)
+		if err := g.Json.Unmarshal(m.Data, &list); err != nil {
+			g.LogError(addr, " ingest decode laneInfo err:", err.Error())
+			return
+		}
+		for _, info := range list {
+			if strings.HasPrefix(info.Node.NodeID, m.StationID) {
+				parameters.SetLaneInfo(info)
+			}
+		}
+	case relayCoreData:
+		list := make([]datastruct.CoreData, 0)
+		if err := g.Json.Unmarshal(m.Data, &list); err != nil {
+			g.LogError(addr, " ingest decode coreData err:", err.Error())
+			return
+		}
+		for _, data := range list {
+			if strings.HasPrefix(data.Node.NodeID, m.StationID) {
+				parameters.SetCoreData(data)
+			}
+		}
+	default:
+		data, err := m.decode()
+		if err != nil {
+			g.LogError(addr, " ingest decode err:", err.Error())
+			return
+		}
+		distribute(m.StationID, data)
+	}
+}
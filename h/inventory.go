@@ -0,0 +1,80 @@
+package h
+
+import (
+	"net/http"
+	"time"
+	"tollsys/tollmon/datastruct"
+	"tollsys/tollmon/g"
+	"tollsys/tollmon/inventory"
+
+	"github.com/gin-gonic/gin"
+)
+
+//configInventoryRoute 通行卡存量路由配置
+//查询参数：stationId/plazaId/laneId 节点，均为空时查询全部车道
+func configInventoryRoute() {
+	//Inventory GET 按收费站查询车道通行卡存量及预测
+	v1.GET("/Inventory", func(c *gin.Context) {
+		lanes, err := queryLanes(c)
+		if err != nil {
+			responseError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		sender := datastruct.NewCommonMessage()
+		sender.Data = inventory.GetStations(lanes, time.Now())
+		c.JSON(http.StatusOK, sender)
+	})
+	//Inventory/History GET 查询车道存量记录，from/to 为日期(2006-01-02)，缺省为当日
+	v1.GET("/Inventory/History", func(c *gin.Context) {
+		lanes, err := queryLanes(c)
+		if err != nil {
+			responseError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		from, to, err := queryDateRange(c)
+		if err != nil {
+			responseError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		sender := datastruct.NewCommonMessage()
+		sender.Data = inventory.GetHistory(lanes, from, to)
+		c.JSON(http.StatusOK, sender)
+	})
+	//Inventory POST 登记车道补卡、清卡后的存量
+	//data: {"laneId":"","kind":"entry|exit","current":0,"threshold":0}
+	v1.POST("/Inventory", func(c *gin.Context) {
+		type Stock struct {
+			LaneID    string `json:"laneId"`
+			Kind      string `json:"kind"`
+			Current   int    `json:"current"`
+			Threshold int    `json:"threshold"`
+		}
+		type Rcvd struct {
+			Code   int    `json:"code"`
+			ErrMsg string `json:"errMsg"`
+			Data   Stock  `json:"data"`
+			Status bool   `json:"status"`
+		}
+		if c.Request.ContentLength == 0 {
+			c.JSON(http.StatusBadRequest, datastruct.ERRORMSG_BlankBody)
+			c.Abort()
+			return
+		}
+		r := Rcvd{}
+		decoder := g.Json.NewDecoder(c.Request.Body)
+		if err := decoder.Decode(&r); err != nil {
+			c.JSON(http.StatusBadRequest, datastruct.ERRORMSG_DecoderError)
+			c.Abort()
+			return
+		}
+		stock, err := inventory.Register(r.Data.LaneID, r.Data.Kind, r.Data.Current, r.Data.Threshold)
+		if err != nil {
+			responseError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		g.LogInfo(c.Request.RemoteAddr, " register inventory ", r.Data.LaneID, " ", r.Data.Kind, " ", r.Data.Current)
+		sender := datastruct.NewCommonMessage()
+		sender.Data = stock
+		c.JSON(http.StatusOK, sender)
+	})
+}
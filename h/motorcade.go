@@ -0,0 +1,34 @@
+package h
+
+import (
+	"net/http"
+	"tollsys/tollmon/datastruct"
+	"tollsys/tollmon/motorcade"
+
+	"github.com/gin-gonic/gin"
+)
+
+//configMotorcadeRoute 车队放行路由配置
+//查询参数：stationId 收费站节点；laneId 车道节点；from/to 开始日期(2006-01-02)，缺省为当日；open=true 仅查询进行中的车队
+func configMotorcadeRoute() {
+	//Motorcades GET 查询车队放行记录
+	v1.GET("/Motorcades", func(c *gin.Context) {
+		q := motorcade.Query{OpenOnly: c.Query("open") == "true"}
+		var err error
+		if q.StationID, err = queryNodeID(c, "stationId", 16); err != nil {
+			responseError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		if q.LaneID, err = queryNodeID(c, "laneId", 26); err != nil {
+			responseError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		if q.From, q.To, err = queryDateRange(c); err != nil {
+			responseError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		sender := datastruct.NewCommonMessage()
+		sender.Data = motorcade.GetSessions(q)
+		c.JSON(http.StatusOK, sender)
+	})
+}
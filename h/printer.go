@@ -0,0 +1,42 @@
+package h
+
+import (
+	"net/http"
+	"time"
+	"tollsys/tollmon/datastruct"
+	"tollsys/tollmon/printer"
+
+	"github.com/gin-gonic/gin"
+)
+
+//configPrinterRoute 票据打印机状态路由配置
+//查询参数：stationId/plazaId/laneId 节点，均为空时查询全部车道
+func configPrinterRoute() {
+	//Printers GET 查询车道当日打印机状态及汇总
+	v1.GET("/Printers", func(c *gin.Context) {
+		lanes, err := queryLanes(c)
+		if err != nil {
+			responseError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		sender := datastruct.NewCommonMessage()
+		sender.Data = printer.GetFleet(lanes, time.Now())
+		c.JSON(http.StatusOK, sender)
+	})
+	//Printers/Reprints GET 查询票据重打记录，from/to 为日期(2006-01-02)，缺省为当日；printNoteNo 票号
+	v1.GET("/Printers/Reprints", func(c *gin.Context) {
+		lanes, err := queryLanes(c)
+		if err != nil {
+			responseError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		from, to, err := queryDateRange(c)
+		if err != nil {
+			responseError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		sender := datastruct.NewCommonMessage()
+		sender.Data = printer.GetReprints(lanes, from, to, c.Query("printNoteNo"))
+		c.JSON(http.StatusOK, sender)
+	})
+}
@@ -0,0 +1,198 @@
+package h
+
+import (
+	"net/http"
+	"tollsys/tollmon/datastruct"
+	"tollsys/tollmon/g"
+	"tollsys/tollmon/parameters"
+
+	"github.com/gin-gonic/gin"
+)
+
+//configStrategyProfileRoute 报警策略方案路由配置
+func configStrategyProfileRoute() {
+	//StrategyProfiles GET 获取全部报警策略方案
+	v1.GET("/StrategyProfiles", func(c *gin.Context) {
+		sender := datastruct.NewCommonMessage()
+		sender.Data = parameters.GetStrategyProfiles()
+		c.JSON(http.StatusOK, sender)
+	})
+	//StrategyProfiles/:name GET 根据名称获取报警策略方案
+	v1.GET("/StrategyProfiles/:name", func(c *gin.Context) {
+		p, ok := parameters.GetStrategyProfile(c.Param("name"))
+		if !ok {
+			responseError(c, http.StatusNotFound, c.Param("name")+" is not exists")
+			return
+		}
+		sender := datastruct.NewCommonMessage()
+		sender.Data = p
+		c.JSON(http.StatusOK, sender)
+	})
+	//StrategyProfiles POST 新建报警策略方案
+	v1.POST("/StrategyProfiles", func(c *gin.Context) {
+		p, ok := decodeStrategyProfile(c)
+		if !ok {
+			return
+		}
+		if _, exists := parameters.GetStrategyProfile(p.Name); exists {
+			responseError(c, http.StatusConflict, p.Name+" already exists")
+			return
+		}
+		saveStrategyProfile(c, p)
+	})
+	//StrategyProfiles/:name PUT 更新报警策略方案
+	v1.PUT("/StrategyProfiles/:name", func(c *gin.Context) {
+		p, ok := decodeStrategyProfile(c)
+		if !ok {
+			return
+		}
+		p.Name = c.Param("name")
+		saveStrategyProfile(c, p)
+	})
+	//StrategyProfiles/:name DELETE 删除报警策略方案
+	v1.DELETE("/StrategyProfiles/:name", func(c *gin.Context) {
+		if err := parameters.DeleteStrategyProfile(c.Param("name")); err != nil {
+			responseError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		g.LogInfo(c.Request.RemoteAddr, " delete strategy profile ", c.Param("name"))
+		sender := datastruct.NewCommonMessage()
+		sender.Data = true
+		c.JSON(http.StatusOK, sender)
+	})
+
+	//StrategyProfile GET 获取当前session适用的报警策略方案
+	v1.GET("/StrategyProfile", requestNilMiddleWare(), sessionMiddleWare(), func(c *gin.Context) {
+		sender := datastruct.NewCommonMessage()
+		sender.Data = sessionStrategyProfile(Manager.GetSession(c))
+		c.JSON(http.StatusOK, sender)
+	})
+	//StrategyProfile POST 为当前session选择报警策略方案，data为方案名称，空字符串取消选择
+	v1.POST("/StrategyProfile", sessionMiddleWare(), func(c *gin.Context) {
+		name, ok := decodeStringData(c)
+		if !ok {
+			return
+		}
+		if _, exists := parameters.GetStrategyProfile(name); name != "" && !exists {
+			responseError(c, http.StatusBadRequest, name+" is not exists")
+			return
+		}
+		Manager.Update(c, datastruct.KEY_StrategyProfile, name)
+		g.LogInfo(c.Request.RemoteAddr, " select strategy profile ", name)
+		sender := datastruct.NewCommonMessage()
+		sender.Data = true
+		c.JSON(http.StatusOK, sender)
+	})
+
+	//Operator GET 获取当前session登记的操作员
+	v1.GET("/Operator", requestNilMiddleWare(), sessionMiddleWare(), func(c *gin.Context) {
+		sender := datastruct.NewCommonMessage()
+		sender.Data = sessionString(Manager.GetSession(c), datastruct.KEY_Operator)
+		c.JSON(http.StatusOK, sender)
+	})
+	//Operator POST 登记当前session的操作员，用于匹配指定给操作员的报警策略方案
+	v1.POST("/Operator", sessionMiddleWare(), func(c *gin.Context) {
+		name, ok := decodeStringData(c)
+		if !ok {
+			return
+		}
+		Manager.Update(c, datastruct.KEY_Operator, name)
+		g.LogInfo(c.Request.RemoteAddr, " operator ", name)
+		sender := datastruct.NewCommonMessage()
+		sender.Data = true
+		c.JSON(http.StatusOK, sender)
+	})
+}
+
+//decodeStrategyProfile 解析请求中的报警策略方案，失败时直接响应错误
+func decodeStrategyProfile(c *gin.Context) (datastruct.StrategyProfile, bool) {
+	type Rcvd struct {
+		Code   int                        `json:"code"`
+		ErrMsg string                     `json:"errMsg"`
+		Data   datastruct.StrategyProfile `json:"data"`
+		Status bool                       `json:"status"`
+	}
+	r := Rcvd{}
+	if c.Request.ContentLength == 0 {
+		c.JSON(http.StatusBadRequest, datastruct.ERRORMSG_BlankBody)
+		c.Abort()
+		return r.Data, false
+	}
+	decoder := g.Json.NewDecoder(c.Request.Body)
+	if err := decoder.Decode(&r); err != nil {
+		c.JSON(http.StatusBadRequest, datastruct.ERRORMSG_DecoderError)
+		c.Abort()
+		return r.Data, false
+	}
+	return r.Data, true
+}
+
+//decodeStringData 解析请求中CommonMessage.Data字符串，失败时直接响应错误
+func decodeStringData(c *gin.Context) (string, bool) {
+	if c.Request.ContentLength == 0 {
+		c.JSON(http.StatusBadRequest, datastruct.ERRORMSG_BlankBody)
+		c.Abort()
+		return "", false
+	}
+	var rcvd datastruct.CommonMessage
+	decoder := g.Json.NewDecoder(c.Request.Body)
+	if err := decoder.Decode(&rcvd); err != nil {
+		c.JSON(http.StatusBadRequest, datastruct.ERRORMSG_DecoderError)
+		c.Abort()
+		return "", false
+	}
+	s, ok := rcvd.Data.(string)
+	if !ok {
+		c.JSON(http.StatusBadRequest, datastruct.ERRORMSG_DecoderError)
+		c.Abort()
+		return "", false
+	}
+	return s, true
+}
+
+func saveStrategyProfile(c *gin.Context, p datastruct.StrategyProfile) {
+	if err := parameters.SaveStrategyProfile(p); err != nil {
+		responseError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	g.LogInfo(c.Request.RemoteAddr, " save strategy profile ", p.Name)
+	sender := datastruct.NewCommonMessage()
+	sender.Data = true
+	c.JSON(http.StatusOK, sender)
+}
+
+//sessionString 获取session中字符串类型的值
+func sessionString(s *Session, key string) string {
+	if s == nil {
+		return ""
+	}
+	if v, ok := s.Get(key).(string); ok {
+		return v
+	}
+	return ""
+}
+
+//sessionRequestIds 获取session中请求的节点列表
+func sessionRequestIds(s *Session) []string {
+	ids := make([]string, 0)
+	if s == nil {
+		return ids
+	}
+	if t, ok := s.Get(datastruct.KEY_RequestIds).([]interface{}); ok {
+		for _, id := range t {
+			if v, ok := id.(string); ok {
+				ids = append(ids, v)
+			}
+		}
+	}
+	return ids
+}
+
+//sessionStrategyProfile 获取session适用的报警策略方案
+//优先使用session选择的方案，否则按操作员、请求收费站匹配，最后使用默认方案
+func sessionStrategyProfile(s *Session) *datastruct.StrategyProfile {
+	if p, ok := parameters.GetStrategyProfile(sessionString(s, datastruct.KEY_StrategyProfile)); ok {
+		return p
+	}
+	return parameters.ResolveStrategyProfile(sessionString(s, datastruct.KEY_Operator), sessionRequestIds(s))
+}
@@ -12,7 +12,7 @@ import (
 
 //ConfigPushHandle 路由配置
 func configPushHandle() {
-	//push POST 接收发布端的POST信息更新渲染数据并添加至实时发送队列
+	//push POST 接收发布端的POST信息更新渲染数据，核心数据按车道合并后周期推送(见coalesce.go)
 	v1.POST("/push", func(c *gin.Context) {
 		if c.Request.ContentLength == 0 {
 			c.JSON(http.StatusBadRequest, datastruct.ERRORMSG_BlankBody)
@@ -28,7 +28,6 @@ func configPushHandle() {
 			return
 		}
 		for _, m := range metrics {
-			msgSend := datastruct.NewMsgSend()
 			node, exists := parameters.GetNodeByIP(m.Endpoint)
 			if !exists {
 				g.LogError(m.Endpoint, " is not exists")
@@ -36,14 +35,8 @@ func configPushHandle() {
 			}
 			if msgType, ok := g.Config().CoreData.List[m.Metric]; ok {
 				parameters.UpdateCoreInfo(node.NodeID, m.Metric, m.Value)
-				msgSend.MsgCatalog = 22
-				msgSend.MsgType = msgType
-				msgSend.MsgTime = time.Unix(m.Timestamp, 0).Format("2006-01-02 15:04:05")
-				msgSend.MsgLane = node.NodeID
-				a := make(map[string]interface{})
-				a[m.Metric] = m.Value
-				msgSend.MsgContent = a
-				PushRealData(node.NodeID[0:16], msgSend)
+				mTime := time.Unix(m.Timestamp, 0).Format("2006-01-02 15:04:05")
+				coreDataCoalescer.add(node.NodeID, m.Metric, msgType, m.Value, mTime)
 			}
 		}
 
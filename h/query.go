@@ -0,0 +1,158 @@
+package h
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+	"tollsys/tollmon/datastruct"
+	"tollsys/tollmon/parameters"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	dateFormat = "2006-01-02"
+	timeFormat = "2006-01-02 15:04:05"
+
+	//maxQueryDays 按日期范围查询本地记录时的最大天数
+	maxQueryDays = 366
+)
+
+//responseError 以CommonMessage格式返回错误信息
+func responseError(c *gin.Context, status int, errMsg string) {
+	sender := datastruct.NewCommonMessage()
+	sender.Status = false
+	sender.Code = 1
+	sender.ErrMsg = errMsg
+	c.JSON(status, sender)
+	c.Abort()
+}
+
+//queryDateRange 解析from/to日期参数(2006-01-02)，缺省为当日，范围不超过maxQueryDays天
+func queryDateRange(c *gin.Context) (time.Time, time.Time, error) {
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+	from, to := today, today
+	var err error
+	if s := c.Query("from"); s != "" {
+		if from, err = time.ParseInLocation(dateFormat, s, time.Local); err != nil {
+			return from, to, errors.New("invalid from date " + s)
+		}
+	}
+	if s := c.Query("to"); s != "" {
+		if to, err = time.ParseInLocation(dateFormat, s, time.Local); err != nil {
+			return from, to, errors.New("invalid to date " + s)
+		}
+	}
+	if to.Before(from) {
+		return from, to, errors.New("to date is before from date")
+	}
+	if to.After(from.AddDate(0, 0, maxQueryDays-1)) {
+		return from, to, errors.New("date range exceeds " + strconv.Itoa(maxQueryDays) + " days")
+	}
+	return from, to, nil
+}
+
+//queryNodeID 获取节点编码参数，要求不少于minLen位
+func queryNodeID(c *gin.Context, key string, minLen int) (string, error) {
+	id := c.Query(key)
+	if id != "" && len(id) < minLen {
+		return "", errors.New("invalid " + key + " " + id)
+	}
+	return id, nil
+}
+
+//queryInt 获取整型参数，缺省为0
+func queryInt(c *gin.Context, key string) (int, error) {
+	s := c.Query(key)
+	if s == "" {
+		return 0, nil
+	}
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, errors.New("invalid " + key + " " + s)
+	}
+	return i, nil
+}
+
+//queryTimeRange 解析from/to时间参数，支持2006-01-02 15:04:05或2006-01-02格式
+//缺省为当日零点至当前时间；to仅指定日期时包含该日全天，范围不超过maxQueryDays天
+func queryTimeRange(c *gin.Context) (time.Time, time.Time, error) {
+	now := time.Now()
+	from := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+	to := now
+	var err error
+	if s := c.Query("from"); s != "" {
+		if from, err = parseQueryTime(s); err != nil {
+			return from, to, errors.New("invalid from time " + s)
+		}
+	}
+	if s := c.Query("to"); s != "" {
+		if to, err = parseQueryTime(s); err != nil {
+			return from, to, errors.New("invalid to time " + s)
+		}
+		if len(s) == len(dateFormat) {
+			to = to.AddDate(0, 0, 1)
+		}
+	}
+	if !from.Before(to) {
+		return from, to, errors.New("to time must be after from time")
+	}
+	if to.After(from.AddDate(0, 0, maxQueryDays)) {
+		return from, to, errors.New("time range exceeds " + strconv.Itoa(maxQueryDays) + " days")
+	}
+	return from, to, nil
+}
+
+func parseQueryTime(s string) (time.Time, error) {
+	if len(s) == len(dateFormat) {
+		return time.ParseInLocation(dateFormat, s, time.Local)
+	}
+	return time.ParseInLocation(timeFormat, s, time.Local)
+}
+
+//queryLanes 根据stationId/plazaId/laneId参数获取车道节点，参数均为空时返回全部车道
+func queryLanes(c *gin.Context) ([]datastruct.Node, error) {
+	prefix := ""
+	if id := c.Query("laneId"); id != "" {
+		if len(id) < 26 {
+			return nil, errors.New("invalid laneId " + id)
+		}
+		prefix = id
+	} else if id := c.Query("plazaId"); id != "" {
+		if len(id) < 20 {
+			return nil, errors.New("invalid plazaId " + id)
+		}
+		prefix = id[0:20]
+	} else if id := c.Query("stationId"); id != "" {
+		if len(id) < 16 {
+			return nil, errors.New("invalid stationId " + id)
+		}
+		prefix = id[0:16]
+	}
+	list := make([]datastruct.Node, 0)
+	for _, lane := range parameters.GetLanes() {
+		if len(lane.NodeID) >= len(prefix) && lane.NodeID[0:len(prefix)] == prefix {
+			list = append(list, lane)
+		}
+	}
+	return list, nil
+}
+
+//queryInts 获取以逗号分隔的整型列表参数，缺省为空
+func queryInts(c *gin.Context, key string) ([]int, error) {
+	list := make([]int, 0)
+	s := c.Query(key)
+	if s == "" {
+		return list, nil
+	}
+	for _, v := range strings.Split(s, ",") {
+		i, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil {
+			return nil, errors.New("invalid " + key + " " + s)
+		}
+		list = append(list, i)
+	}
+	return list, nil
+}
@@ -0,0 +1,24 @@
+package h
+
+import (
+	"net/http"
+	"tollsys/tollmon/datastruct"
+	"tollsys/tollmon/reader"
+
+	"github.com/gin-gonic/gin"
+)
+
+//configReaderRoute 卡机健康度路由配置
+func configReaderRoute() {
+	//Readers GET 查询车道卡机健康度，参数：stationId/plazaId/laneId 节点，均为空时查询全部车道
+	v1.GET("/Readers", func(c *gin.Context) {
+		lanes, err := queryLanes(c)
+		if err != nil {
+			responseError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		sender := datastruct.NewCommonMessage()
+		sender.Data = reader.GetHealth(lanes)
+		c.JSON(http.StatusOK, sender)
+	})
+}
@@ -1,139 +1,78 @@
 package h
 
 import (
-	"time"
 	"tollsys/tollmon/datastruct"
 	"tollsys/tollmon/g"
-
-	"github.com/gorilla/websocket"
-	"runtime"
-)
-
-var (
-	broadCast        = make(map[string]chan interface{})
-	stationToClients = make(map[string][]*websocket.Conn)
+	"tollsys/tollmon/notify"
 )
 
-//bindStationIdToClients 提供绑定 站节点-webSocket方法
-//已废弃
-//func bindStationIdToClients(id string, conn *websocket.Conn) {
-//	stationToClients[id] = append(stationToClients[id], conn)
-//}
-
-//bindStationIdToClients 提供解除绑定 站节点-webSocket方法
-//遍历队列获取失效webSocket索引，合并该索引前后的队列为新的队列
-//func unbindStationIdToClient(id string, conn *websocket.Conn) {
-//	for index, client := range stationToClients[id] {
-//		if client == conn {
-//			g.LogDebug("解除绑定 站节点-webSocket", conn.RemoteAddr(), "-", id)
-//			stationToClients[id] = append(stationToClients[id][:index], stationToClients[id][index+1:]...)
-//		}
-//	}
-//}
-func unbindStationIdToClient(conn *websocket.Conn) {
-	for index, webSocketList := range stationToClients {
-		for id, client := range webSocketList {
-			if client == conn {
-				g.LogDebug("解除绑定 站节点-webSocket", conn.RemoteAddr(), "-", id)
-				stationToClients[index] = append(stationToClients[index][:id], stationToClients[index][id+1:]...)
-			}
-		}
-	}
+//PushRealData 推送本实例产生的实时数据至订阅该收费站的客户端
+func PushRealData(stationId string, data interface{}) {
+	notify.Dispatch(stationId, data) //报警类消息同时提交至邮件、syslog等通知通道
+	distribute(stationId, data)
 }
 
-//getDataChan提供通过stationID获取缓冲数据通道的功能
-//要求参数stationID:string eg:(1F010000000008)
-//如果缓冲列表中无该站缓冲则创建
-func getDataChan(stationID string) chan interface{} {
-	if dataChan, ok := broadCast[stationID]; ok {
-		g.LogDebug("get chan:", stationID)
-		return dataChan
+//distribute 分发实时数据至本地客户端，启用Redis发布订阅时发布至其它实例，启用上级转发时转发至上级
+func distribute(stationId string, data interface{}) {
+	fanOut(stationId, data)
+	if g.Config().Redis.PubSub {
+		relayPublish(stationId, data)
 	}
-	g.LogDebug("chan:", stationID, " is blank , create a new chan")
-	dataChan := make(chan interface{}, 5000)
-	broadCast[stationID] = dataChan
-	return dataChan
-}
-func PushRealData(stationId string,data interface{}){
-	for conn,_ := range clientList { //遍历webSocket客户端列表
-		if conn.requestIds[stationId] { //判断该webSocket客户端是否请求当前站数据并发送
-			send(conn, data)
-		}
+	if c := g.Config().Upstream; c != nil && c.Enabled {
+		forwardUpstream(stationId, data)
 	}
 }
-//PushRuntimeData提供写入实时数据至某收费站的方法
-//要求参数stationID:string eg:(1F01000000008) 数据data:interface{}
-//通过stationID获取该station的缓冲数据通道并将data写入缓冲通道
-//当通道满时，去除最早的数据并写入最新数据
-//func PushRuntimeData(stationID string, data interface{}) {
-//	lock.Lock()
-//	defer lock.Unlock()
-//	c := getDataChan(stationID)
-//	select {
-//	case c <- data:
-//		g.LogDebug("<- chan OK:", stationID, data)
-//	default:
-//		g.LogDebug("chan full")
-//		d := <-c
-//		g.LogDebug("remove index 1 data:", d)
-//		d = nil
-//		c <- data
-//	}
-//}
 
-//StartRealData 广播实时数据 goroutine启动
-//遍历待发送实时数据队列，获取站实时数据缓冲通道并根据站节点编码获取该通道需要该通道数据的webSocket 广播该通道数据
-func StartRealData() {
-	g.LogInfo("goroutine:Start Handle Real Data Send...")
-	g.LogDebug("当前广播通道:",len(broadCast))
-	for {
-		for stationId, dataChan := range broadCast {
-			g.LogDebug("开始广播信息:",stationId," - ", len(dataChan))
-			//g.LogDebug("start broadcasting data-",stationId)
-			//需要注意的是 待广播数据模型是 站-数据缓存队列 的模式，因此在一次发送中将发送缓存队列中所有数据
-			//缓存数据的发送暂时无法做到并发发送，webSocket是不支持并发写操作的
-			//因此在数据量剧增时，前端可能会出现短时间内仅接收到同一站点的信息，这是由于缓存数据过多导致的
-			//如果需要做到前端同时获取多个站数据的效果，可以并发读取站数据再写入webSocket，由于写操作由读写锁控制
-			//效率上并无提升，但用户体验将变好
-			select {
-				case data := <-dataChan: //从缓冲队列拿一条数据
-					for conn, _ := range clientList { //遍历webSocket客户端列表
-						if conn.requestIds[stationId] { //判断该webSocket客户端是否请求当前站数据并发送
-							send(conn, data)
-						}
-					}
-					data =  nil
-				default: //缓存为空终止IO阻塞
-					break
-				}
-		}
-		time.Sleep(time.Second / 10)
-		runtime.GC()
+//fanOut 分发实时数据至本地客户端
+//数据先写入回放缓存并分配序号；仅遍历订阅该收费站或其广场、车道的客户端，按各客户端订阅条件过滤后写入发送队列，不等待发送完成
+func fanOut(stationId string, data interface{}) {
+	wsHistory.lock.Lock()
+	defer wsHistory.lock.Unlock()
+	seq := wsHistory.add(stationId, data)
+	for _, conn := range wsHub.subscribers(stationId) {
+		send(conn, stationId, data, seq)
 	}
 }
 
-//发送数据，并为该数据叠加策略信息
-//发送失败则认为该webSocket已失效 (失效后仅标记该webSocket为失效状态，不做删除操作)
-func send(conn *webSocketClient, d interface{}) {
-	if conn.client == nil {
+//send 按客户端订阅条件及报警策略过滤数据，为报警叠加策略等级后连同序号写入发送队列
+//核心数据增量消息按订阅条件过滤车道及数据项
+//报警内容在各客户端间共享，叠加等级时复制消息内容
+func send(conn *realtimeClient, stationId string, d interface{}, seq uint64) {
+	conn.lock.RLock()
+	var item datastruct.StrategyItem
+	subscribed := true
+	switch v := d.(type) {
+	case datastruct.MsgSend:
+		item = conn.strategyItems[v.MsgType]
+		subscribed = conn.sub.match(stationId, d, item.Level)
+	case *coreDataDelta:
+		if f := v.filter(&conn.sub); f != nil {
+			d = f
+		} else {
+			subscribed = false
+		}
+	default:
+		subscribed = conn.sub.match(stationId, d, item.Level)
+	}
+	conn.lock.RUnlock()
+	if !subscribed {
 		return
 	}
-	if v, ok := d.(datastruct.MsgSend); ok {
-		if v.MsgCatalog == 0x20 {
-			if !conn.strategyItems[v.MsgType].IsChecked {
-				return
-			}
-			v.MsgContent["level"] = conn.strategyItems[v.MsgType].Level
+	if v, ok := d.(datastruct.MsgSend); ok && v.MsgCatalog == datastruct.MSGCATALOG_Alert {
+		if !item.IsChecked {
+			return
+		}
+		content := make(map[string]interface{}, len(v.MsgContent)+1)
+		for k, val := range v.MsgContent {
+			content[k] = val
 		}
+		content["level"] = item.Level
+		v.MsgContent = content
+		d = v
 	}
-	j := datastruct.NewCommonMessage()
+	j := &realtimeMessage{CommonMessage: *datastruct.NewCommonMessage(), Seq: seq}
 	j.Data = d
-	if err := conn.write(j); err != nil {
-		lock.Lock()
-		g.LogError("ws:", conn.client.RemoteAddr(), " write data err:", err.Error())
-		clientList[conn] = false
-		conn.client.Close()
-		lock.Unlock()
+	if conn.enqueue(j) {
+		g.LogDebug("发送数据 - ", d, " --> ", conn.addr)
 	}
-	g.LogDebug("发送数据 - ",d," --> ",conn.client.RemoteAddr())
 }
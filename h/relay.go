@@ -0,0 +1,293 @@
+package h
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"tollsys/tollmon/datastruct"
+	"tollsys/tollmon/g"
+	"tollsys/tollmon/parameters"
+	"tollsys/tollmon/redis"
+)
+
+//多实例实时数据转发
+//redis.pubSub 启用时，本实例产生的实时数据按收费站发布至Redis频道 {channel}{stationId}，
+//各实例订阅全部频道并分发至本地客户端，客户端可连接任一实例；本实例发布的数据已在本地分发，收到时忽略。
+//报警通知仅由产生数据的实例发送；回放序号由各实例分别分配，断线重连至其它实例时回放可能不完整。
+//实时数据转发为尽力而为，不经磁盘缓冲队列：Redis不可用或发布队列满时丢弃，恢复后不补发过期数据。
+//LaneInfo/CoreData按心跳周期发布本实例产生的变化，其它实例收到后更新本地车道数据，使连接任一实例时的快照及/LaneInfo一致；
+//实例启动时发布同步请求(频道 {channel}，kind 为sync)，其它实例收到后发布由其产生的全部车道数据
+
+const (
+	defaultRelayChannel = "tollmon:realtime:"
+
+	relayMsgSend = "msg"
+	relayDelta   = "delta"
+	relayOther   = "other"
+	relaySync    = "sync"
+)
+
+//relayMessage 转发消息，用于多实例转发及上级转发，Origin 为发布实例标识
+//Kind 为数据类型：msg 为MsgSend，delta 为核心数据增量消息(Types 为其数据项类别)，other 为其它数据；
+//上级转发另有 nodes 收费站节点树，laneInfo/coreData 为车道数据列表(见upstream.go)
+type relayMessage struct {
+	Origin    string          `json:"origin"`
+	StationID string          `json:"stationId"`
+	Kind      string          `json:"kind"`
+	Types     map[string]int  `json:"types,omitempty"`
+	Data      json.RawMessage `json:"data"`
+}
+
+//instanceID 本实例标识
+var instanceID = func() string {
+	host, _ := os.Hostname()
+	return host + ":" + strconv.Itoa(os.Getpid())
+}()
+
+func relayChannel() string {
+	if g.Config().Redis.Channel != "" {
+		return g.Config().Redis.Channel
+	}
+	return defaultRelayChannel
+}
+
+//encodeRelay 按数据类型编码实时数据
+func encodeRelay(stationID string, data interface{}) ([]byte, error) {
+	m := relayMessage{Origin: instanceID, StationID: stationID, Kind: relayOther}
+	switch v := data.(type) {
+	case datastruct.MsgSend:
+		m.Kind = relayMsgSend
+	case *coreDataDelta:
+		m.Kind = relayDelta
+		m.Types = v.types
+	}
+	b, err := g.Json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	m.Data = b
+	return g.Json.Marshal(m)
+}
+
+//relayPublish 编码本实例产生的实时数据并写入发布队列，队列满时丢弃
+func relayPublish(stationID string, data interface{}) {
+	b, err := encodeRelay(stationID, data)
+	if err != nil {
+		g.LogError("relay marshal err:", err.Error())
+		return
+	}
+	select {
+	case relayQueue <- relayItem{channel: relayChannel() + stationID, b: b}:
+	default:
+		g.LogError("relay queue full, drop ", stationID)
+	}
+}
+
+type relayItem struct {
+	channel string
+	b       []byte
+}
+
+//relayQueue 待发布的数据，由relayPublisher发布，不阻塞实时数据处理
+var relayQueue = make(chan relayItem, defaultQueueSize)
+
+//relayPublisher 发布队列中的数据至Redis，不返回
+func relayPublisher() {
+	for item := range relayQueue {
+		if err := redis.Publish(item.channel, item.b); err != nil {
+			g.LogError("relay publish ", item.channel, " err:", err.Error())
+		}
+	}
+}
+
+//subscribeRelay 订阅其它实例发布的实时数据并分发至本地客户端，不返回
+func subscribeRelay() {
+	prefix := relayChannel()
+	g.LogInfo("relay subscribe ", prefix, "* as ", instanceID)
+	redis.PSubscribe(prefix+"*", func(channel string, b []byte) {
+		m := relayMessage{}
+		if err := g.Json.Unmarshal(b, &m); err != nil {
+			g.LogError("relay ", channel, " decode err:", err.Error())
+			return
+		}
+		if m.Origin == instanceID || m.StationID != strings.TrimPrefix(channel, prefix) {
+			return
+		}
+		switch m.Kind {
+		case relaySync:
+			relayStates.publishOwned()
+			return
+		case relayLaneInfo, relayCoreData:
+			if err := relayStates.apply(&m); err != nil {
+				g.LogError("relay ", channel, " decode err:", err.Error())
+			}
+			return
+		}
+		data, err := m.decode()
+		if err != nil {
+			g.LogError("relay ", channel, " decode err:", err.Error())
+			return
+		}
+		fanOut(m.StationID, data)
+	})
+}
+
+//decode 按数据类型解码转发的实时数据
+func (m *relayMessage) decode() (interface{}, error) {
+	switch m.Kind {
+	case relayMsgSend:
+		v := datastruct.NewMsgSend()
+		err := g.Json.Unmarshal(m.Data, &v)
+		return v, err
+	case relayDelta:
+		v := newCoreDataDelta(m.StationID)
+		err := g.Json.Unmarshal(m.Data, v)
+		if m.Types != nil {
+			v.types = m.Types
+		}
+		return v, err
+	}
+	var v interface{}
+	err := g.Json.Unmarshal(m.Data, &v)
+	return v, err
+}
+
+//relayState 多实例转发的LaneInfo/CoreData状态
+//sent 为已发布或已从其它实例更新的车道数据，仅发布与其不同的即本实例产生的变化；
+//owned 为最近一次变化由本实例产生的车道，用于响应其它实例的同步请求
+type relayState struct {
+	lock  *sync.Mutex
+	sent  *upstreamState
+	owned map[string]bool
+}
+
+var relayStates = &relayState{lock: &sync.Mutex{}, sent: newUpstreamState(), owned: make(map[string]bool)}
+
+//relayStateSync 记录启动时的车道数据，请求其它实例发布其车道数据，并按心跳周期发布本实例产生的变化，不返回
+func relayStateSync() {
+	relayStates.lock.Lock()
+	relayStates.sent.sync(func(string, string, interface{}) error { return nil })
+	relayStates.lock.Unlock()
+	ticker := time.NewTicker(interval())
+	defer ticker.Stop()
+	requested := false
+	for range ticker.C {
+		//首个周期后请求，等待订阅建立以接收其它实例的响应
+		if !requested {
+			if err := relayWrite("", relaySync, nil); err != nil {
+				g.LogError("relay sync request err:", err.Error())
+			}
+			requested = true
+		}
+		relayStates.lock.Lock()
+		err := relayStates.sent.sync(relayStates.write)
+		relayStates.lock.Unlock()
+		if err != nil {
+			g.LogError("relay lane state err:", err.Error())
+		}
+	}
+}
+
+//write 发布车道数据并记录为本实例产生，调用方需持有lock
+func (s *relayState) write(stationID string, kind string, data interface{}) error {
+	if err := relayWrite(stationID, kind, data); err != nil {
+		return err
+	}
+	switch v := data.(type) {
+	case []datastruct.LaneInfo:
+		for _, info := range v {
+			s.owned[info.Node.NodeID] = true
+		}
+	case []datastruct.CoreData:
+		for _, data := range v {
+			s.owned[data.Node.NodeID] = true
+		}
+	}
+	return nil
+}
+
+//publishOwned 发布由本实例产生的全部车道数据，响应其它实例的同步请求
+func (s *relayState) publishOwned() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if len(s.owned) == 0 {
+		return
+	}
+	for _, station := range parameters.GetStationTrees() {
+		stationID := station.Station.NodeID[0:16]
+		infos := make([]datastruct.LaneInfo, 0)
+		for _, info := range parameters.GetLaneInfoByStationID(stationID) {
+			if s.owned[info.Node.NodeID] {
+				infos = append(infos, info.Copy())
+			}
+		}
+		datas := make([]datastruct.CoreData, 0)
+		for _, data := range parameters.GetCoreDataByStationID(stationID) {
+			if s.owned[data.Node.NodeID] {
+				datas = append(datas, data.Copy())
+			}
+		}
+		if len(infos) != 0 {
+			if err := relayWrite(stationID, relayLaneInfo, infos); err != nil {
+				g.LogError("relay lane state err:", err.Error())
+				return
+			}
+		}
+		if len(datas) != 0 {
+			if err := relayWrite(stationID, relayCoreData, datas); err != nil {
+				g.LogError("relay lane state err:", err.Error())
+				return
+			}
+		}
+	}
+}
+
+//apply 以其它实例发布的车道数据更新本地车道数据，并记录为已发布，避免再次发布
+func (s *relayState) apply(m *relayMessage) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if m.Kind == relayLaneInfo {
+		list := make([]datastruct.LaneInfo, 0)
+		if err := g.Json.Unmarshal(m.Data, &list); err != nil {
+			return err
+		}
+		for _, info := range list {
+			id := info.Node.NodeID
+			if strings.HasPrefix(id, m.StationID) && parameters.SetLaneInfo(info) {
+				s.sent.laneInfo[id] = parameters.GetLaneInfoByID(id).Copy().Info
+				delete(s.owned, id)
+			}
+		}
+		return nil
+	}
+	list := make([]datastruct.CoreData, 0)
+	if err := g.Json.Unmarshal(m.Data, &list); err != nil {
+		return err
+	}
+	for _, data := range list {
+		id := data.Node.NodeID
+		if strings.HasPrefix(id, m.StationID) && parameters.SetCoreData(data) {
+			s.sent.coreData[id] = parameters.GetCoreInfoById(id).Copy().CoreData
+			delete(s.owned, id)
+		}
+	}
+	return nil
+}
+
+//relayWrite 编码车道数据或同步请求并写入发布队列，队列满时返回错误
+func relayWrite(stationID string, kind string, data interface{}) error {
+	b, err := encodeState(stationID, kind, data)
+	if err != nil {
+		return err
+	}
+	select {
+	case relayQueue <- relayItem{channel: relayChannel() + stationID, b: b}:
+		return nil
+	default:
+		return errors.New("relay queue full")
+	}
+}
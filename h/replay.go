@@ -0,0 +1,186 @@
+package h
+
+import (
+	"sort"
+	"sync"
+	"time"
+	"tollsys/tollmon/datastruct"
+	"tollsys/tollmon/g"
+	"tollsys/tollmon/parameters"
+)
+
+//实时数据快照及回放
+//history 按收费站保存最近replaySize条实时数据，每条数据分配全局递增的序号seq，推送时随数据下发；
+//客户端连接时先收到订阅节点的LaneInfo/CoreData快照，携带上次收到的seq时回放其后缓存的数据，之后接收实时数据。
+//快照、回放及注册在history锁内完成，与实时推送之间不重复、不遗漏。
+//序号从启动时间(秒)左移seqBootShift位开始分配，重启后的序号大于重启前的序号，
+//客户端携带重启前的序号时可判断为不同启动周期，回放标记为不完整，客户端以快照重新同步
+
+const (
+	defaultReplaySize = 500
+	//seqBootShift 每秒运行时间可分配的序号数为2^20，序号在JavaScript数值的精确范围内
+	seqBootShift = 20
+)
+
+//ActionSnapshot 快照消息，也可由客户端发送以重新获取快照及回放
+const ActionSnapshot = "snapshot"
+
+//realtimeMessage 实时数据消息，Seq 为数据序号
+type realtimeMessage struct {
+	datastruct.CommonMessage
+	Seq uint64 `json:"seq"`
+}
+
+//wsSnapshot 快照消息内容
+//Seq 为快照时的最新序号；Replay 为随后回放的数据条数；Complete 为false时表示缓存不足或服务已重启，部分数据无法回放
+type wsSnapshot struct {
+	Action   string                `json:"action"`
+	Seq      uint64                `json:"seq"`
+	Replay   int                   `json:"replay"`
+	Complete bool                  `json:"complete"`
+	LaneInfo []datastruct.LaneInfo `json:"laneInfo"`
+	CoreData []datastruct.CoreData `json:"coreData"`
+}
+
+type event struct {
+	seq       uint64
+	stationID string
+	data      interface{}
+}
+
+//ring 单个收费站的实时数据环形缓存
+type ring struct {
+	events []event
+	next   int
+	full   bool
+}
+
+func (r *ring) add(e event) {
+	r.events[r.next] = e
+	r.next++
+	if r.next == len(r.events) {
+		r.next = 0
+		r.full = true
+	}
+}
+
+//since 获取序号seq之后的数据，返回数据是否完整
+func (r *ring) since(seq uint64) ([]event, bool) {
+	list := make([]event, 0)
+	start, n := 0, r.next
+	if r.full {
+		start, n = r.next, len(r.events)
+	}
+	for i := 0; i < n; i++ {
+		e := r.events[(start+i)%len(r.events)]
+		if e.seq > seq {
+			list = append(list, e)
+		}
+	}
+	complete := !r.full || r.events[r.next].seq <= seq+1
+	return list, complete
+}
+
+//history 实时数据缓存，boot 为本次启动的起始序号
+type history struct {
+	lock  *sync.Mutex
+	boot  uint64
+	seq   uint64
+	rings map[string]*ring
+}
+
+var wsHistory = newHistory(time.Now())
+
+func newHistory(start time.Time) *history {
+	boot := uint64(start.Unix()) << seqBootShift
+	return &history{
+		lock:  &sync.Mutex{},
+		boot:  boot,
+		seq:   boot,
+		rings: make(map[string]*ring),
+	}
+}
+
+//add 缓存实时数据并返回序号，调用方需持有lock
+func (h *history) add(stationID string, data interface{}) uint64 {
+	h.seq++
+	r, ok := h.rings[stationID]
+	if !ok {
+		size := defaultReplaySize
+		if g.Config().WebSocket.ReplaySize > 0 {
+			size = g.Config().WebSocket.ReplaySize
+		}
+		r = &ring{events: make([]event, size)}
+		h.rings[stationID] = r
+	}
+	r.add(event{seq: h.seq, stationID: stationID, data: data})
+	return h.seq
+}
+
+//since 获取各收费站序号seq之后的数据并按序号排序，调用方需持有lock
+//seq不属于本次启动周期(早于boot或大于当前序号)时返回不完整
+func (h *history) since(stationIDs []string, seq uint64) ([]event, bool) {
+	list := make([]event, 0)
+	complete := seq >= h.boot && seq <= h.seq
+	for _, id := range stationIDs {
+		r, ok := h.rings[id]
+		if !ok {
+			continue
+		}
+		events, ok := r.since(seq)
+		list = append(list, events...)
+		complete = complete && ok
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].seq < list[j].seq
+	})
+	return list, complete
+}
+
+//resume 发送订阅节点快照，seq不为nil时回放其后的数据，并按当前订阅条件注册至hub
+//重复调用时回放的数据可能与已在发送队列中的数据重复，客户端按seq去重
+func (w *realtimeClient) resume(seq *uint64) {
+	wsHistory.lock.Lock()
+	defer wsHistory.lock.Unlock()
+	w.lock.RLock()
+	ids := w.sub.stationKeys()
+	snapshot := w.snapshot(ids)
+	w.lock.RUnlock()
+	snapshot.Seq = wsHistory.seq
+	events := make([]event, 0)
+	if seq != nil {
+		events, snapshot.Complete = wsHistory.since(ids, *seq)
+		snapshot.Replay = len(events)
+	}
+	sender := datastruct.NewCommonMessage()
+	sender.Data = snapshot
+	w.enqueue(sender)
+	for _, e := range events {
+		send(w, e.stationID, e.data, e.seq)
+	}
+	wsHub.reindex(w)
+	g.LogInfo(w.addr, " snapshot seq:", snapshot.Seq, " replay:", snapshot.Replay)
+}
+
+//snapshot 订阅节点的LaneInfo/CoreData快照，调用方需持有w.lock
+func (w *realtimeClient) snapshot(stationIDs []string) *wsSnapshot {
+	s := &wsSnapshot{
+		Action:   ActionSnapshot,
+		Complete: true,
+		LaneInfo: make([]datastruct.LaneInfo, 0),
+		CoreData: make([]datastruct.CoreData, 0),
+	}
+	for _, id := range stationIDs {
+		for _, info := range parameters.GetLaneInfoByStationID(id) {
+			if w.sub.matchNode(id, info.Node.NodeID) {
+				s.LaneInfo = append(s.LaneInfo, info.Copy())
+			}
+		}
+		for _, data := range parameters.GetCoreDataByStationID(id) {
+			if w.sub.matchNode(id, data.Node.NodeID) {
+				s.CoreData = append(s.CoreData, data.Copy())
+			}
+		}
+	}
+	return s
+}
@@ -0,0 +1,49 @@
+package h
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+	"tollsys/tollmon/g"
+)
+
+func TestHistorySince(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "config.json")
+	if err := ioutil.WriteFile(name, []byte(`{"log": {}, "webSocket": {"replaySize": 3}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	g.ParseConfig(name)
+
+	start := time.Now()
+	previous := newHistory(start.Add(-time.Minute))
+	h := newHistory(start)
+	ids := []string{"1F01010400010000"}
+	for i := 0; i < 5; i++ {
+		previous.add(ids[0], i)
+		h.add(ids[0], i)
+	}
+	cases := []struct {
+		name     string
+		seq      uint64
+		replay   int
+		complete bool
+	}{
+		{"up to date", h.seq, 0, true},
+		{"within replay size", h.seq - 2, 2, true},
+		{"exactly replay size", h.seq - 3, 3, true},
+		{"beyond replay size", h.seq - 4, 3, false},
+		{"from boot", h.boot, 3, false},
+		{"previous boot", previous.seq, 3, false},
+		{"ahead of server", h.seq + 1, 0, false},
+	}
+	for _, c := range cases {
+		events, complete := h.since(ids, c.seq)
+		if len(events) != c.replay || complete != c.complete {
+			t.Errorf("%s: replay %d complete %v, want %d %v", c.name, len(events), complete, c.replay, c.complete)
+		}
+	}
+	if previous.seq >= h.boot {
+		t.Errorf("sequence of previous boot %d not below %d", previous.seq, h.boot)
+	}
+}
@@ -0,0 +1,96 @@
+package h
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+	"tollsys/tollmon/datastruct"
+	"tollsys/tollmon/timeline"
+
+	"github.com/gin-gonic/gin"
+)
+
+//configReportRoute 报表路由配置
+//参数：stationId/plazaId/laneId 节点，均为空时查询全部车道；format=csv 导出csv文件，format=xlsx 导出xlsx文件(同Export/availability)，缺省为json
+func configReportRoute() {
+	//Reports/Availability GET 车道、收费站可用率报表
+	//month 为月份(2006-01)，指定时统计该月；否则按from/to时间或日期统计，缺省为当日零点至当前时间
+	v1.GET("/Reports/Availability", func(c *gin.Context) {
+		if c.Query("format") == "xlsx" {
+			if t, ok := availabilityTable(c); ok {
+				responseTable(c, t)
+			}
+			return
+		}
+		lanes, err := queryLanes(c)
+		if err != nil {
+			responseError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		from, to, err := queryReportRange(c)
+		if err != nil {
+			responseError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		r := timeline.GetSLA(lanes, from, to)
+		if c.Query("format") == "csv" {
+			header := []string{"type", "nodeID", "nodeName", "lanes", "availability", "outages", "mttr", "uptime", "downtime", "known"}
+			rows := make([][]string, 0, len(r.Stations)+len(r.Lanes))
+			for _, s := range r.Stations {
+				rows = append(rows, slaRow("station", s))
+			}
+			for _, s := range r.Lanes {
+				rows = append(rows, slaRow("lane", s))
+			}
+			responseCSV(c, "availability_"+from.Format("20060102")+"_"+to.Format("20060102")+".csv", header, rows)
+			return
+		}
+		sender := datastruct.NewCommonMessage()
+		sender.Data = r
+		c.JSON(http.StatusOK, sender)
+	})
+}
+
+//queryReportRange 解析报表统计时段，month 参数优先
+func queryReportRange(c *gin.Context) (time.Time, time.Time, error) {
+	if s := c.Query("month"); s != "" {
+		from, err := time.ParseInLocation("2006-01", s, time.Local)
+		if err != nil {
+			return from, from, errors.New("invalid month " + s)
+		}
+		return from, from.AddDate(0, 1, 0), nil
+	}
+	return queryTimeRange(c)
+}
+
+func slaRow(kind string, s timeline.SLA) []string {
+	return []string{
+		kind,
+		s.NodeID,
+		s.NodeName,
+		strconv.Itoa(s.Lanes),
+		strconv.FormatFloat(s.Availability, 'f', 2, 64),
+		strconv.Itoa(s.Outages),
+		strconv.FormatInt(s.MTTR, 10),
+		strconv.FormatInt(s.Uptime, 10),
+		strconv.FormatInt(s.Downtime, 10),
+		strconv.FormatInt(s.Known, 10),
+	}
+}
+
+//responseCSV 以csv文件返回表格数据
+func responseCSV(c *gin.Context, fileName string, header []string, rows [][]string) {
+	buf := &bytes.Buffer{}
+	w := csv.NewWriter(buf)
+	w.Write(header)
+	w.WriteAll(rows)
+	if err := w.Error(); err != nil {
+		responseError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.Header("Content-Disposition", "attachment; filename="+fileName)
+	c.Data(http.StatusOK, "text/csv; charset=utf-8", buf.Bytes())
+}
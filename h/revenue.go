@@ -0,0 +1,36 @@
+package h
+
+import (
+	"net/http"
+	"tollsys/tollmon/datastruct"
+	"tollsys/tollmon/revenue"
+
+	"github.com/gin-gonic/gin"
+)
+
+//configRevenueRoute 收入统计路由配置
+//参数：stationId/plazaId/laneId 节点，均为空时查询全部车道
+func configRevenueRoute() {
+	//Revenue GET 查询车道当班、当日收入及广场、收费站合计
+	v1.GET("/Revenue", func(c *gin.Context) {
+		lanes, err := queryLanes(c)
+		if err != nil {
+			responseError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		sender := datastruct.NewCommonMessage()
+		sender.Data = revenue.GetSummary(lanes)
+		c.JSON(http.StatusOK, sender)
+	})
+	//Revenue/Reconcile GET 核对当日出口记录数与车道上报的SendStatus.ExitRecord
+	v1.GET("/Revenue/Reconcile", func(c *gin.Context) {
+		lanes, err := queryLanes(c)
+		if err != nil {
+			responseError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		sender := datastruct.NewCommonMessage()
+		sender.Data = revenue.Reconcile(lanes)
+		c.JSON(http.StatusOK, sender)
+	})
+}
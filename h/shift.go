@@ -0,0 +1,67 @@
+package h
+
+import (
+	"net/http"
+	"tollsys/tollmon/datastruct"
+	"tollsys/tollmon/shift"
+
+	"github.com/gin-gonic/gin"
+)
+
+//configShiftRoute 班次路由配置
+//查询参数：stationId 收费站节点；laneId 车道节点；empId 工号；from/to 上班日期(2006-01-02)，缺省为当日
+func configShiftRoute() {
+	//Shifts GET 查询班次记录
+	v1.GET("/Shifts", func(c *gin.Context) {
+		q, ok := shiftQuery(c)
+		if !ok {
+			return
+		}
+		sender := datastruct.NewCommonMessage()
+		sender.Data = shift.GetShifts(q)
+		c.JSON(http.StatusOK, sender)
+	})
+	//Shifts/Lanes GET 按车道汇总班次
+	v1.GET("/Shifts/Lanes", func(c *gin.Context) {
+		q, ok := shiftQuery(c)
+		if !ok {
+			return
+		}
+		sender := datastruct.NewCommonMessage()
+		sender.Data = shift.GetLaneReports(q)
+		c.JSON(http.StatusOK, sender)
+	})
+	//Shifts/Employees GET 按员工汇总班次
+	v1.GET("/Shifts/Employees", func(c *gin.Context) {
+		q, ok := shiftQuery(c)
+		if !ok {
+			return
+		}
+		sender := datastruct.NewCommonMessage()
+		sender.Data = shift.GetEmployeeReports(q)
+		c.JSON(http.StatusOK, sender)
+	})
+}
+
+//shiftQuery 解析班次查询参数，失败时直接响应错误
+func shiftQuery(c *gin.Context) (shift.Query, bool) {
+	q := shift.Query{}
+	var err error
+	if q.StationID, err = queryNodeID(c, "stationId", 16); err != nil {
+		responseError(c, http.StatusBadRequest, err.Error())
+		return q, false
+	}
+	if q.LaneID, err = queryNodeID(c, "laneId", 26); err != nil {
+		responseError(c, http.StatusBadRequest, err.Error())
+		return q, false
+	}
+	if q.EmpID, err = queryInt(c, "empId"); err != nil {
+		responseError(c, http.StatusBadRequest, err.Error())
+		return q, false
+	}
+	if q.From, q.To, err = queryDateRange(c); err != nil {
+		responseError(c, http.StatusBadRequest, err.Error())
+		return q, false
+	}
+	return q, true
+}
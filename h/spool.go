@@ -0,0 +1,19 @@
+package h
+
+import (
+	"net/http"
+	"tollsys/tollmon/datastruct"
+	"tollsys/tollmon/spool"
+
+	"github.com/gin-gonic/gin"
+)
+
+//configSpoolRoute 外发数据磁盘缓冲队列路由配置
+func configSpoolRoute() {
+	//Spool GET 查询各缓冲队列(upstream 上级转发，notify-{通道} 通知发送)的积压统计
+	v1.GET("/Spool", func(c *gin.Context) {
+		sender := datastruct.NewCommonMessage()
+		sender.Data = spool.GetStats()
+		c.JSON(http.StatusOK, sender)
+	})
+}
@@ -0,0 +1,57 @@
+package h
+
+import (
+	"net/http"
+	"strconv"
+	"tollsys/tollmon/datastruct"
+	"tollsys/tollmon/shift"
+
+	"github.com/gin-gonic/gin"
+)
+
+//configStaffingRoute 员工上岗记录路由配置
+func configStaffingRoute() {
+	//Employees/:id/Activity GET 查询员工上岗记录，from/to 为上班日期(2006-01-02)，缺省为当日
+	v1.GET("/Employees/:id/Activity", func(c *gin.Context) {
+		empID, err := strconv.Atoi(c.Param("id"))
+		if err != nil || empID == 0 {
+			responseError(c, http.StatusBadRequest, "invalid employee id "+c.Param("id"))
+			return
+		}
+		from, to, err := queryDateRange(c)
+		if err != nil {
+			responseError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		sender := datastruct.NewCommonMessage()
+		sender.Data = shift.GetActivity(empID, from, to)
+		c.JSON(http.StatusOK, sender)
+	})
+	//Lanes/:id/Staffing GET 查询车道在岗记录
+	//at 为时刻(2006-01-02 15:04:05)，查询该时刻的在岗班次；否则按from/to上班日期查询，缺省为当日
+	v1.GET("/Lanes/:id/Staffing", func(c *gin.Context) {
+		laneID := c.Param("id")
+		if len(laneID) < 26 {
+			responseError(c, http.StatusBadRequest, "invalid laneId "+laneID)
+			return
+		}
+		sender := datastruct.NewCommonMessage()
+		if s := c.Query("at"); s != "" {
+			at, err := parseQueryTime(s)
+			if err != nil {
+				responseError(c, http.StatusBadRequest, "invalid at time "+s)
+				return
+			}
+			sender.Data = shift.GetStaffing(laneID, at)
+			c.JSON(http.StatusOK, sender)
+			return
+		}
+		from, to, err := queryDateRange(c)
+		if err != nil {
+			responseError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		sender.Data = shift.GetShifts(shift.Query{LaneID: laneID, From: from, To: to})
+		c.JSON(http.StatusOK, sender)
+	})
+}
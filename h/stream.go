@@ -0,0 +1,97 @@
+package h
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+	"tollsys/tollmon/g"
+
+	"github.com/gin-gonic/gin"
+)
+
+//Server-Sent Events 实时数据推送
+//供无法使用WebSocket的看板及代理使用，订阅条件、报警策略及快照回放与WebSocket一致；
+//每条实时数据以seq作为事件id，浏览器断线重连时携带Last-Event-ID回放缺失的数据。
+//SSE为单向连接，订阅条件仅能在连接时通过参数指定
+
+//sseTransport SSE连接
+type sseTransport struct {
+	w gin.ResponseWriter
+}
+
+//write 以data字段写入一条数据，实时数据同时写入id字段
+func (t *sseTransport) write(i interface{}) error {
+	b, err := g.Json.Marshal(i)
+	if err != nil {
+		return err
+	}
+	var buffer bytes.Buffer
+	if m, ok := i.(*realtimeMessage); ok {
+		buffer.WriteString("id: " + strconv.FormatUint(m.Seq, 10) + "\n")
+	}
+	buffer.WriteString("data: ")
+	buffer.Write(b)
+	buffer.WriteString("\n\n")
+	return t.flush(buffer.Bytes())
+}
+
+//heartbeat 发送注释行作为心跳，客户端不会触发事件
+func (t *sseTransport) heartbeat() error {
+	return t.flush([]byte(": 0\n\n"))
+}
+
+func (t *sseTransport) flush(b []byte) error {
+	if _, err := t.w.Write(b); err != nil {
+		return err
+	}
+	t.w.Flush()
+	return nil
+}
+
+//Close 连接由streamHandle返回时关闭
+func (t *sseTransport) Close() error {
+	return nil
+}
+
+//configStreamRoute SSE实时数据路由配置
+func configStreamRoute() {
+	//stream GET 以Server-Sent Events推送实时数据，参数同WebSocket连接参数
+	//stationId/plazaId/laneId 订阅节点(逗号分隔)，未指定时使用session中请求的节点；catalogs/types/minLevel 过滤条件；
+	//profile/operator 报警策略；Last-Event-ID头或seq参数为断线前收到的最后序号
+	v1.GET("/stream", streamHandle)
+}
+
+//streamHandle SSE连接处理，当前goroutine负责发送直至客户端断开
+func streamHandle(c *gin.Context) {
+	conn := newRealtimeClient(&sseTransport{w: c.Writer}, c.Request.RemoteAddr)
+	seq, err := setupClient(c, conn)
+	if err != nil {
+		responseError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if id := c.GetHeader("Last-Event-ID"); id != "" {
+		v, err := strconv.ParseUint(id, 10, 64)
+		if err != nil {
+			responseError(c, http.StatusBadRequest, "invalid Last-Event-ID "+id)
+			return
+		}
+		seq = &v
+	}
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	go func() {
+		select {
+		case <-c.Request.Context().Done():
+			conn.close()
+		case <-conn.done:
+		}
+	}()
+	conn.resume(seq)
+	g.LogInfo(conn.addr, " stream registered, clients:", wsHub.count())
+	conn.writePump()
+}
@@ -0,0 +1,225 @@
+package h
+
+import (
+	"sort"
+	"tollsys/tollmon/datastruct"
+)
+
+//subscription 实时数据订阅条件
+//Stations/Plazas/Lanes 为订阅的收费站(16位)、广场(20位)、车道(26位)节点，满足其一即订阅该节点数据；
+//catalogs/types 为消息种类、类别过滤条件；minLevel 为报警的最低策略等级，为0时不过滤
+type subscription struct {
+	stations map[string]bool
+	plazas   map[string]bool
+	lanes    map[string]bool
+	catalogs intFilter
+	types    intFilter
+	minLevel int
+}
+
+//subscriptionView 订阅条件，用于回复客户端
+type subscriptionView struct {
+	Stations []string `json:"stations"`
+	Plazas   []string `json:"plazas"`
+	Lanes    []string `json:"lanes"`
+	Catalogs []int    `json:"catalogs,omitempty"`
+	Types    []int    `json:"types,omitempty"`
+	MinLevel int      `json:"minLevel"`
+	Excluded struct {
+		Catalogs []int `json:"catalogs,omitempty"`
+		Types    []int `json:"types,omitempty"`
+	} `json:"excluded"`
+}
+
+func newSubscription() subscription {
+	return subscription{
+		stations: make(map[string]bool),
+		plazas:   make(map[string]bool),
+		lanes:    make(map[string]bool),
+		catalogs: newIntFilter(),
+		types:    newIntFilter(),
+	}
+}
+
+//intFilter 整型过滤条件
+//only 为nil时不限制，仅排除except中的值；否则仅允许only中的值
+//订阅时首次指定值即由不限制转为仅允许指定值；取消订阅时从允许值中移除，不限制时加入排除值
+type intFilter struct {
+	only   map[int]bool
+	except map[int]bool
+}
+
+func newIntFilter() intFilter {
+	return intFilter{except: make(map[int]bool)}
+}
+
+func (f *intFilter) add(list []int) {
+	for _, v := range list {
+		delete(f.except, v)
+		if f.only == nil {
+			f.only = make(map[int]bool)
+		}
+		f.only[v] = true
+	}
+}
+
+func (f *intFilter) remove(list []int) {
+	for _, v := range list {
+		if f.only != nil {
+			delete(f.only, v)
+		} else {
+			f.except[v] = true
+		}
+	}
+}
+
+func (f *intFilter) match(v int) bool {
+	if f.only != nil {
+		return f.only[v]
+	}
+	return !f.except[v]
+}
+
+//subscribe 添加订阅节点及消息种类、类别，节点编码长度不足时返回false
+func (s *subscription) subscribe(c *wsControl) bool {
+	return s.update(c, true)
+}
+
+//unsubscribe 取消订阅节点及消息种类、类别，节点编码长度不足时返回false
+func (s *subscription) unsubscribe(c *wsControl) bool {
+	return s.update(c, false)
+}
+
+func (s *subscription) update(c *wsControl, add bool) bool {
+	ok := setNodes(s.stations, c.Stations, 16, add)
+	ok = setNodes(s.plazas, c.Plazas, 20, add) && ok
+	ok = setNodes(s.lanes, c.Lanes, 26, add) && ok
+	if add {
+		s.catalogs.add(c.Catalogs)
+		s.types.add(c.Types)
+		if c.MinLevel != nil {
+			s.minLevel = *c.MinLevel
+		}
+	} else {
+		s.catalogs.remove(c.Catalogs)
+		s.types.remove(c.Types)
+		if c.MinLevel != nil {
+			s.minLevel = 0
+		}
+	}
+	return ok
+}
+
+//addNodes 按节点编码长度添加订阅节点：不少于26位为车道，不少于20位为广场，否则为收费站
+//用于session中保存的请求节点及连接参数，节点编码长度不足16位时返回false
+func (s *subscription) addNodes(ids []string) bool {
+	ok := true
+	for _, id := range ids {
+		switch {
+		case len(id) >= 26:
+			s.lanes[id[0:26]] = true
+		case len(id) >= 20:
+			s.plazas[id[0:20]] = true
+		case len(id) >= 16:
+			s.stations[id[0:16]] = true
+		default:
+			ok = false
+		}
+	}
+	return ok
+}
+
+//setNodes 按节点编码前n位添加或移除节点
+func setNodes(m map[string]bool, ids []string, n int, add bool) bool {
+	ok := true
+	for _, id := range ids {
+		if len(id) < n {
+			ok = false
+			continue
+		}
+		if add {
+			m[id[0:n]] = true
+		} else {
+			delete(m, id[0:n])
+		}
+	}
+	return ok
+}
+
+//matchNode 判断是否订阅该收费站或车道节点，laneID为空时仅按收费站判断
+func (s *subscription) matchNode(stationID string, laneID string) bool {
+	if s.stations[stationID] {
+		return true
+	}
+	if len(laneID) >= 20 && s.plazas[laneID[0:20]] {
+		return true
+	}
+	return laneID != "" && s.lanes[laneID]
+}
+
+//match 判断实时数据是否满足订阅条件，level为报警的策略等级
+func (s *subscription) match(stationID string, d interface{}, level int) bool {
+	v, ok := d.(datastruct.MsgSend)
+	if !ok {
+		return s.stations[stationID]
+	}
+	if !s.matchNode(stationID, v.MsgLane) || !s.catalogs.match(v.MsgCatalog) || !s.types.match(v.MsgType) {
+		return false
+	}
+	return v.MsgCatalog != datastruct.MSGCATALOG_Alert || level >= s.minLevel
+}
+
+//empty 是否未订阅任何节点
+func (s *subscription) empty() bool {
+	return len(s.stations) == 0 && len(s.plazas) == 0 && len(s.lanes) == 0
+}
+
+//stationIds 订阅的收费站节点
+func (s *subscription) stationIds() []string {
+	return keys(s.stations)
+}
+
+//stationKeys 订阅涉及的收费站，包括所订阅广场、车道所属的收费站
+func (s *subscription) stationKeys() []string {
+	m := make(map[string]bool)
+	for id := range s.stations {
+		m[id] = true
+	}
+	for id := range s.plazas {
+		m[id[0:16]] = true
+	}
+	for id := range s.lanes {
+		m[id[0:16]] = true
+	}
+	return keys(m)
+}
+
+func (s *subscription) view() subscriptionView {
+	v := subscriptionView{Stations: keys(s.stations), Plazas: keys(s.plazas), Lanes: keys(s.lanes), MinLevel: s.minLevel}
+	v.Catalogs = intKeys(s.catalogs.only)
+	v.Types = intKeys(s.types.only)
+	v.Excluded.Catalogs = intKeys(s.catalogs.except)
+	v.Excluded.Types = intKeys(s.types.except)
+	return v
+}
+
+func keys(m map[string]bool) []string {
+	list := make([]string, 0, len(m))
+	for k := range m {
+		list = append(list, k)
+	}
+	sort.Strings(list)
+	return list
+}
+
+func intKeys(m map[int]bool) []int {
+	if m == nil {
+		return nil
+	}
+	list := make([]int, 0, len(m))
+	for k := range m {
+		list = append(list, k)
+	}
+	sort.Ints(list)
+	return list
+}
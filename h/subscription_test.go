@@ -0,0 +1,148 @@
+package h
+
+import (
+	"testing"
+	"tollsys/tollmon/datastruct"
+)
+
+const (
+	testStation = "1F01010400010000"
+	testPlaza   = "1F010104000100000001"
+	testLane    = "1F010104000100000001000001"
+	otherPlaza  = "1F010104000100000002"
+	otherLane   = "1F010104000100000002000001"
+)
+
+func TestIntFilter(t *testing.T) {
+	cases := []struct {
+		name   string
+		add    []int
+		remove []int
+		match  map[int]bool
+	}{
+		{"unrestricted", nil, nil, map[int]bool{1: true, 2: true}},
+		{"except without only", nil, []int{1}, map[int]bool{1: false, 2: true}},
+		{"add switches to only", []int{1}, nil, map[int]bool{1: true, 2: false}},
+		{"add clears except", []int{1}, []int{2}, map[int]bool{1: true, 2: false, 3: false}},
+		{"remove from only", []int{1, 2}, []int{2}, map[int]bool{1: true, 2: false}},
+	}
+	for _, c := range cases {
+		f := newIntFilter()
+		f.add(c.add)
+		f.remove(c.remove)
+		for v, want := range c.match {
+			if got := f.match(v); got != want {
+				t.Errorf("%s: match(%d) = %v, want %v", c.name, v, got, want)
+			}
+		}
+	}
+
+	f := newIntFilter()
+	f.remove([]int{1})
+	f.add([]int{1})
+	if !f.match(1) || f.except[1] {
+		t.Errorf("re-added value still excluded: %+v", f)
+	}
+}
+
+func TestSubscriptionMatch(t *testing.T) {
+	level := 2
+	s := newSubscription()
+	s.subscribe(&wsControl{Plazas: []string{testPlaza}, Catalogs: []int{datastruct.MSGCATALOG_Alert, datastruct.MSGCATALOG_Data}, MinLevel: &level})
+	alert := func(laneID string) datastruct.MsgSend {
+		return datastruct.MsgSend{MsgCatalog: datastruct.MSGCATALOG_Alert, MsgType: datastruct.MSGTYPE_EntryCard, MsgLane: laneID}
+	}
+	cases := []struct {
+		name  string
+		d     interface{}
+		level int
+		want  bool
+	}{
+		{"plaza lane", alert(testLane), 2, true},
+		{"other plaza lane", alert(otherLane), 2, false},
+		{"below min level", alert(testLane), 1, false},
+		{"data ignores min level", datastruct.MsgSend{MsgCatalog: datastruct.MSGCATALOG_Data, MsgLane: testLane}, 0, true},
+		{"catalog not subscribed", datastruct.MsgSend{MsgCatalog: datastruct.MSGCATALOG_Test, MsgLane: testLane}, 0, false},
+		{"station data without station subscription", newCoreDataDelta(testStation), 0, false},
+	}
+	for _, c := range cases {
+		if got := s.match(testStation, c.d, c.level); got != c.want {
+			t.Errorf("%s: match = %v, want %v", c.name, got, c.want)
+		}
+	}
+
+	s.unsubscribe(&wsControl{MinLevel: &level})
+	if !s.match(testStation, alert(testLane), 1) {
+		t.Errorf("min level not reset on unsubscribe")
+	}
+	s.subscribe(&wsControl{Stations: []string{testStation}})
+	if !s.match(testStation, alert(otherLane), 0) || !s.match(testStation, newCoreDataDelta(testStation), 0) {
+		t.Errorf("station subscription does not match its lanes")
+	}
+	s.unsubscribe(&wsControl{Plazas: []string{testPlaza}, Stations: []string{testStation}, Lanes: []string{otherLane}})
+	s.subscribe(&wsControl{Lanes: []string{otherLane}})
+	if s.match(testStation, alert(testLane), 0) || !s.match(testStation, alert(otherLane), 0) {
+		t.Errorf("lane subscription matches other lanes")
+	}
+}
+
+func TestCoreDataDeltaFilter(t *testing.T) {
+	d := newCoreDataDelta(testStation)
+	d.MsgTime = "2020-03-01 08:00:00"
+	d.MsgContent[testLane] = map[string]interface{}{"pass": 10, "card": 5}
+	d.MsgContent[otherLane] = map[string]interface{}{"pass": 20}
+	d.types["pass"] = 1
+	d.types["card"] = 2
+
+	cases := []struct {
+		name    string
+		control wsControl
+		same    bool
+		want    map[string][]string
+	}{
+		{"all matched", wsControl{Stations: []string{testStation}}, true, nil},
+		{"catalog excluded", wsControl{Stations: []string{testStation}, Catalogs: []int{datastruct.MSGCATALOG_Alert}}, false, nil},
+		{"plaza lanes only", wsControl{Plazas: []string{testPlaza}}, false, map[string][]string{testLane: {"card", "pass"}}},
+		{"partial metrics", wsControl{Stations: []string{testStation}, Types: []int{1}}, false, map[string][]string{testLane: {"pass"}, otherLane: {"pass"}}},
+		{"lane and metric", wsControl{Lanes: []string{testLane}, Types: []int{2}}, false, map[string][]string{testLane: {"card"}}},
+		{"no metric matched", wsControl{Lanes: []string{otherLane}, Types: []int{2}}, false, nil},
+	}
+	for _, c := range cases {
+		s := newSubscription()
+		s.subscribe(&c.control)
+		f := d.filter(&s)
+		if c.same {
+			if f != d {
+				t.Errorf("%s: got filtered copy, want original", c.name)
+			}
+			continue
+		}
+		if c.want == nil {
+			if f != nil {
+				t.Errorf("%s: got %v, want nil", c.name, f.MsgContent)
+			}
+			continue
+		}
+		if f == nil || f == d {
+			t.Errorf("%s: got %v, want filtered copy", c.name, f)
+			continue
+		}
+		if f.MsgTime != d.MsgTime || f.MsgStation != d.MsgStation || len(f.MsgContent) != len(c.want) {
+			t.Errorf("%s: got %+v, want lanes %v", c.name, f, c.want)
+			continue
+		}
+		for laneID, metrics := range c.want {
+			if lane := f.MsgContent[laneID]; len(lane) != len(metrics) {
+				t.Errorf("%s: lane %s got %v, want %v", c.name, laneID, lane, metrics)
+			}
+			for _, metric := range metrics {
+				if f.MsgContent[laneID][metric] != d.MsgContent[laneID][metric] {
+					t.Errorf("%s: lane %s %s got %v", c.name, laneID, metric, f.MsgContent[laneID][metric])
+				}
+			}
+		}
+	}
+	if len(d.MsgContent[testLane]) != 2 {
+		t.Errorf("filter changed original delta: %v", d.MsgContent)
+	}
+}
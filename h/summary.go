@@ -0,0 +1,106 @@
+package h
+
+import (
+	"net/http"
+	"time"
+	"tollsys/tollmon/datastruct"
+	"tollsys/tollmon/g"
+	"tollsys/tollmon/summary"
+
+	"github.com/gin-gonic/gin"
+)
+
+//configSummaryRoute 收费站每日汇总报表路由配置
+func configSummaryRoute() {
+	//Summaries GET 查询已生成的汇总报表
+	//stationId 收费站节点，为空时查询全部收费站；from/to 为日期(2006-01-02)，缺省为当日
+	v1.GET("/Summaries", func(c *gin.Context) {
+		stationID, err := queryNodeID(c, "stationId", 16)
+		if err != nil {
+			responseError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		from, to, err := queryDateRange(c)
+		if err != nil {
+			responseError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		sender := datastruct.NewCommonMessage()
+		sender.Data = summary.GetSummaries(stationID, from, to)
+		c.JSON(http.StatusOK, sender)
+	})
+	//Summaries/:stationId/:date GET 获取收费站某日汇总报表，format=html 返回html文件，缺省为json
+	v1.GET("/Summaries/:stationId/:date", func(c *gin.Context) {
+		stationID := c.Param("stationId")
+		if len(stationID) < 16 {
+			responseError(c, http.StatusBadRequest, "invalid stationId "+stationID)
+			return
+		}
+		day, err := time.ParseInLocation(dateFormat, c.Param("date"), time.Local)
+		if err != nil {
+			responseError(c, http.StatusBadRequest, "invalid date "+c.Param("date"))
+			return
+		}
+		if c.Query("format") == "html" {
+			b, err := summary.GetHTML(stationID, day)
+			if err != nil {
+				responseError(c, http.StatusNotFound, "summary not found")
+				return
+			}
+			c.Data(http.StatusOK, "text/html; charset=utf-8", b)
+			return
+		}
+		list := summary.GetSummaries(stationID, day, day)
+		if len(list) == 0 {
+			responseError(c, http.StatusNotFound, "summary not found")
+			return
+		}
+		sender := datastruct.NewCommonMessage()
+		sender.Data = list[0]
+		c.JSON(http.StatusOK, sender)
+	})
+	//Summaries POST 立即生成收费站某日汇总报表，deliver为true时通过通知通道发送
+	//data: {"stationId":"","date":"2006-01-02","deliver":false}
+	v1.POST("/Summaries", func(c *gin.Context) {
+		type Request struct {
+			StationID string `json:"stationId"`
+			Date      string `json:"date"`
+			Deliver   bool   `json:"deliver"`
+		}
+		type Rcvd struct {
+			Code   int     `json:"code"`
+			ErrMsg string  `json:"errMsg"`
+			Data   Request `json:"data"`
+			Status bool    `json:"status"`
+		}
+		if c.Request.ContentLength == 0 {
+			c.JSON(http.StatusBadRequest, datastruct.ERRORMSG_BlankBody)
+			c.Abort()
+			return
+		}
+		r := Rcvd{}
+		decoder := g.Json.NewDecoder(c.Request.Body)
+		if err := decoder.Decode(&r); err != nil {
+			c.JSON(http.StatusBadRequest, datastruct.ERRORMSG_DecoderError)
+			c.Abort()
+			return
+		}
+		day, err := time.ParseInLocation(dateFormat, r.Data.Date, time.Local)
+		if err != nil {
+			responseError(c, http.StatusBadRequest, "invalid date "+r.Data.Date)
+			return
+		}
+		s, err := summary.Generate(r.Data.StationID, day)
+		if err != nil {
+			responseError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		g.LogInfo(c.Request.RemoteAddr, " generate summary ", s.StationID, " ", s.Date)
+		if r.Data.Deliver {
+			summary.Deliver(s)
+		}
+		sender := datastruct.NewCommonMessage()
+		sender.Data = s
+		c.JSON(http.StatusOK, sender)
+	})
+}
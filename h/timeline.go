@@ -0,0 +1,47 @@
+package h
+
+import (
+	"net/http"
+	"tollsys/tollmon/datastruct"
+	"tollsys/tollmon/timeline"
+
+	"github.com/gin-gonic/gin"
+)
+
+//configTimelineRoute 车道状态时间线路由配置
+//参数：stationId/plazaId/laneId 节点，均为空时查询全部车道
+func configTimelineRoute() {
+	//Timeline GET 查询车道通讯连接、车道开闭、上下班状态区间
+	//from/to 为时间(2006-01-02 15:04:05)或日期，缺省为当日零点至当前时间
+	v1.GET("/Timeline", func(c *gin.Context) {
+		lanes, err := queryLanes(c)
+		if err != nil {
+			responseError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		from, to, err := queryTimeRange(c)
+		if err != nil {
+			responseError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		sender := datastruct.NewCommonMessage()
+		sender.Data = timeline.GetTimelines(lanes, from, to)
+		c.JSON(http.StatusOK, sender)
+	})
+	//Timeline/Availability GET 按日查询车道可用率，from/to 为日期(2006-01-02)，缺省为当日
+	v1.GET("/Timeline/Availability", func(c *gin.Context) {
+		lanes, err := queryLanes(c)
+		if err != nil {
+			responseError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		from, to, err := queryDateRange(c)
+		if err != nil {
+			responseError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		sender := datastruct.NewCommonMessage()
+		sender.Data = timeline.GetAvailability(lanes, from, to)
+		c.JSON(http.StatusOK, sender)
+	})
+}
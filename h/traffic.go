@@ -0,0 +1,50 @@
+package h
+
+import (
+	"net/http"
+	"tollsys/tollmon/datastruct"
+	"tollsys/tollmon/traffic"
+
+	"github.com/gin-gonic/gin"
+)
+
+//configTrafficRoute 车道流量时间序列路由配置
+func configTrafficRoute() {
+	//Traffic GET 查询车道流量时间序列
+	//参数：stationId/plazaId/laneId 节点；step 统计粒度minute/hour/day，缺省minute；
+	//from/to 时间范围，minute不超过2天，hour不超过93天，day不超过3年
+	//group=lane 时按车道分别返回，否则返回所选车道合计
+	v1.GET("/Traffic", func(c *gin.Context) {
+		step := c.DefaultQuery("step", traffic.Minute)
+		if step != traffic.Minute && step != traffic.Hour && step != traffic.Day {
+			responseError(c, http.StatusBadRequest, "invalid step "+step)
+			return
+		}
+		lanes, err := queryLanes(c)
+		if err != nil {
+			responseError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		from, to, err := queryTimeRange(c)
+		if err != nil {
+			responseError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		if to.Sub(from) > traffic.MaxSpan(step) {
+			responseError(c, http.StatusBadRequest, "time range too large for step "+step)
+			return
+		}
+		from = traffic.Truncate(step, from)
+		list := make([]traffic.LaneSeries, 0)
+		for _, lane := range lanes {
+			list = append(list, traffic.LaneSeries{LaneID: lane.NodeID, Points: traffic.Range(step, lane.NodeID, from, to)})
+		}
+		sender := datastruct.NewCommonMessage()
+		if c.Query("group") == "lane" {
+			sender.Data = list
+		} else {
+			sender.Data = traffic.Merge(list)
+		}
+		c.JSON(http.StatusOK, sender)
+	})
+}
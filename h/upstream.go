@@ -0,0 +1,261 @@
+package h
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"time"
+	"tollsys/tollmon/datastruct"
+	"tollsys/tollmon/g"
+	"tollsys/tollmon/parameters"
+	"tollsys/tollmon/spool"
+
+	"github.com/gorilla/websocket"
+)
+
+//上级转发
+//upstream.enabled 时本实例作为下级，以WebSocket长连接将节点树、实时数据及LaneInfo/CoreData变化转发至上级tollmon的/v1/ingest。
+//连接时以Authorization: Bearer {token}认证，断开后间隔retry秒重连；连接建立后先发送节点树，
+//之后发送实时数据，并按心跳周期发送ping及变化的LaneInfo/CoreData。消息格式同多实例转发(relayMessage)
+//实时数据经磁盘缓冲队列(spool)发送，断线期间保留在磁盘，重连后在节点树之后按原顺序补发，
+//补发完成后再发送全部LaneInfo/CoreData，避免过期的实时数据覆盖上级的最新状态
+
+const (
+	relayNodes    = "nodes"
+	relayLaneInfo = "laneInfo"
+	relayCoreData = "coreData"
+
+	defaultUpstreamRetry = 5
+	upstreamBatch        = 100
+)
+
+//upstreamQueue 待写入磁盘缓冲队列的实时数据，写满后丢弃
+var upstreamQueue = make(chan []byte, defaultQueueSize)
+
+//forwardUpstream 编码实时数据并写入转发队列，不阻塞实时数据处理
+func forwardUpstream(stationID string, data interface{}) {
+	b, err := encodeRelay(stationID, data)
+	if err != nil {
+		g.LogError("upstream marshal err:", err.Error())
+		return
+	}
+	select {
+	case upstreamQueue <- b:
+	default:
+		g.LogError("upstream queue full, drop ", stationID)
+	}
+}
+
+//runUpstream 保持与上级的连接，不返回
+func runUpstream() {
+	cfg := g.Config().Upstream
+	q, err := spool.Open("upstream")
+	if err != nil {
+		g.LogError("upstream open spool err:", err.Error())
+		return
+	}
+	go spoolUpstream(q)
+	retry := time.Duration(cfg.Retry) * time.Second
+	if retry <= 0 {
+		retry = defaultUpstreamRetry * time.Second
+	}
+	for {
+		err := upstreamSession(cfg, q)
+		g.LogError("upstream ", cfg.URL, " err:", err.Error())
+		time.Sleep(retry)
+	}
+}
+
+//spoolUpstream 将转发队列中的实时数据写入磁盘缓冲队列，不返回
+func spoolUpstream(q *spool.Queue) {
+	for b := range upstreamQueue {
+		if err := q.Push(b); err != nil {
+			g.LogError("upstream spool err:", err.Error())
+		}
+	}
+}
+
+//upstreamSession 建立一次上级连接并转发数据，连接断开时返回
+func upstreamSession(cfg *g.UpstreamConfig, q *spool.Queue) error {
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+cfg.Token)
+	ws, resp, err := websocket.DefaultDialer.Dial(cfg.URL, header)
+	if err != nil {
+		if resp != nil {
+			return errors.New(err.Error() + " " + resp.Status)
+		}
+		return err
+	}
+	defer ws.Close()
+	g.LogInfo("upstream connected ", cfg.URL)
+
+	//读取goroutine处理上级的pong及close，读取失败时结束连接
+	errc := make(chan error, 1)
+	t := &wsTransport{conn: ws, encoding: EncodingJSON}
+	t.readDeadline()
+	ws.SetPongHandler(func(string) error {
+		t.readDeadline()
+		return nil
+	})
+	go func() {
+		for {
+			if _, _, err := ws.ReadMessage(); err != nil {
+				errc <- err
+				return
+			}
+			t.readDeadline()
+		}
+	}()
+
+	sent := newUpstreamState()
+	write := func(stationID string, kind string, data interface{}) error {
+		return upstreamWrite(ws, stationID, kind, data)
+	}
+	for _, station := range parameters.GetStationTrees() {
+		if err := upstreamWrite(ws, station.Station.NodeID[0:16], relayNodes, station); err != nil {
+			return err
+		}
+	}
+	ticker := time.NewTicker(interval())
+	defer ticker.Stop()
+	//补发积压期间仅发送心跳
+	for {
+		n, err := upstreamDrain(ws, q)
+		if err != nil {
+			return err
+		}
+		if n < upstreamBatch {
+			break
+		}
+		select {
+		case err := <-errc:
+			return err
+		case <-ticker.C:
+			if err := t.heartbeat(); err != nil {
+				return err
+			}
+		default:
+		}
+	}
+	if err := sent.sync(write); err != nil {
+		return err
+	}
+	tick := func() error {
+		if err := t.heartbeat(); err != nil {
+			return err
+		}
+		return sent.sync(write)
+	}
+	for {
+		n, err := upstreamDrain(ws, q)
+		if err != nil {
+			return err
+		}
+		//积压未发送完时不等待新数据，仅处理连接错误及心跳
+		if n == upstreamBatch {
+			select {
+			case err := <-errc:
+				return err
+			case <-ticker.C:
+				if err := tick(); err != nil {
+					return err
+				}
+			default:
+			}
+			continue
+		}
+		select {
+		case err := <-errc:
+			return err
+		case <-q.Ready():
+		case <-ticker.C:
+			if err := tick(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+//upstreamDrain 按顺序发送磁盘缓冲队列中至多upstreamBatch条数据，发送成功后确认，返回发送的条数
+func upstreamDrain(ws *websocket.Conn, q *spool.Queue) (int, error) {
+	n := 0
+	for n < upstreamBatch {
+		b, ok, err := q.Peek()
+		if err != nil || !ok {
+			return n, err
+		}
+		ws.SetWriteDeadline(time.Now().Add(writeWait))
+		if err := ws.WriteMessage(websocket.TextMessage, b); err != nil {
+			return n, err
+		}
+		q.Commit()
+		n++
+	}
+	return n, nil
+}
+
+//upstreamState 已发送至上级(或多实例转发已发布)的LaneInfo/CoreData，用于仅发送变化的项
+type upstreamState struct {
+	laneInfo map[string]map[string]interface{}
+	coreData map[string]map[string]interface{}
+}
+
+func newUpstreamState() *upstreamState {
+	return &upstreamState{laneInfo: make(map[string]map[string]interface{}), coreData: make(map[string]map[string]interface{})}
+}
+
+//sync 以write发送与上次发送相比变化的LaneInfo/CoreData，按收费站分组发送，发送成功后记录
+func (s *upstreamState) sync(write func(stationID string, kind string, data interface{}) error) error {
+	for _, station := range parameters.GetStationTrees() {
+		stationID := station.Station.NodeID[0:16]
+		infos := make([]datastruct.LaneInfo, 0)
+		for _, info := range parameters.GetLaneInfoByStationID(stationID) {
+			info = info.Copy()
+			if !reflect.DeepEqual(s.laneInfo[info.Node.NodeID], info.Info) {
+				infos = append(infos, info)
+			}
+		}
+		datas := make([]datastruct.CoreData, 0)
+		for _, data := range parameters.GetCoreDataByStationID(stationID) {
+			data = data.Copy()
+			if !reflect.DeepEqual(s.coreData[data.Node.NodeID], data.CoreData) {
+				datas = append(datas, data)
+			}
+		}
+		if len(infos) != 0 {
+			if err := write(stationID, relayLaneInfo, infos); err != nil {
+				return err
+			}
+			for _, info := range infos {
+				s.laneInfo[info.Node.NodeID] = info.Info
+			}
+		}
+		if len(datas) != 0 {
+			if err := write(stationID, relayCoreData, datas); err != nil {
+				return err
+			}
+			for _, data := range datas {
+				s.coreData[data.Node.NodeID] = data.CoreData
+			}
+		}
+	}
+	return nil
+}
+
+//encodeState 编码节点树、LaneInfo/CoreData等状态消息
+func encodeState(stationID string, kind string, data interface{}) ([]byte, error) {
+	b, err := g.Json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	return g.Json.Marshal(relayMessage{Origin: instanceID, StationID: stationID, Kind: kind, Data: b})
+}
+
+func upstreamWrite(ws *websocket.Conn, stationID string, kind string, data interface{}) error {
+	b, err := encodeState(stationID, kind, data)
+	if err != nil {
+		return err
+	}
+	ws.SetWriteDeadline(time.Now().Add(writeWait))
+	return ws.WriteMessage(websocket.TextMessage, b)
+}
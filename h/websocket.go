@@ -1,9 +1,10 @@
 package h
 
 import (
+	"errors"
 	"net/http"
-	"sync"
-	"time"
+	"strconv"
+	"strings"
 	"tollsys/tollmon/datastruct"
 	"tollsys/tollmon/g"
 	"tollsys/tollmon/parameters"
@@ -12,33 +13,14 @@ import (
 	"github.com/gorilla/websocket"
 )
 
-type webSocketClient struct {
-	lock          *sync.Mutex
-	client        *websocket.Conn
-	requestIds    map[string]bool
-	strategyItems map[int]datastruct.StrategyItem
-	stop          chan bool
-}
-
-func (w *webSocketClient) write(i interface{}) error {
-	w.lock.Lock()
-	defer w.lock.Unlock()
-	return w.client.WriteJSON(i)
-}
-func newWebSocketClient() webSocketClient {
-	return webSocketClient{lock: &sync.Mutex{}, requestIds: make(map[string]bool, 0),
-		strategyItems: make(map[int]datastruct.StrategyItem),
-		stop:          make(chan bool)}
-}
-
 var (
-	upGrader   websocket.Upgrader
-	clientList = make(map[*webSocketClient]bool)
+	upGrader websocket.Upgrader
 )
 
-//初始化webSocket升级方法，允许跨域访问
+//初始化webSocket升级方法，允许跨域访问，支持msgpack、json子协议
 func init() {
 	upGrader = websocket.Upgrader{
+		Subprotocols: []string{EncodingMsgpack, EncodingJSON},
 		CheckOrigin: func(r *http.Request) bool {
 			return true
 		}}
@@ -52,13 +34,11 @@ func configWebSocketHandle() {
 }
 
 //webSocket出路模块
-//获取webSocket连接并通过cookie获取session中请求收费站信息和报警策略
-//绑定webSocket-请求站点；webSocket-报警策略
-//通过goroutine启动实时数据处理模块
-//阻塞操作-心跳检测发送停止信号时将终止该goroutine
+//获取webSocket连接，按session及连接参数设置订阅条件和报警策略(见setupClient)
+//注册至hub并以goroutine启动发送及ping心跳，当前goroutine持续读取并处理客户端控制消息，
+//超过readWaitTimes个心跳周期未收到消息或pong、连接断开或收到close时发送close控制帧并关闭连接
 func wsHandle(c *gin.Context) {
 	g.LogDebug("handle webSocket...")
-	conn := newWebSocketClient()
 	ws, err := upGrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
 		g.LogError("ws upgrade err:", err.Error())
@@ -67,97 +47,135 @@ func wsHandle(c *gin.Context) {
 	}
 	defer ws.Close()
 	g.LogDebug("webSocket conn from ", ws.RemoteAddr())
-	session := Manager.GetSession(c)
-	if session.Data == nil {
-		lock.Lock()
-		ws.WriteJSON("nil session")
-		lock.Unlock()
-		return
-	}
-	conn.client = ws
-	temp := session.Data["requestIds"]
-	bTemp, _ := g.Json.Marshal(temp)
-	ids := make([]string, 0)
-	err = g.Json.Unmarshal(bTemp, &ids)
+	t := &wsTransport{conn: ws, encoding: negotiateEncoding(c, ws)}
+	conn := newRealtimeClient(t, ws.RemoteAddr().String())
+	seq, err := setupClient(c, conn)
 	if err != nil {
-		g.LogError(err)
+		t.write(err.Error())
 		return
 	}
-	if len(ids) != 0 {
-		for _, stationIds := range ids {
-			conn.requestIds[stationIds] = true
+
+	defer conn.close()
+	go conn.writePump()
+	conn.resume(seq)
+	g.LogInfo(ws.RemoteAddr(), " registered, clients:", wsHub.count())
+	t.readDeadline()
+	ws.SetPongHandler(func(string) error {
+		t.readDeadline()
+		return nil
+	})
+	for {
+		messageType, b, err := ws.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				g.LogError("ws:", conn.addr, " read err:", err.Error())
+			} else {
+				g.LogDebug("ws read err:", err.Error())
+			}
+			return
+		}
+		t.readDeadline()
+		if b, err = decodeMessage(messageType, b); err != nil {
+			conn.replyError(datastruct.ERRORMSG_DecoderError)
+			continue
+		}
+		if !conn.handleControl(b) {
+			return
 		}
-	} else {
-		conn.client.WriteJSON("nil requestIds")
-		conn.client.Close()
-		return
 	}
-	g.LogInfo(conn.client.RemoteAddr(), ":requestIds - ", conn.requestIds)
+}
 
-	temp = session.Data["StrategyItems"]
-	bTemp, _ = g.Json.Marshal(temp)
-	//fmt.Println(string(bTemp))
+//setupClient 按session及连接参数设置客户端订阅条件和报警策略，返回断线前收到的最后序号
+//session中的请求节点按编码长度区分收费站、广场、车道；连接参数stationId/plazaId/laneId(逗号分隔)追加订阅节点，
+//catalogs/types(逗号分隔)、minLevel 为初始过滤条件；seq 为断线前收到的最后序号，连接后先发送快照，携带seq时回放其后缓存的数据
+func setupClient(c *gin.Context, conn *realtimeClient) (*uint64, error) {
+	session := Manager.GetSession(c)
 	items := make(map[int]datastruct.StrategyItem)
-	err = g.Json.Unmarshal(bTemp, &items)
+	if session != nil && session.Data != nil {
+		conn.sub.addNodes(sessionRequestIds(session))
+		bTemp, _ := g.Json.Marshal(session.Data[datastruct.KEY_StrategyItems])
+		if err := g.Json.Unmarshal(bTemp, &items); err != nil {
+			g.LogError(err)
+			return nil, err
+		}
+	}
+	seq, err := querySeq(c)
 	if err != nil {
-		g.LogError(err)
-		return
+		return nil, err
+	}
+	if err := queryFilter(c, &conn.sub); err != nil {
+		return nil, err
+	}
+	if conn.sub.empty() {
+		if session == nil {
+			return nil, errors.New("nil session")
+		}
+		return nil, errors.New("nil requestIds")
+	}
+	g.LogInfo(conn.addr, ":subscription - ", conn.sub.view())
+
+	//报警策略优先级：连接参数profile指定的方案 > session选择的方案 > session中自定义的报警策略 > 操作员/收费站方案 > 默认方案
+	profileName := c.Query("profile")
+	if profileName == "" {
+		profileName = sessionString(session, datastruct.KEY_StrategyProfile)
 	}
-	if len(items) != 0 {
+	operator := c.Query("operator")
+	if operator == "" {
+		operator = sessionString(session, datastruct.KEY_Operator)
+	}
+	conn.operator = operator
+	if p, ok := parameters.GetStrategyProfile(profileName); ok {
+		conn.strategyItems = p.TypeToItems()
+	} else if len(items) != 0 {
 		conn.strategyItems = items
 	} else {
-		conn.strategyItems = parameters.GetTypeToStrategyItems()
+		conn.strategyItems = parameters.ResolveStrategyProfile(operator, conn.sub.stationKeys()).TypeToItems()
 	}
-	g.LogInfo(conn.client.RemoteAddr(), ":strategyItems - ", conn.strategyItems)
+	g.LogInfo(conn.addr, ":strategyItems - ", conn.strategyItems)
+	return seq, nil
+}
 
-	clientList[&conn] = true
-	exit := false
-	go func() {
-		if exit {
-			return
-		}
-		a := datastruct.NewCommonMessage()
-		err := conn.client.ReadJSON(a)
+//queryFilter 按连接参数添加订阅节点及过滤条件
+func queryFilter(c *gin.Context, sub *subscription) error {
+	ctl := &wsControl{}
+	if s := c.Query("stationId"); s != "" {
+		ctl.Stations = strings.Split(s, ",")
+	}
+	if s := c.Query("plazaId"); s != "" {
+		ctl.Plazas = strings.Split(s, ",")
+	}
+	if s := c.Query("laneId"); s != "" {
+		ctl.Lanes = strings.Split(s, ",")
+	}
+	var err error
+	if ctl.Catalogs, err = queryInts(c, "catalogs"); err != nil {
+		return err
+	}
+	if ctl.Types, err = queryInts(c, "types"); err != nil {
+		return err
+	}
+	if c.Query("minLevel") != "" {
+		level, err := queryInt(c, "minLevel")
 		if err != nil {
-			g.LogDebug("ws read err:", err.Error())
+			return err
 		}
-		if a.Data == "close" {
-			clientList[&conn] = false
-		}
-	}()
-	select {
-	case <-conn.stop:
-		lock.Lock()
-		delete(clientList, &conn)
-		exit = true
-		g.LogInfo(conn.client.RemoteAddr(), " get stop signal")
-		lock.Unlock()
-		g.LogDebug(conn.client.RemoteAddr(), "已终止")
-		return
+		ctl.MinLevel = &level
+	}
+	if !sub.subscribe(ctl) {
+		return errors.New("invalid node id")
 	}
+	return nil
 }
 
-//webSocketHeartServ webSocket客户端心跳检测
-//根据配置定期发送0，保证长连接有效
-//遍历webSocket客户端列表，状态为true则发送心跳检测 - 成功：继续轮询 ; 失败：置状态为false并关闭该goroutine
-//若状态为false则该webSocket已失效，直接关闭该goroutine
-func webSocketHeartServ() {
-	g.LogInfo("goroutine start - webSocket heart beat")
-	for {
-		for conn, ok := range clientList {
-			if ok {
-				err := conn.write(0)
-				if err != nil {
-					g.LogDebug("heart beat err:", err.Error())
-					conn.client.Close()
-					conn.stop <- true
-					g.LogDebug("webSocket", conn.client.RemoteAddr(), "停止信号已发送")
-				}
-				g.LogDebug("webSocket心跳检测-", conn.client.RemoteAddr(), "成功")
-			} else {
-				conn.stop <- true
-			}
-		}
-		time.Sleep(time.Second * time.Duration(g.Config().WebSocket.Interval))
+//querySeq 获取连接参数seq，未指定时返回nil
+func querySeq(c *gin.Context) (*uint64, error) {
+	s := c.Query("seq")
+	if s == "" {
+		return nil, nil
+	}
+	seq, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return nil, errors.New("invalid seq " + s)
 	}
+	return &seq, nil
 }
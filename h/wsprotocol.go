@@ -0,0 +1,160 @@
+package h
+
+import (
+	"encoding/json"
+	"time"
+	"tollsys/tollmon/datastruct"
+	"tollsys/tollmon/g"
+	"tollsys/tollmon/parameters"
+)
+
+//WebSocket客户端控制协议
+//客户端以CommonMessage格式发送控制消息，data为控制内容：
+//{"data":{"action":"subscribe","stations":[],"plazas":[],"lanes":[],"catalogs":[],"types":[],"minLevel":0}} 添加订阅，minLevel 为报警最低策略等级
+//{"data":{"action":"unsubscribe",...}} 取消订阅，字段同subscribe，指定minLevel时取消等级过滤
+//{"data":{"action":"strategy","profile":""}} 或 {"data":{"action":"strategy","items":[]}} 更新报警策略，均为空时恢复操作员/收费站方案
+//{"data":{"action":"snapshot","seq":0}} 重新获取快照，指定seq时回放其后缓存的数据
+//{"data":{"action":"ping"}} 服务端回复pong
+//{"data":{"action":"close"}} 或 {"data":"close"} 关闭连接
+//服务端以CommonMessage回复，data为wsReply，失败时status为false并在errMsg中说明
+
+const (
+	ActionSubscribe   = "subscribe"
+	ActionUnsubscribe = "unsubscribe"
+	ActionStrategy    = "strategy"
+	ActionPing        = "ping"
+	ActionPong        = "pong"
+	ActionClose       = "close"
+)
+
+//wsControl 客户端控制消息
+type wsControl struct {
+	Action   string                    `json:"action"`
+	Stations []string                  `json:"stations"`
+	Plazas   []string                  `json:"plazas"`
+	Lanes    []string                  `json:"lanes"`
+	Catalogs []int                     `json:"catalogs"`
+	Types    []int                     `json:"types"`
+	MinLevel *int                      `json:"minLevel"`
+	Profile  string                    `json:"profile"`
+	Items    []datastruct.StrategyItem `json:"items"`
+	Seq      *uint64                   `json:"seq"`
+}
+
+//wsReply 控制消息回复
+type wsReply struct {
+	Action       string            `json:"action"`
+	Time         string            `json:"time"`
+	Subscription *subscriptionView `json:"subscription,omitempty"`
+	Profile      string            `json:"profile,omitempty"`
+}
+
+//parseControl 解析客户端消息，兼容data为"close"的关闭消息
+func parseControl(b []byte) (*wsControl, error) {
+	rcvd := struct {
+		Data json.RawMessage `json:"data"`
+	}{}
+	if err := g.Json.Unmarshal(b, &rcvd); err != nil {
+		return nil, err
+	}
+	var s string
+	if err := g.Json.Unmarshal(rcvd.Data, &s); err == nil {
+		return &wsControl{Action: s}, nil
+	}
+	c := &wsControl{}
+	if err := g.Json.Unmarshal(rcvd.Data, c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+//handleControl 处理客户端控制消息，返回false时关闭连接
+func (w *realtimeClient) handleControl(b []byte) bool {
+	c, err := parseControl(b)
+	if err != nil {
+		w.replyError(datastruct.ERRORMSG_DecoderError)
+		return true
+	}
+	reply := &wsReply{Action: c.Action, Time: time.Now().Format(timeFormat)}
+	switch c.Action {
+	case ActionClose:
+		g.LogInfo(w.addr, " get close signal")
+		return false
+	case ActionPing:
+		reply.Action = ActionPong
+	case ActionSnapshot:
+		w.resume(c.Seq)
+		return true
+	case ActionSubscribe, ActionUnsubscribe:
+		w.lock.Lock()
+		var ok bool
+		if c.Action == ActionSubscribe {
+			ok = w.sub.subscribe(c)
+		} else {
+			ok = w.sub.unsubscribe(c)
+		}
+		v := w.sub.view()
+		w.lock.Unlock()
+		wsHub.reindex(w)
+		reply.Subscription = &v
+		g.LogInfo(w.addr, " ", c.Action, " - ", v)
+		if !ok {
+			sender := datastruct.NewCommonMessage()
+			sender.Status = false
+			sender.Code = 1
+			sender.ErrMsg = "invalid node id"
+			sender.Data = reply
+			w.enqueue(sender)
+			return true
+		}
+	case ActionStrategy:
+		items, name, ok := w.resolveStrategy(c)
+		if !ok {
+			w.replyError("strategy profile " + c.Profile + " is not exists")
+			return true
+		}
+		w.lock.Lock()
+		w.strategyItems = items
+		w.lock.Unlock()
+		reply.Profile = name
+		g.LogInfo(w.addr, " strategy - ", name)
+	default:
+		w.replyError("unknown action " + c.Action)
+		return true
+	}
+	sender := datastruct.NewCommonMessage()
+	sender.Data = reply
+	w.enqueue(sender)
+	return true
+}
+
+//resolveStrategy 按控制消息获取报警策略：profile指定的方案 > items自定义策略 > 操作员/收费站方案
+func (w *realtimeClient) resolveStrategy(c *wsControl) (map[int]datastruct.StrategyItem, string, bool) {
+	if c.Profile != "" {
+		p, ok := parameters.GetStrategyProfile(c.Profile)
+		if !ok {
+			return nil, "", false
+		}
+		return p.TypeToItems(), p.Name, true
+	}
+	if len(c.Items) != 0 {
+		items := make(map[int]datastruct.StrategyItem)
+		for _, item := range c.Items {
+			items[item.Type] = item
+		}
+		return items, "", true
+	}
+	w.lock.RLock()
+	ids := w.sub.stationIds()
+	w.lock.RUnlock()
+	p := parameters.ResolveStrategyProfile(w.operator, ids)
+	return p.TypeToItems(), p.Name, true
+}
+
+func (w *realtimeClient) replyError(errMsg string) {
+	sender := datastruct.NewCommonMessage()
+	sender.Status = false
+	sender.Code = 1
+	sender.ErrMsg = errMsg
+	w.enqueue(sender)
+}
@@ -0,0 +1,334 @@
+package inventory
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"sync"
+	"time"
+	"tollsys/tollmon/datastruct"
+	"tollsys/tollmon/g"
+	"tollsys/tollmon/parameters"
+	"tollsys/tollmon/store"
+	"tollsys/tollmon/traffic"
+)
+
+//车道通行卡存量跟踪及预测
+//入口车道每放行一辆车发出一张卡，出口车道每放行一辆车收回一张卡
+//以最近一次上报(存量报警)或登记(补卡、清卡)的存量为基准，按其后的车道流量推算当前存量，
+//按近期流量速率预测入口车道到达最低存量、耗尽，出口车道到达最高存量的时间
+
+const (
+	KIND = "inventory"
+
+	Entry = "entry"
+	Exit  = "exit"
+
+	SourceAlert  = "alert"
+	SourceManual = "manual"
+
+	timeFormat = "2006-01-02 15:04:05"
+)
+
+//Sample 存量记录，上报或登记时写入本地存储
+type Sample struct {
+	LaneID     string `json:"laneID"`
+	Kind       string `json:"kind"`
+	Threshold  int    `json:"threshold"`
+	Current    int    `json:"current"`
+	Source     string `json:"source"`
+	UpdateTime string `json:"updateTime"`
+}
+
+//Stock 车道通行卡存量
+//Estimated 为推算的当前存量；Rate 为近期每小时发卡(收卡)数
+//Minutes 为预计到达阈值的分钟数，EmptyMinutes 为入口车道预计耗尽的分钟数，无法预测时为-1
+type Stock struct {
+	Sample
+	LaneName     string  `json:"laneName"`
+	Estimated    int     `json:"estimated"`
+	Rate         float64 `json:"rate"`
+	Minutes      int     `json:"minutes"`
+	EmptyMinutes int     `json:"emptyMinutes"`
+	Warned       bool    `json:"warned"`
+}
+
+//StationInventory 收费站通行卡存量汇总
+//AtRisk 为预计在预警时长内到达阈值或已到达阈值的车道数
+type StationInventory struct {
+	StationID   string  `json:"stationID"`
+	StationName string  `json:"stationName"`
+	EntryStock  int     `json:"entryStock"`
+	ExitStock   int     `json:"exitStock"`
+	AtRisk      int     `json:"atRisk"`
+	Lanes       []Stock `json:"lanes"`
+}
+
+var (
+	lock   = &sync.Mutex{}
+	stocks = make(map[string]*Stock)
+)
+
+//InitInventory 以本地存储中最近7日的记录恢复各车道最近一次存量
+func InitInventory() {
+	now := time.Now()
+	lock.Lock()
+	defer lock.Unlock()
+	err := store.Load(KIND, now.AddDate(0, 0, -7), now, func(b []byte) {
+		sample := Sample{}
+		if err := g.Json.Unmarshal(b, &sample); err != nil {
+			g.LogError("parse inventory err:", err.Error())
+			return
+		}
+		setSample(sample)
+	})
+	if err != nil {
+		g.LogError("load inventory err:", err.Error())
+	}
+}
+
+//Alert 记录入口、出口通行卡存量报警上报的存量
+func Alert(msg datastruct.MsgSend) {
+	kind := Entry
+	if msg.MsgType == datastruct.MSGTYPE_ExitCard {
+		kind = Exit
+	}
+	save(Sample{
+		LaneID:     msg.MsgLane,
+		Kind:       kind,
+		Threshold:  msg.GetInt("Threshold"),
+		Current:    msg.GetInt("Current"),
+		Source:     SourceAlert,
+		UpdateTime: msg.MsgTime,
+	})
+}
+
+//Register 登记车道补卡、清卡后的存量，阈值为0时沿用最近一次上报的阈值
+func Register(laneID string, kind string, current int, threshold int) (Stock, error) {
+	if _, ok := parameters.GetNodeByID(laneID); !ok || len(laneID) < 26 {
+		return Stock{}, errors.New("invalid laneId " + laneID)
+	}
+	if kind != Entry && kind != Exit {
+		return Stock{}, errors.New("invalid kind " + kind)
+	}
+	if current < 0 || threshold < 0 {
+		return Stock{}, errors.New("current and threshold must not be negative")
+	}
+	if threshold == 0 {
+		lock.Lock()
+		if s, ok := stocks[laneID]; ok && s.Kind == kind {
+			threshold = s.Threshold
+		}
+		lock.Unlock()
+	}
+	save(Sample{
+		LaneID:     laneID,
+		Kind:       kind,
+		Threshold:  threshold,
+		Current:    current,
+		Source:     SourceManual,
+		UpdateTime: time.Now().Format(timeFormat),
+	})
+	return GetStock(laneID, time.Now()), nil
+}
+
+func save(sample Sample) {
+	if err := store.Append(KIND, parseTime(sample.UpdateTime), sample); err != nil {
+		g.LogError("save inventory err:", sample.LaneID, err.Error())
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	setSample(sample)
+}
+
+//setSample 更新车道最近一次存量，上报存量已到达阈值，不再预警
+//调用方需持有lock
+func setSample(sample Sample) {
+	s := &Stock{Sample: sample, Warned: sample.Source == SourceAlert}
+	if node, ok := parameters.GetNodeByID(sample.LaneID); ok {
+		s.LaneName = node.NodeName
+	}
+	stocks[sample.LaneID] = s
+}
+
+//estimate 按最近一次存量后的车道流量推算当前存量并预测到达阈值时间
+//s为存量副本，调用时不持有lock，避免在车道消息处理中嵌套流量统计锁
+func estimate(s *Stock, now time.Time) {
+	rateMinutes, _ := settings()
+	base := parseTime(s.UpdateTime)
+	since := traffic.Sum(s.LaneID, base, now)
+	recent := traffic.Sum(s.LaneID, now.Add(-time.Duration(rateMinutes)*time.Minute), now)
+	count, recentCount := since.Entry, recent.Entry
+	if s.Kind == Exit {
+		count, recentCount = since.Exit, recent.Exit
+	}
+	s.Rate = float64(recentCount) * 60 / float64(rateMinutes)
+	s.Minutes, s.EmptyMinutes = -1, -1
+	if s.Kind == Entry {
+		s.Estimated = s.Current - count
+		if s.Estimated < 0 {
+			s.Estimated = 0
+		}
+		s.Minutes = forecast(s.Estimated-s.Threshold, s.Rate)
+		s.EmptyMinutes = forecast(s.Estimated, s.Rate)
+	} else {
+		s.Estimated = s.Current + count
+		if s.Threshold > 0 {
+			s.Minutes = forecast(s.Threshold-s.Estimated, s.Rate)
+		}
+	}
+}
+
+//forecast 按每小时速率预测剩余数量用尽的分钟数，速率为0时无法预测
+func forecast(remain int, rate float64) int {
+	if remain <= 0 {
+		return 0
+	}
+	if rate <= 0 {
+		return -1
+	}
+	return int(math.Floor(float64(remain) / rate * 60))
+}
+
+func settings() (int, int) {
+	rateMinutes, warnMinutes := 60, 60
+	if c := g.Config().Inventory; c != nil {
+		if c.RateMinutes > 0 {
+			rateMinutes = c.RateMinutes
+		}
+		if c.WarnMinutes > 0 {
+			warnMinutes = c.WarnMinutes
+		}
+	}
+	return rateMinutes, warnMinutes
+}
+
+//Check 推算各车道存量，对预计在预警时长内到达阈值的车道生成通行卡存量预警
+//每条存量记录只预警一次
+func Check(now time.Time) []datastruct.MsgSend {
+	_, warnMinutes := settings()
+	list := make([]datastruct.MsgSend, 0)
+	lock.Lock()
+	pending := make(map[*Stock]Stock)
+	for _, s := range stocks {
+		if !s.Warned {
+			pending[s] = *s
+		}
+	}
+	lock.Unlock()
+	for p, s := range pending {
+		estimate(&s, now)
+		if s.Minutes < 0 || s.Minutes > warnMinutes {
+			continue
+		}
+		//推算期间存量记录已更新或已预警时不再预警
+		lock.Lock()
+		current := stocks[s.LaneID] == p && !p.Warned
+		if current {
+			p.Warned = true
+		}
+		lock.Unlock()
+		if !current {
+			continue
+		}
+		msg := datastruct.NewMsgSend()
+		msg.MsgCatalog = datastruct.MSGCATALOG_Alert
+		msg.MsgType = datastruct.MSGTYPE_CardForecast
+		msg.MsgTime = now.Format(timeFormat)
+		msg.MsgLane = s.LaneID
+		msg.MsgContent["Kind"] = s.Kind
+		msg.MsgContent["Threshold"] = s.Threshold
+		msg.MsgContent["Current"] = s.Estimated
+		msg.MsgContent["Minutes"] = s.Minutes
+		list = append(list, msg)
+		g.LogDebug("通行卡存量预警-[LaneID:", s.LaneID, " kind:", s.Kind, " estimated:", s.Estimated, " minutes:", s.Minutes, "]")
+	}
+	return list
+}
+
+//GetStock 获取车道通行卡存量
+func GetStock(laneID string, now time.Time) Stock {
+	lock.Lock()
+	p, ok := stocks[laneID]
+	if !ok {
+		lock.Unlock()
+		return Stock{Sample: Sample{LaneID: laneID}, Minutes: -1, EmptyMinutes: -1}
+	}
+	s := *p
+	lock.Unlock()
+	estimate(&s, now)
+	return s
+}
+
+//GetStations 按收费站汇总所选车道的通行卡存量，仅包含有存量记录的车道
+func GetStations(laneList []datastruct.Node, now time.Time) []StationInventory {
+	_, warnMinutes := settings()
+	result := make([]StationInventory, 0)
+	index := make(map[string]int)
+	for _, lane := range laneList {
+		s := GetStock(lane.NodeID, now)
+		if s.Kind == "" {
+			continue
+		}
+		s.LaneName = lane.NodeName
+		stationID := lane.NodeID[0:16]
+		i, ok := index[stationID]
+		if !ok {
+			st := StationInventory{StationID: stationID, Lanes: make([]Stock, 0)}
+			if node, ok := parameters.GetNodeByID(stationID); ok {
+				st.StationName = node.NodeName
+			}
+			result = append(result, st)
+			i = len(result) - 1
+			index[stationID] = i
+		}
+		st := &result[i]
+		if s.Kind == Entry {
+			st.EntryStock += s.Estimated
+		} else {
+			st.ExitStock += s.Estimated
+		}
+		if s.Minutes >= 0 && s.Minutes <= warnMinutes {
+			st.AtRisk++
+		}
+		st.Lanes = append(st.Lanes, s)
+	}
+	for i := range result {
+		lanes := result[i].Lanes
+		sort.Slice(lanes, func(a, b int) bool {
+			return lanes[a].LaneID < lanes[b].LaneID
+		})
+	}
+	return result
+}
+
+//GetHistory 查询车道[from,to]日期范围内的存量记录
+func GetHistory(laneList []datastruct.Node, from time.Time, to time.Time) []Sample {
+	selected := make(map[string]bool)
+	for _, lane := range laneList {
+		selected[lane.NodeID] = true
+	}
+	list := make([]Sample, 0)
+	err := store.Load(KIND, from, to, func(b []byte) {
+		sample := Sample{}
+		if err := g.Json.Unmarshal(b, &sample); err != nil {
+			g.LogError("parse inventory err:", err.Error())
+			return
+		}
+		if selected[sample.LaneID] {
+			list = append(list, sample)
+		}
+	})
+	if err != nil {
+		g.LogError("load inventory err:", err.Error())
+	}
+	return list
+}
+
+func parseTime(s string) time.Time {
+	t, err := time.ParseInLocation(timeFormat, s, time.Local)
+	if err != nil {
+		return time.Now()
+	}
+	return t
+}
@@ -13,8 +13,15 @@ import (
 	_ "net/http/pprof"
 	"tollsys/tollmon/db"
 	"tollsys/tollmon/h"
+	"tollsys/tollmon/inventory"
 	"tollsys/tollmon/monitor"
+	"tollsys/tollmon/notify"
 	"tollsys/tollmon/parameters"
+	"tollsys/tollmon/printer"
+	"tollsys/tollmon/revenue"
+	"tollsys/tollmon/summary"
+	"tollsys/tollmon/timeline"
+	"tollsys/tollmon/traffic"
 	"net/http"
 )
 
@@ -32,6 +39,13 @@ func InitSys() {
 	h.InitServer()
 	monitor.InitMonitor()
 	parameters.InitParameters()
+	notify.InitNotify()
+	traffic.InitTraffic()
+	revenue.InitRevenue()
+	inventory.InitInventory()
+	printer.InitPrinter()
+	timeline.InitTimeline()
+	summary.InitSummary()
 }
 func main() {
 	flag.BoolVar(&showVer, "v", false, "")
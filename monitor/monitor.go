@@ -7,7 +7,6 @@ import (
 	"sync"
 	"time"
 	"tollsys/tollmon/g"
-	"tollsys/tollmon/h"
 	"tollsys/tollmon/parameters"
 )
 
@@ -42,6 +41,8 @@ func Start() {
 			go handleConnection(conn)
 		}
 	}()
+	//goroutine 统计模块定时检查，此goroutine常驻
+	go watch()
 	//goroutine 车道队列连接状态轮询
 	//遍历map获取车道id与最后一次通讯时间
 	//车道状态变更时才更新渲染数据并添加实时变更数据至实时发送队列，此goroutine常驻
@@ -57,7 +58,7 @@ func Start() {
 						a := make(map[string]interface{})
 						a["ConnectStatus"] = false
 						msg := setMsgSend(McTest, MtHeart, lastCommTime.Format("2006-01-02 15:04:05"), nodeId, a)
-						h.PushRealData(nodeId[:16], msg)
+						publish(msg)
 						g.LogInfo("车道连接状态变更:", parameters.GetLaneInfoByID(nodeId).Node.NodeName, " - 已中断连接")
 					}
 				} else {
@@ -66,7 +67,7 @@ func Start() {
 						a := make(map[string]interface{})
 						a["ConnectStatus"] = true
 						msg := setMsgSend(McTest, MtHeart, lastCommTime.Format("2006-01-02 15:04:05"), nodeId, a)
-						h.PushRealData(nodeId[:16], msg)
+						publish(msg)
 						g.LogInfo("车道连接状态变更:", parameters.GetLaneInfoByID(nodeId).Node.NodeName, " - 通讯连接已建立")
 					}
 				}
@@ -9,7 +9,6 @@ import (
 	"strings"
 	"tollsys/tollmon/datastruct"
 	"tollsys/tollmon/g"
-	"tollsys/tollmon/h"
 	"tollsys/tollmon/parameters"
 
 	"time"
@@ -272,7 +271,7 @@ func handleMtEntryLane(s []byte) {
 	index += LenType
 	a["ETCCar"] = bytesToInt(subBytes(s, index, LenETCCar))
 	msg := setMsgSend(McData, MtEntryLane, sTime, sLaneID, a)
-	h.PushRealData(sLaneID[0:16], msg)
+	publish(msg)
 	g.LogDebug("入口车道记录信息-[Time:", sTime, " LaneID:", sLaneID, a, "]")
 }
 func handleMtExitLane(s []byte) {
@@ -302,7 +301,7 @@ func handleMtExitLane(s []byte) {
 	index += LenForfeit
 	a["ETCCar"] = bytesToInt(subBytes(s, index, LenETCCar))
 	msg := setMsgSend(McData, MtExitLane, sTime, sLaneID, a)
-	h.PushRealData(sLaneID[0:16], msg)
+	publish(msg)
 	g.LogDebug("出口车道记录信息-[Time:", sTime, " LaneID:", sLaneID, a, "]")
 }
 func handleMtOnduty(s []byte) {
@@ -328,7 +327,7 @@ func handleMtOnduty(s []byte) {
 	parameters.UpdateLaneInfo(sLaneID, "shiftNo", a["Shift"])
 	parameters.UpdateLaneInfo(sLaneID, "empName", a["EmpName"])
 	parameters.UpdateLaneInfo(sLaneID, "empID", a["EmpID"])
-	h.PushRealData(sLaneID[0:16], msg)
+	publish(msg)
 	g.LogDebug("上班记录信息-[Time:", sTime, " LaneID:", sLaneID, a, "]")
 }
 func handleMtEnOffduty(s []byte) {
@@ -359,7 +358,7 @@ func handleMtEnOffduty(s []byte) {
 	parameters.UpdateLaneInfo(sLaneID, "shiftNo", a["Shift"])
 	parameters.UpdateLaneInfo(sLaneID, "empName", a["EmpName"])
 	parameters.UpdateLaneInfo(sLaneID, "empID", a["EmpID"])
-	h.PushRealData(sLaneID[0:16], msg)
+	publish(msg)
 	g.LogDebug("入口下班记录-[Time:", sTime, " LaneID:", sLaneID, a, "]")
 }
 func handleMtExOffduty(s []byte) {
@@ -390,7 +389,7 @@ func handleMtExOffduty(s []byte) {
 	parameters.UpdateLaneInfo(sLaneID, "shiftNo", a["Shift"])
 	parameters.UpdateLaneInfo(sLaneID, "empName", a["EmpName"])
 	parameters.UpdateLaneInfo(sLaneID, "empID", a["EmpID"])
-	h.PushRealData(sLaneID[0:16], msg)
+	publish(msg)
 	g.LogDebug("出口下班记录-[Time:", sTime, " LaneID:", sLaneID, a, "]")
 }
 func handleMtImage(s []byte) {
@@ -404,7 +403,7 @@ func handleMtImage(s []byte) {
 	}
 	sLaneID := string(subBytes(s, index, LenLaneID))
 	msg := setMsgSend(McData, MtImage, sTime, sLaneID, a)
-	h.PushRealData(sLaneID[0:16], msg)
+	publish(msg)
 	g.LogDebug("车道图像-[Time:", sTime, " LaneID:", sLaneID, "]")
 }
 func handleMtVoice(s []byte) {
@@ -418,7 +417,7 @@ func handleMtVoice(s []byte) {
 	}
 	sLaneID := string(subBytes(s, index, LenLaneID))
 	msg := setMsgSend(McData, MtVoice, sTime, sLaneID, a)
-	h.PushRealData(sLaneID[0:16], msg)
+	publish(msg)
 	g.LogDebug("语音信息-[Time:", sTime, " LaneID:", sLaneID, "]")
 }
 func handleMtLaneStatus(s []byte) {
@@ -446,7 +445,7 @@ func handleMtLaneStatus(s []byte) {
 		parameters.UpdateLaneInfo(sLaneID, "laneStatus", 1)
 		a["Status"] = 1
 	}
-	h.PushRealData(sLaneID[0:16], msg)
+	publish(msg)
 	g.LogDebug("车道状态-[Time:", sTime, " LaneID:", sLaneID, a, "]")
 }
 func handleMtGJC(s []byte) {
@@ -460,7 +459,7 @@ func handleMtGJC(s []byte) {
 	}
 	sLaneID := string(subBytes(s, index, LenLaneID))
 	msg := setMsgSend(McData, MtGJC, sTime, sLaneID, a)
-	h.PushRealData(sLaneID[0:16], msg)
+	publish(msg)
 	g.LogDebug("代金卡-[Time:", sTime, " LaneID:", sLaneID, "]")
 }
 func handleMtReq(s []byte) {
@@ -474,7 +473,7 @@ func handleMtReq(s []byte) {
 	}
 	sLaneID := string(subBytes(s, index, LenLaneID))
 	msg := setMsgSend(McData, MtReq, sTime, sLaneID, a)
-	h.PushRealData(sLaneID[0:16], msg)
+	publish(msg)
 	g.LogDebug("入口查询请求-[Time:", sTime, " LaneID:", sLaneID, "]")
 }
 func subStr(src string, index int, length int) string {
@@ -528,7 +527,7 @@ func handleMtClassChange(mb []byte) {
 	index += LenClass
 	a["ExClass"] = bytesToInt(subBytes(mb, index, LenClass))
 	msg := setMsgSend(McAlert, MtClassChange, sTime, sLaneID, a)
-	h.PushRealData(sLaneID[0:16], msg)
+	publish(msg)
 	g.LogDebug("出入口车型不一致-[Time:", sTime, " LaneID:", sLaneID, a, "]")
 }
 func handleMtVio(mb []byte) {
@@ -546,7 +545,7 @@ func handleMtVio(mb []byte) {
 	index += LenShift
 	a["EmpID"] = bytesToInt(subBytes(mb, index, LenEmpID))
 	msg := setMsgSend(McAlert, MtVio, sTime, sLaneID, a)
-	h.PushRealData(sLaneID[0:16], msg)
+	publish(msg)
 	g.LogDebug("闯关-[Time:", sTime, " LaneID:", sLaneID, " EmpID:", a, "]")
 }
 func handleMtDutyEnd(mb []byte) {
@@ -566,7 +565,7 @@ func handleMtDutyEnd(mb []byte) {
 	index += LenEmpID
 	a["Offset"] = bytesToInt(subBytes(mb, index, LenOffSet))
 	msg := setMsgSend(McAlert, MtDutyEnd, sTime, sLaneID, a)
-	h.PushRealData(sLaneID[0:16], msg)
+	publish(msg)
 	g.LogDebug("下班通知-[Time:", sTime, " LaneID:", sLaneID, a, "]")
 }
 func handleMtTypeChange(mb []byte) {
@@ -588,7 +587,7 @@ func handleMtTypeChange(mb []byte) {
 	index += LenType
 	a["ExType"] = bytesToInt(subBytes(mb, index, LenType))
 	msg := setMsgSend(McAlert, MtTypeChange, sTime, sLaneID, a)
-	h.PushRealData(sLaneID[0:16], msg)
+	publish(msg)
 	g.LogDebug("车种不一致-[Time:", sTime, " LaneID:", sLaneID, a, "]")
 }
 func handleMtEntryCard(mb []byte) {
@@ -610,7 +609,7 @@ func handleMtEntryCard(mb []byte) {
 	index += LenThreshold
 	a["Current"] = bytesToInt(subBytes(mb, index, LenCurrent))
 	msg := setMsgSend(McAlert, MtEntryCard, sTime, sLaneID, a)
-	h.PushRealData(sLaneID[0:16], msg)
+	publish(msg)
 	g.LogDebug("入口通行卡存量报警-[Time:", sTime, " LaneID:", sLaneID, a, "]")
 }
 func handleMtExitCard(mb []byte) {
@@ -632,7 +631,7 @@ func handleMtExitCard(mb []byte) {
 	index += LenThreshold
 	a["Current"] = bytesToInt(subBytes(mb, index, LenCurrent))
 	msg := setMsgSend(McAlert, MtExitCard, sTime, sLaneID, a)
-	h.PushRealData(sLaneID[0:16], msg)
+	publish(msg)
 	g.LogDebug("出口通行卡存量报警-[Time:", sTime, " LaneID:", sLaneID, a, "]")
 }
 func handleMtNotePrint(mb []byte) {
@@ -654,7 +653,7 @@ func handleMtNotePrint(mb []byte) {
 	index += LenThreshold
 	a["Current"] = bytesToInt(subBytes(mb, index, LenCurrent))
 	msg := setMsgSend(McAlert, MtNotePrint, sTime, sLaneID, a)
-	h.PushRealData(sLaneID[0:16], msg)
+	publish(msg)
 	g.LogDebug("出口打印票存量报警-[Time:", sTime, " LaneID:", sLaneID, a, "]")
 }
 func handleMtNoteHand(mb []byte) {
@@ -676,7 +675,7 @@ func handleMtNoteHand(mb []byte) {
 	index += LenThreshold
 	a["Current"] = bytesToInt(subBytes(mb, index, LenCurrent))
 	msg := setMsgSend(McAlert, MtNoteHand, sTime, sLaneID, a)
-	h.PushRealData(sLaneID[0:16], msg)
+	publish(msg)
 	g.LogDebug("出口定额票余额报警-[Time:", sTime, " LaneID:", sLaneID, a, "]")
 }
 func handleMtVehicleCount(mb []byte) {
@@ -694,7 +693,7 @@ func handleMtVehicleCount(mb []byte) {
 	index += LenShift
 	a["EmpID"] = bytesToInt(subBytes(mb, index, LenEmpID))
 	msg := setMsgSend(McAlert, MtVehicleCount, sTime, sLaneID, a)
-	h.PushRealData(sLaneID[0:16], msg)
+	publish(msg)
 	g.LogDebug("车道流量计数-[Time:", sTime, " LaneID:", sLaneID, a, "]")
 }
 func handleMtOpeCardFail(mb []byte) {
@@ -714,7 +713,7 @@ func handleMtOpeCardFail(mb []byte) {
 	index += LenEmpID
 	a["CardType"] = bytesToInt(subBytes(mb, index, LenCardType))
 	msg := setMsgSend(McAlert, MtOpeCardFail, sTime, sLaneID, a)
-	h.PushRealData(sLaneID[0:16], msg)
+	publish(msg)
 	g.LogDebug("卡操作失败-[Time:", sTime, " LaneID:", sLaneID, a, "]")
 }
 func handleMtReaderInitFail(mb []byte) {
@@ -732,7 +731,7 @@ func handleMtReaderInitFail(mb []byte) {
 	index += LenShift
 	a["EmpID"] = bytesToInt(subBytes(mb, index, LenEmpID))
 	msg := setMsgSend(McAlert, MtReaderInitFail, sTime, sLaneID, a)
-	h.PushRealData(sLaneID[0:16], msg)
+	publish(msg)
 	g.LogDebug("卡机初始化失败-[Time:", sTime, " LaneID:", sLaneID, a, "]")
 }
 func handleMtCardModeChange(mb []byte) {
@@ -754,7 +753,7 @@ func handleMtCardModeChange(mb []byte) {
 	index += LenMode
 	a["CurrMode"] = bytesToInt(subBytes(mb, index, LenMode))
 	msg := setMsgSend(McAlert, MtCardModeChange, sTime, sLaneID, a)
-	h.PushRealData(sLaneID[0:16], msg)
+	publish(msg)
 	g.LogDebug("入口发卡模式改变-[Time:", sTime, " LaneID:", sLaneID, a, "]")
 }
 func handleMtNoteModeChange(mb []byte) {
@@ -776,7 +775,7 @@ func handleMtNoteModeChange(mb []byte) {
 	index += LenMode
 	a["CurrMode"] = bytesToInt(subBytes(mb, index, LenMode))
 	msg := setMsgSend(McAlert, MtNoteModeChange, sTime, sLaneID, a)
-	h.PushRealData(sLaneID[0:16], msg)
+	publish(msg)
 	g.LogDebug("票据模式改变-[Time:", sTime, " LaneID:", sLaneID, a, "]")
 }
 func handleMtNoteAgain(mb []byte) {
@@ -798,7 +797,7 @@ func handleMtNoteAgain(mb []byte) {
 	index += LenPrintTimes
 	a["PrintNoteNo"] = string(subBytes(mb, index, LenPrintNoteNo))
 	msg := setMsgSend(McAlert, MtNoteAgain, sTime, sLaneID, a)
-	h.PushRealData(sLaneID[0:16], msg)
+	publish(msg)
 	g.LogDebug("发票重打-[Time:", sTime, " LaneID:", sLaneID, a, "]")
 }
 func handleMtExBadCard(mb []byte) {
@@ -820,7 +819,7 @@ func handleMtExBadCard(mb []byte) {
 	index += LenClass
 	a["Type"] = bytesToInt(subBytes(mb, index, LenType))
 	msg := setMsgSend(McAlert, MtExBadCard, sTime, sLaneID, a)
-	h.PushRealData(sLaneID[0:16], msg)
+	publish(msg)
 	g.LogDebug("出口坏卡-[Time:", sTime, " LaneID:", sLaneID, a, "]")
 }
 func handleMtExNoCard(mb []byte) {
@@ -842,7 +841,7 @@ func handleMtExNoCard(mb []byte) {
 	index += LenClass
 	a["Type"] = bytesToInt(subBytes(mb, index, LenType))
 	msg := setMsgSend(McAlert, MtExNoCard, sTime, sLaneID, a)
-	h.PushRealData(sLaneID[0:16], msg)
+	publish(msg)
 	g.LogDebug("出口无卡-[Time:", sTime, " LaneID:", sLaneID, a, "]")
 }
 func handleMtSimulate(mb []byte) {
@@ -860,7 +859,7 @@ func handleMtSimulate(mb []byte) {
 	index += LenShift
 	a["EmpID"] = bytesToInt(subBytes(mb, index, LenEmpID))
 	msg := setMsgSend(McAlert, MtSimulate, sTime, sLaneID, a)
-	h.PushRealData(sLaneID[0:16], msg)
+	publish(msg)
 	g.LogDebug("模拟放车-[Time:", sTime, " LaneID:", sLaneID, a, "]")
 }
 func handleMtDebt(mb []byte) {
@@ -882,7 +881,7 @@ func handleMtDebt(mb []byte) {
 	index += LenClass
 	a["Type"] = bytesToInt(subBytes(mb, index, LenType))
 	msg := setMsgSend(McAlert, MtDebt, sTime, sLaneID, a)
-	h.PushRealData(sLaneID[0:16], msg)
+	publish(msg)
 	g.LogDebug("欠款未付车辆-[Time:", sTime, " LaneID:", sLaneID, a, "]")
 }
 func handleMtFree(mb []byte) {
@@ -903,7 +902,7 @@ func handleMtFree(mb []byte) {
 	a["Type"] = bytesToInt(subBytes(mb, index, LenType))
 	index += LenType
 	msg := setMsgSend(McAlert, MtFree, sTime, sLaneID, a)
-	h.PushRealData(sLaneID[0:16], msg)
+	publish(msg)
 	g.LogDebug("免费车辆-[Time:", sTime, " LaneID:", sLaneID, a, "]")
 }
 func handleMtFlowChange(mb []byte) {
@@ -922,7 +921,7 @@ func handleMtFlowChange(mb []byte) {
 	a["EmpID"] = bytesToInt(subBytes(mb, index, LenEmpID))
 	index += LenEmpID
 	msg := setMsgSend(McAlert, MtFlowChange, sTime, sLaneID, a)
-	h.PushRealData(sLaneID[0:16], msg)
+	publish(msg)
 	g.LogDebug("流水修改-[Time:", sTime, " LaneID:", sLaneID, a, "]")
 }
 func handleMtMotoStart(mb []byte) {
@@ -940,7 +939,7 @@ func handleMtMotoStart(mb []byte) {
 	index += LenShift
 	a["EmpID"] = bytesToInt(subBytes(mb, index, LenEmpID))
 	msg := setMsgSend(McAlert, MtMotoStart, sTime, sLaneID, a)
-	h.PushRealData(sLaneID[0:16], msg)
+	publish(msg)
 	g.LogDebug("车队开始-[Time:", sTime, " LaneID:", sLaneID, a, "]")
 }
 
@@ -961,7 +960,7 @@ func handleMtMotoEnd(mb []byte) {
 	index += LenEmpID
 	a["Flow"] = bytesToInt(subBytes(mb, index, LenFlow))
 	msg := setMsgSend(McAlert, MtMotoEnd, sTime, sLaneID, a)
-	h.PushRealData(sLaneID[0:16], msg)
+	publish(msg)
 	g.LogDebug("车队结束-[Time:", sTime, " LaneID:", sLaneID, a, "]")
 }
 func handleMtExitChangeClass(mb []byte) {
@@ -983,7 +982,7 @@ func handleMtExitChangeClass(mb []byte) {
 	index += LenClass
 	a["ExClass"] = bytesToInt(subBytes(mb, index, LenClass))
 	msg := setMsgSend(McAlert, MtExitChangeClass, sTime, sLaneID, a)
-	h.PushRealData(sLaneID[0:16], msg)
+	publish(msg)
 	g.LogDebug("出口车型修改-[Time:", sTime, " LaneID:", sLaneID, a, "]")
 }
 func handleMtReaderErr(mb []byte) {
@@ -1001,7 +1000,7 @@ func handleMtReaderErr(mb []byte) {
 	index += LenShift
 	a["EmpID"] = bytesToInt(subBytes(mb, index, LenEmpID))
 	msg := setMsgSend(McAlert, MtReaderErr, sTime, sLaneID, a)
-	h.PushRealData(sLaneID[0:16], msg)
+	publish(msg)
 	g.LogDebug("卡机故障-[Time:", sTime, " LaneID:", sLaneID, a, "]")
 }
 func handleMtUType(mb []byte) {
@@ -1021,7 +1020,7 @@ func handleMtUType(mb []byte) {
 	index += LenEmpID
 	a["ExClass"] = bytesToInt(subBytes(mb, index, LenClass))
 	msg := setMsgSend(McAlert, MtUType, sTime, sLaneID, a)
-	h.PushRealData(sLaneID[0:16], msg)
+	publish(msg)
 	g.LogDebug("U行车-[Time:", sTime, " LaneID:", sLaneID, a, "]")
 }
 func handleMtOverTime(mb []byte) {
@@ -1041,7 +1040,7 @@ func handleMtOverTime(mb []byte) {
 	index += LenEmpID
 	a["ExClass"] = bytesToInt(subBytes(mb, index, LenClass))
 	msg := setMsgSend(McAlert, MtOverTime, sTime, sLaneID, a)
-	h.PushRealData(sLaneID[0:16], msg)
+	publish(msg)
 	g.LogDebug("超时车辆-[Time:", sTime, " LaneID:", sLaneID, a, "]")
 }
 func handleMtManualAlert(mb []byte) {
@@ -1059,7 +1058,7 @@ func handleMtManualAlert(mb []byte) {
 	index += LenShift
 	a["EmpID"] = bytesToInt(subBytes(mb, index, LenEmpID))
 	msg := setMsgSend(McAlert, MtManualAlert, sTime, sLaneID, a)
-	h.PushRealData(sLaneID[0:16], msg)
+	publish(msg)
 	g.LogDebug("人工报警-[Time:", sTime, " LaneID:", sLaneID, a, "]")
 }
 func handleMtETCInfo(mb []byte) {
@@ -1075,7 +1074,7 @@ func handleMtETCInfo(mb []byte) {
 	index += LenLaneID
 	a["ETCErrorNote"] = string(subBytes(mb, index, LenETCErrorNote))
 	msg := setMsgSend(McAlert, MtETCInfo, sTime, sLaneID, a)
-	h.PushRealData(sLaneID[0:16], msg)
+	publish(msg)
 	g.LogDebug("ETC信息-[Time:", sTime, " LaneID:", sLaneID, a, "]")
 }
 func parseTimeFormat(time string) (string, error) {
@@ -0,0 +1,103 @@
+package monitor
+
+import (
+	"time"
+	"tollsys/tollmon/datastruct"
+	"tollsys/tollmon/events"
+	"tollsys/tollmon/g"
+	"tollsys/tollmon/h"
+	"tollsys/tollmon/inventory"
+	"tollsys/tollmon/motorcade"
+	"tollsys/tollmon/parameters"
+	"tollsys/tollmon/printer"
+	"tollsys/tollmon/reader"
+	"tollsys/tollmon/revenue"
+	"tollsys/tollmon/shift"
+	"tollsys/tollmon/timeline"
+	"tollsys/tollmon/traffic"
+)
+
+//publish 分发已解码的车道消息
+//按消息种类、类别更新各统计模块，推送该消息至实时发送队列，并发布统计模块产生的消息
+//统计模块产生的核心数据消息不直接推送，按车道合并后周期推送
+func publish(msg datastruct.MsgSend) {
+	if msg.MsgCatalog == datastruct.MSGCATALOG_CoreData {
+		for key, val := range msg.MsgContent {
+			h.PushCoreData(msg.MsgLane, key, msg.MsgType, val, msg.MsgTime)
+		}
+		return
+	}
+	extra := make([]datastruct.MsgSend, 0)
+	switch msg.MsgCatalog {
+	case McData:
+		switch msg.MsgType {
+		case MtOnduty:
+			parameters.SetEmployeeName(msg.GetInt("EmpID"), msg.GetString("EmpName"))
+			shift.OnDuty(msg)
+			timeline.OnDuty(msg)
+			extra = append(extra, revenue.OnDuty(msg)...)
+		case MtEnOffduty, MtExOffduty:
+			parameters.SetEmployeeName(msg.GetInt("EmpID"), msg.GetString("EmpName"))
+			shift.OffDuty(msg)
+			timeline.OffDuty(msg)
+			extra = append(extra, motorcade.OffDuty(msg)...)
+		case MtLaneStatus:
+			timeline.LaneStatus(msg)
+		case MtEntryLane:
+			shift.Entry(msg)
+			traffic.Entry(msg)
+		case MtExitLane:
+			shift.Exit(msg)
+			traffic.Exit(msg)
+			extra = append(extra, revenue.Exit(msg)...)
+		}
+	case McAlert:
+		//报警仅包含工号时补充员工姓名
+		//无工号的报警(如派生的通行卡预警)不计入班次，避免关闭或补建班次
+		if empID := msg.GetInt("EmpID"); empID != 0 {
+			if msg.GetString("EmpName") == "" {
+				if name := parameters.GetEmployeeName(empID); name != "" {
+					msg.MsgContent["EmpName"] = name
+				}
+			}
+			shift.Alert(msg)
+		}
+		events.Record(msg)
+		switch msg.MsgType {
+		case MtEntryCard, MtExitCard:
+			inventory.Alert(msg)
+		case MtNotePrint, MtNoteHand, MtNoteModeChange, MtNoteAgain:
+			extra = append(extra, printer.Handle(msg)...)
+		case MtOpeCardFail, MtReaderInitFail, MtReaderErr:
+			extra = append(extra, reader.Handle(msg)...)
+		case MtMotoStart:
+			extra = append(extra, motorcade.Start(msg)...)
+		case MtMotoEnd:
+			extra = append(extra, motorcade.End(msg)...)
+		}
+	case McTest:
+		if msg.MsgType == MtHeart {
+			timeline.Heartbeat(msg)
+		}
+	}
+	h.PushRealData(msg.MsgLane[0:16], msg)
+	for _, m := range extra {
+		publish(m)
+	}
+}
+
+//watch 每分钟执行各统计模块的定时检查，发布检查产生的预警消息
+func watch() {
+	g.LogInfo("goroutine start - [statistics check]")
+	for {
+		time.Sleep(time.Minute)
+		now := time.Now()
+		list := reader.Refresh(now)
+		list = append(list, inventory.Check(now)...)
+		list = append(list, revenue.Check(now)...)
+		list = append(list, motorcade.Check(now)...)
+		for _, msg := range list {
+			publish(msg)
+		}
+	}
+}
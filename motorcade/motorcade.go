@@ -0,0 +1,274 @@
+package motorcade
+
+import (
+	"sort"
+	"sync"
+	"time"
+	"tollsys/tollmon/datastruct"
+	"tollsys/tollmon/g"
+	"tollsys/tollmon/parameters"
+	"tollsys/tollmon/store"
+)
+
+//车队放行会话
+//按车道配对车队开始、车队结束报警为一次车队放行，记录持续时间、车辆数及操作员，
+//结束后的车队写入本地存储；超过最长持续时间未结束或车辆数超限时报警
+
+const (
+	KIND = "motorcade"
+
+	EventStart   = "start"
+	EventEnd     = "end"
+	EventOverdue = "overdue"
+
+	ReasonDuration = "duration" //超过最长持续时间
+	ReasonFlow     = "flow"     //车辆数超限
+
+	timeFormat = "2006-01-02 15:04:05"
+)
+
+//Session 车队放行记录
+//NoEnd 为未收到车队结束即开始新车队或下班；NoStart 为未收到车队开始的车队结束
+type Session struct {
+	ID         string `json:"id"`
+	LaneID     string `json:"laneID"`
+	LaneName   string `json:"laneName"`
+	Shift      int    `json:"shift"`
+	EmpID      int    `json:"empID"`
+	EmpName    string `json:"empName"`
+	StartTime  string `json:"startTime"`
+	EndTime    string `json:"endTime"`
+	Duration   int64  `json:"duration"`
+	Flow       int    `json:"flow"`
+	Open       bool   `json:"open"`
+	NoEnd      bool   `json:"noEnd"`
+	NoStart    bool   `json:"noStart"`
+	Overdue    bool   `json:"overdue"`
+	ExceedFlow bool   `json:"exceedFlow"`
+}
+
+//Query 车队查询条件，字段为空值时不过滤
+type Query struct {
+	StationID string
+	LaneID    string
+	From      time.Time
+	To        time.Time
+	OpenOnly  bool
+}
+
+var (
+	lock     = &sync.Mutex{}
+	openList = make(map[string]*Session) //车道 -> 进行中的车队
+)
+
+func settings() (int, int) {
+	maxMinutes, maxFlow := 30, 0
+	if c := g.Config().Motorcade; c != nil {
+		if c.MaxMinutes > 0 {
+			maxMinutes = c.MaxMinutes
+		}
+		maxFlow = c.MaxFlow
+	}
+	return maxMinutes, maxFlow
+}
+
+func newSession(msg datastruct.MsgSend) *Session {
+	s := &Session{
+		ID:     msg.MsgLane + "-" + parseTime(msg.MsgTime).Format("20060102150405"),
+		LaneID: msg.MsgLane,
+		Shift:  msg.GetInt("Shift"),
+		EmpID:  msg.GetInt("EmpID"),
+	}
+	if node, ok := parameters.GetNodeByID(msg.MsgLane); ok {
+		s.LaneName = node.NodeName
+	}
+	s.EmpName, _ = parameters.GetLaneInfoByID(msg.MsgLane).Info["empName"].(string)
+	return s
+}
+
+//Start 车队开始，关闭该车道未结束的车队并开始新车队，返回需发布的车队事件
+func Start(msg datastruct.MsgSend) []datastruct.MsgSend {
+	lock.Lock()
+	defer lock.Unlock()
+	list := make([]datastruct.MsgSend, 0)
+	if s, ok := openList[msg.MsgLane]; ok {
+		s.NoEnd = true
+		closeSession(s, msg.MsgTime)
+		list = append(list, eventMsg(EventEnd, msg.MsgTime, s))
+	}
+	s := newSession(msg)
+	s.StartTime = msg.MsgTime
+	s.Open = true
+	openList[msg.MsgLane] = s
+	return append(list, eventMsg(EventStart, msg.MsgTime, s))
+}
+
+//End 车队结束，返回需发布的车队事件及车辆数超限报警
+func End(msg datastruct.MsgSend) []datastruct.MsgSend {
+	lock.Lock()
+	defer lock.Unlock()
+	s, ok := openList[msg.MsgLane]
+	if !ok {
+		s = newSession(msg)
+		s.NoStart = true
+	}
+	s.Flow = msg.GetInt("Flow")
+	closeSession(s, msg.MsgTime)
+	list := []datastruct.MsgSend{eventMsg(EventEnd, msg.MsgTime, s)}
+	if s.ExceedFlow {
+		list = append(list, exceedMsg(ReasonFlow, msg.MsgTime, s))
+	}
+	return list
+}
+
+//OffDuty 下班时关闭该车道未结束的车队，返回需发布的车队事件
+func OffDuty(msg datastruct.MsgSend) []datastruct.MsgSend {
+	lock.Lock()
+	defer lock.Unlock()
+	s, ok := openList[msg.MsgLane]
+	if !ok {
+		return nil
+	}
+	s.NoEnd = true
+	closeSession(s, msg.MsgTime)
+	return []datastruct.MsgSend{eventMsg(EventEnd, msg.MsgTime, s)}
+}
+
+//closeSession 结束车队并写入本地存储，以开始日期归档
+//调用方需持有lock
+func closeSession(s *Session, endTime string) {
+	_, maxFlow := settings()
+	s.Open = false
+	s.EndTime = endTime
+	if !s.NoStart {
+		s.Duration = duration(s, parseTime(endTime))
+		delete(openList, s.LaneID)
+	}
+	s.ExceedFlow = maxFlow > 0 && s.Flow > maxFlow
+	day := parseTime(s.StartTime)
+	if s.NoStart {
+		day = parseTime(s.EndTime)
+	}
+	if err := store.Append(KIND, day, s); err != nil {
+		g.LogError("save motorcade err:", s.ID, err.Error())
+	}
+	g.LogDebug("车队结束-", s.ID, " flow:", s.Flow, " duration:", s.Duration)
+}
+
+func duration(s *Session, end time.Time) int64 {
+	d := int64(end.Sub(parseTime(s.StartTime)).Seconds())
+	if d < 0 {
+		return 0
+	}
+	return d
+}
+
+//Check 检查进行中的车队，超过最长持续时间时报警，每个车队只报警一次
+func Check(now time.Time) []datastruct.MsgSend {
+	maxMinutes, _ := settings()
+	lock.Lock()
+	defer lock.Unlock()
+	list := make([]datastruct.MsgSend, 0)
+	for _, s := range openList {
+		s.Duration = duration(s, now)
+		if s.Overdue || s.Duration < int64(maxMinutes)*60 {
+			continue
+		}
+		s.Overdue = true
+		mTime := now.Format(timeFormat)
+		list = append(list, eventMsg(EventOverdue, mTime, s), exceedMsg(ReasonDuration, mTime, s))
+	}
+	return list
+}
+
+//eventMsg 生成车队放行事件消息
+func eventMsg(event string, mTime string, s *Session) datastruct.MsgSend {
+	msg := datastruct.NewMsgSend()
+	msg.MsgCatalog = datastruct.MSGCATALOG_Data
+	msg.MsgType = datastruct.MSGTYPE_Motorcade
+	msg.MsgTime = mTime
+	msg.MsgLane = s.LaneID
+	msg.MsgContent["Event"] = event
+	msg.MsgContent["Session"] = *s
+	return msg
+}
+
+//exceedMsg 生成车队放行超限报警
+func exceedMsg(reason string, mTime string, s *Session) datastruct.MsgSend {
+	msg := datastruct.NewMsgSend()
+	msg.MsgCatalog = datastruct.MSGCATALOG_Alert
+	msg.MsgType = datastruct.MSGTYPE_MotorcadeExceed
+	msg.MsgTime = mTime
+	msg.MsgLane = s.LaneID
+	msg.MsgContent["Shift"] = s.Shift
+	msg.MsgContent["EmpID"] = s.EmpID
+	msg.MsgContent["Reason"] = reason
+	msg.MsgContent["StartTime"] = s.StartTime
+	msg.MsgContent["Duration"] = int(s.Duration)
+	msg.MsgContent["Flow"] = s.Flow
+	g.LogDebug("车队放行超限-[LaneID:", s.LaneID, " reason:", reason, " duration:", s.Duration, " flow:", s.Flow, "]")
+	return msg
+}
+
+//GetSessions 查询车队放行记录，包含已结束车队及进行中的车队，按开始时间排序
+func GetSessions(q Query) []Session {
+	list := make([]Session, 0)
+	if !q.OpenOnly {
+		err := store.Load(KIND, q.From, q.To, func(b []byte) {
+			s := Session{}
+			if err := g.Json.Unmarshal(b, &s); err != nil {
+				g.LogError("parse motorcade err:", err.Error())
+				return
+			}
+			if q.match(&s) {
+				list = append(list, s)
+			}
+		})
+		if err != nil {
+			g.LogError("load motorcade err:", err.Error())
+		}
+	}
+	now := time.Now()
+	lock.Lock()
+	for _, s := range openList {
+		s.Duration = duration(s, now)
+		if q.match(s) || (q.OpenOnly && q.matchNode(s)) {
+			list = append(list, *s)
+		}
+	}
+	lock.Unlock()
+	sort.Slice(list, func(i, j int) bool {
+		return sessionTime(&list[i]) < sessionTime(&list[j])
+	})
+	return list
+}
+
+func sessionTime(s *Session) string {
+	if s.NoStart {
+		return s.EndTime
+	}
+	return s.StartTime
+}
+
+func (q Query) matchNode(s *Session) bool {
+	if q.StationID != "" && (len(s.LaneID) < 16 || s.LaneID[0:16] != q.StationID[0:16]) {
+		return false
+	}
+	return q.LaneID == "" || s.LaneID == q.LaneID
+}
+
+func (q Query) match(s *Session) bool {
+	if !q.matchNode(s) {
+		return false
+	}
+	t := parseTime(sessionTime(s))
+	return !t.Before(store.Day(q.From)) && t.Before(store.Day(q.To).AddDate(0, 0, 1))
+}
+
+func parseTime(s string) time.Time {
+	t, err := time.ParseInLocation(timeFormat, s, time.Local)
+	if err != nil {
+		return time.Now()
+	}
+	return t
+}
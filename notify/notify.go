@@ -0,0 +1,252 @@
+package notify
+
+import (
+	"bytes"
+	"text/template"
+	"tollsys/tollmon/datastruct"
+	"tollsys/tollmon/g"
+	"tollsys/tollmon/parameters"
+	"tollsys/tollmon/spool"
+)
+
+//Event 报警通知内容，由报警消息及节点、策略信息组合而成，供通知模板使用
+type Event struct {
+	StationID   string
+	StationName string
+	LaneID      string
+	LaneName    string
+	Type        int
+	Description string
+	Level       int
+	Time        string
+	Content     map[string]interface{}
+}
+
+//Report 报表通知内容，HTML 为邮件正文，Text 为单行摘要
+type Report struct {
+	StationID string
+	Subject   string
+	HTML      string
+	Text      string
+}
+
+//channel 通知通道接口，SMTP、syslog等通道分别实现
+type channel interface {
+	name() string
+	filter() *g.NotifyFilter
+	send(e *Event) error
+	report(r *Report) error
+}
+
+//spoolRecord 通知缓冲队列中的记录，Event、Report 其一非空
+type spoolRecord struct {
+	Event  *Event  `json:"event,omitempty"`
+	Report *Report `json:"report,omitempty"`
+}
+
+var (
+	channels []channel
+	events   = make(chan *Event, 1000)
+	//queues 各通道的磁盘缓冲队列，通知先写入队列再由各通道按顺序发送，发送失败时保留并重试，不可重试或多次失败的通知写入死信文件
+	queues = make(map[string]*spool.Queue)
+)
+
+//InitNotify 根据配置创建通知通道，并以goroutine启动通知发送
+//未配置或未启用任何通道时不启动
+func InitNotify() {
+	cfg := g.Config().Notify
+	if cfg == nil {
+		return
+	}
+	if cfg.Smtp != nil && cfg.Smtp.Enabled {
+		c, err := newSmtpChannel(cfg.Smtp)
+		if err != nil {
+			g.LogError("init smtp notify err:", err.Error())
+		} else {
+			channels = append(channels, c)
+		}
+	}
+	if cfg.Syslog != nil && cfg.Syslog.Enabled {
+		c, err := newSyslogChannel(cfg.Syslog)
+		if err != nil {
+			g.LogError("init syslog notify err:", err.Error())
+		} else {
+			channels = append(channels, c)
+		}
+	}
+	if len(channels) == 0 {
+		return
+	}
+	for _, c := range channels {
+		q, err := spool.Open("notify-" + c.name())
+		if err != nil {
+			g.LogError(c.name(), " notify open spool err:", err.Error())
+			continue
+		}
+		queues[c.name()] = q
+		go q.Run(deliver(c))
+	}
+	go serve()
+	g.LogInfo("Init Notify OK...", len(channels), " channels")
+}
+
+//Dispatch 提交一条实时数据至通知队列，仅处理报警类消息
+//队列满时丢弃该通知，不阻塞实时数据推送
+func Dispatch(stationID string, d interface{}) {
+	if len(channels) == 0 {
+		return
+	}
+	v, ok := d.(datastruct.MsgSend)
+	if !ok || v.MsgCatalog != datastruct.MSGCATALOG_Alert {
+		return
+	}
+	e := newEvent(stationID, v)
+	select {
+	case events <- e:
+	default:
+		g.LogError("notify queue full, drop alert:", e.LaneID, " type:", e.Type)
+	}
+}
+
+func newEvent(stationID string, v datastruct.MsgSend) *Event {
+	e := &Event{
+		StationID: stationID,
+		LaneID:    v.MsgLane,
+		Type:      v.MsgType,
+		Time:      v.MsgTime,
+		Content:   make(map[string]interface{}),
+	}
+	for k, val := range v.MsgContent {
+		e.Content[k] = val
+	}
+	if item, ok := parameters.GetTypeToStrategyItems()[v.MsgType]; ok {
+		e.Description = item.Description
+		e.Level = item.Level
+	}
+	if node, ok := parameters.GetNodeByID(stationID); ok {
+		e.StationName = node.NodeName
+	}
+	if node, ok := parameters.GetNodeByID(v.MsgLane); ok {
+		e.LaneName = node.NodeName
+	}
+	return e
+}
+
+//serve 通知发送goroutine，逐条匹配各通道过滤条件并写入通道的缓冲队列
+func serve() {
+	g.LogInfo("goroutine start - notify")
+	for e := range events {
+		for _, c := range channels {
+			if !match(c.filter(), e) {
+				continue
+			}
+			enqueue(c, &spoolRecord{Event: e})
+		}
+	}
+}
+
+//SendReport 通过各通道发送报表，仅按通道过滤条件中的收费站过滤
+func SendReport(r *Report) {
+	for _, c := range channels {
+		if !matchStation(c.filter(), r.StationID) {
+			continue
+		}
+		enqueue(c, &spoolRecord{Report: r})
+	}
+}
+
+//enqueue 写入通道的缓冲队列，队列不可用时直接发送
+func enqueue(c channel, r *spoolRecord) {
+	if q, ok := queues[c.name()]; ok {
+		b, err := g.Json.Marshal(r)
+		if err == nil {
+			err = q.Push(b)
+		}
+		if err == nil {
+			return
+		}
+		g.LogError(c.name(), " notify spool err:", err.Error())
+	}
+	if err := r.send(c); err != nil {
+		g.LogError(c.name(), " notify err:", err.Error())
+	}
+}
+
+//deliver 返回通道缓冲队列的发送函数
+func deliver(c channel) func(b []byte) error {
+	return func(b []byte) error {
+		//UseNumber 保持Content中的数值格式与写入前一致
+		r := &spoolRecord{}
+		d := g.Json.NewDecoder(bytes.NewReader(b))
+		d.UseNumber()
+		if err := d.Decode(r); err != nil {
+			return spool.Permanent(err)
+		}
+		return r.send(c)
+	}
+}
+
+func (r *spoolRecord) send(c channel) error {
+	if r.Report != nil {
+		return c.report(r.Report)
+	}
+	if r.Event != nil {
+		return c.send(r.Event)
+	}
+	return nil
+}
+
+//match 判断报警是否满足通道过滤条件
+func match(f *g.NotifyFilter, e *Event) bool {
+	if f == nil {
+		return true
+	}
+	if e.Level < f.MinLevel {
+		return false
+	}
+	if !matchStation(f, e.StationID) {
+		return false
+	}
+	if len(f.Types) != 0 {
+		found := false
+		for _, t := range f.Types {
+			if t == e.Type {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+//matchStation 判断收费站是否满足通道过滤条件
+func matchStation(f *g.NotifyFilter, stationID string) bool {
+	if f == nil || len(f.Stations) == 0 {
+		return true
+	}
+	for _, id := range f.Stations {
+		if len(id) >= 16 && id[0:16] == stationID {
+			return true
+		}
+	}
+	return false
+}
+
+//parseTemplate 解析通知模板，模板为空时使用默认模板
+func parseTemplate(name string, text string, def string) (*template.Template, error) {
+	if text == "" {
+		text = def
+	}
+	return template.New(name).Parse(text)
+}
+
+func render(t *template.Template, e *Event) (string, error) {
+	var buffer bytes.Buffer
+	if err := t.Execute(&buffer, e); err != nil {
+		return "", err
+	}
+	return buffer.String(), nil
+}
@@ -0,0 +1,87 @@
+package notify
+
+import (
+	"testing"
+	"tollsys/tollmon/g"
+)
+
+func TestMatch(t *testing.T) {
+	e := &Event{StationID: "1F01010400010000", Type: 5, Level: 2}
+	cases := []struct {
+		name   string
+		filter *g.NotifyFilter
+		want   bool
+	}{
+		{"nil filter", nil, true},
+		{"empty filter", &g.NotifyFilter{}, true},
+		{"level reached", &g.NotifyFilter{MinLevel: 2}, true},
+		{"level below", &g.NotifyFilter{MinLevel: 3}, false},
+		{"station", &g.NotifyFilter{Stations: []string{"1F01010400010000"}}, true},
+		{"station by lane id", &g.NotifyFilter{Stations: []string{"1F010104000100000000000004"}}, true},
+		{"other station", &g.NotifyFilter{Stations: []string{"1F01010400020000"}}, false},
+		{"short station id", &g.NotifyFilter{Stations: []string{"1F0101"}}, false},
+		{"type", &g.NotifyFilter{Types: []int{3, 5}}, true},
+		{"other type", &g.NotifyFilter{Types: []int{3}}, false},
+		{"all conditions", &g.NotifyFilter{Stations: []string{"1F01010400010000"}, Types: []int{5}, MinLevel: 1}, true},
+		{"one condition fails", &g.NotifyFilter{Stations: []string{"1F01010400010000"}, Types: []int{5}, MinLevel: 3}, false},
+	}
+	for _, c := range cases {
+		if got := match(c.filter, e); got != c.want {
+			t.Errorf("%s: match = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestMatchStation(t *testing.T) {
+	f := &g.NotifyFilter{Types: []int{1}, MinLevel: 3, Stations: []string{"1F01010400010000"}}
+	if !matchStation(f, "1F01010400010000") {
+		t.Error("report for filtered station should match regardless of type and level")
+	}
+	if matchStation(f, "1F01010400020000") {
+		t.Error("report for other station should not match")
+	}
+}
+
+//recordChannel 记录发送内容的通知通道
+type recordChannel struct {
+	events  []*Event
+	reports []*Report
+}
+
+func (c *recordChannel) name() string            { return "record" }
+func (c *recordChannel) filter() *g.NotifyFilter { return nil }
+func (c *recordChannel) send(e *Event) error {
+	c.events = append(c.events, e)
+	return nil
+}
+func (c *recordChannel) report(r *Report) error {
+	c.reports = append(c.reports, r)
+	return nil
+}
+
+func TestDeliverSpoolRecord(t *testing.T) {
+	c := &recordChannel{}
+	records := []*spoolRecord{
+		{Event: &Event{StationID: "1F01010400010000", Level: 2, Content: map[string]interface{}{"count": 12345678}}},
+		{Report: &Report{StationID: "1F01010400010000", Text: "日报"}},
+	}
+	for _, r := range records {
+		b, err := g.Json.Marshal(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := deliver(c)(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(c.events) != 1 || len(c.reports) != 1 {
+		t.Fatalf("events = %d, reports = %d", len(c.events), len(c.reports))
+	}
+	tpl, _ := parseTemplate("count", "{{.Content.count}}", "")
+	if v, err := render(tpl, c.events[0]); err != nil || v != "12345678" {
+		t.Errorf("content count rendered as %q (%v)", v, err)
+	}
+	if c.reports[0].Text != "日报" {
+		t.Errorf("report = %+v", c.reports[0])
+	}
+}
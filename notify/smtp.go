@@ -0,0 +1,186 @@
+package notify
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"mime"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+	"tollsys/tollmon/g"
+	"tollsys/tollmon/spool"
+)
+
+const (
+	defaultMailSubject = "[{{.Level}}级报警] {{.StationName}} {{.LaneName}} {{.Description}}"
+	defaultMailBody    = `报警时间：{{.Time}}
+收费站：{{.StationName}}({{.StationID}})
+车道：{{.LaneName}}({{.LaneID}})
+报警类别：{{.Description}}({{.Type}})
+报警等级：{{.Level}}
+{{range $k, $v := .Content}}{{$k}}：{{$v}}
+{{end}}`
+)
+
+var (
+	//smtpDialTimeout 连接超时；smtpTimeout 单封邮件投递的总超时，避免服务器无响应时阻塞通知队列
+	smtpDialTimeout = 10 * time.Second
+	smtpTimeout     = time.Minute
+)
+
+//smtpChannel 邮件通知通道
+type smtpChannel struct {
+	cfg     *g.SmtpConfig
+	addr    string
+	subject *template.Template
+	body    *template.Template
+}
+
+func newSmtpChannel(cfg *g.SmtpConfig) (*smtpChannel, error) {
+	if cfg.Host == "" || cfg.From == "" {
+		return nil, errors.New("smtp host or from is blank")
+	}
+	port := cfg.Port
+	if port == 0 {
+		port = 25
+	}
+	subject, err := parseTemplate("subject", cfg.Subject, defaultMailSubject)
+	if err != nil {
+		return nil, err
+	}
+	body, err := parseTemplate("body", cfg.Body, defaultMailBody)
+	if err != nil {
+		return nil, err
+	}
+	return &smtpChannel{
+		cfg:     cfg,
+		addr:    net.JoinHostPort(cfg.Host, strconv.Itoa(port)),
+		subject: subject,
+		body:    body,
+	}, nil
+}
+
+func (s *smtpChannel) name() string {
+	return "smtp"
+}
+func (s *smtpChannel) filter() *g.NotifyFilter {
+	return s.cfg.Filter
+}
+
+//recipients 获取收费站对应的收件人，合并"*"配置的公共收件人
+func (s *smtpChannel) recipients(stationID string) []string {
+	to := make([]string, 0)
+	to = append(to, s.cfg.Recipients["*"]...)
+	to = append(to, s.cfg.Recipients[stationID]...)
+	return to
+}
+
+func (s *smtpChannel) send(e *Event) error {
+	to := s.recipients(e.StationID)
+	if len(to) == 0 {
+		return nil
+	}
+	subject, err := render(s.subject, e)
+	if err != nil {
+		return spool.Permanent(err)
+	}
+	body, err := render(s.body, e)
+	if err != nil {
+		return spool.Permanent(err)
+	}
+	return s.sendMail(to, subject, body, "text/plain")
+}
+
+//report 以HTML邮件发送报表
+func (s *smtpChannel) report(r *Report) error {
+	to := s.recipients(r.StationID)
+	if len(to) == 0 {
+		return nil
+	}
+	return s.sendMail(to, r.Subject, r.HTML, "text/html")
+}
+
+//sendMail 组装邮件并投递，配置了用户名时使用PLAIN认证
+//服务器返回5xx(如收件人被拒绝、认证失败)时重试无效，返回Permanent错误
+func (s *smtpChannel) sendMail(to []string, subject string, body string, contentType string) error {
+	var auth smtp.Auth
+	if s.cfg.User != "" {
+		auth = smtp.PlainAuth("", s.cfg.User, s.cfg.Pwd, s.cfg.Host)
+	}
+	err := s.deliver(auth, to, buildMail(s.cfg.From, to, subject, body, contentType))
+	if e, ok := err.(*textproto.Error); ok && e.Code >= 500 {
+		return spool.Permanent(err)
+	}
+	return err
+}
+
+//deliver 按smtp.SendMail的流程投递，连接及整个会话均有超时
+func (s *smtpChannel) deliver(auth smtp.Auth, to []string, msg []byte) error {
+	conn, err := net.DialTimeout("tcp", s.addr, smtpDialTimeout)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(smtpTimeout))
+	c, err := smtp.NewClient(conn, s.cfg.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: s.cfg.Host}); err != nil {
+			return err
+		}
+	}
+	if auth != nil {
+		if ok, _ := c.Extension("AUTH"); ok {
+			if err := c.Auth(auth); err != nil {
+				return err
+			}
+		}
+	}
+	if err := c.Mail(s.cfg.From); err != nil {
+		return err
+	}
+	for _, addr := range to {
+		if err := c.Rcpt(addr); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+//buildMail 生成UTF-8编码的邮件报文，正文采用base64传输编码
+func buildMail(from string, to []string, subject string, body string, contentType string) []byte {
+	var buffer bytes.Buffer
+	buffer.WriteString("From: " + from + "\r\n")
+	buffer.WriteString("To: " + strings.Join(to, ", ") + "\r\n")
+	buffer.WriteString("Subject: " + mime.BEncoding.Encode("UTF-8", subject) + "\r\n")
+	buffer.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	buffer.WriteString("MIME-Version: 1.0\r\n")
+	buffer.WriteString("Content-Type: " + contentType + "; charset=UTF-8\r\n")
+	buffer.WriteString("Content-Transfer-Encoding: base64\r\n\r\n")
+	encoded := base64.StdEncoding.EncodeToString([]byte(body))
+	for len(encoded) > 76 {
+		buffer.WriteString(encoded[:76] + "\r\n")
+		encoded = encoded[76:]
+	}
+	buffer.WriteString(encoded + "\r\n")
+	return buffer.Bytes()
+}
@@ -0,0 +1,228 @@
+package notify
+
+import (
+	"bufio"
+	"encoding/base64"
+	"mime"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"tollsys/tollmon/g"
+	"tollsys/tollmon/spool"
+)
+
+//smtpSession 本地SMTP替身服务器收到的一次投递
+type smtpSession struct {
+	auth string
+	from string
+	to   []string
+	data string
+}
+
+//startSmtpServer 启动仅支持AUTH PLAIN的本地SMTP替身服务器，每个连接完成后将会话写入返回的通道
+//以reject开头的收件人返回550
+func startSmtpServer(t *testing.T) (string, <-chan smtpSession) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	sessions := make(chan smtpSession, 1)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveSmtp(conn, sessions)
+		}
+	}()
+	return ln.Addr().String(), sessions
+}
+
+func serveSmtp(conn net.Conn, sessions chan<- smtpSession) {
+	defer conn.Close()
+	tp := textproto.NewConn(conn)
+	s := smtpSession{}
+	tp.PrintfLine("220 localhost ESMTP")
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch cmd {
+		case "EHLO", "HELO":
+			tp.PrintfLine("250-localhost")
+			tp.PrintfLine("250 AUTH PLAIN")
+		case "AUTH":
+			fields := strings.Fields(line)
+			if len(fields) != 3 || strings.ToUpper(fields[1]) != "PLAIN" {
+				tp.PrintfLine("504 unsupported")
+				continue
+			}
+			b, _ := base64.StdEncoding.DecodeString(fields[2])
+			s.auth = string(b)
+			tp.PrintfLine("235 authenticated")
+		case "MAIL":
+			s.from = line
+			tp.PrintfLine("250 ok")
+		case "RCPT":
+			rcpt := strings.Trim(strings.TrimPrefix(line, "RCPT TO:"), "<>")
+			if strings.HasPrefix(rcpt, "reject") {
+				tp.PrintfLine("550 no such user")
+				continue
+			}
+			s.to = append(s.to, rcpt)
+			tp.PrintfLine("250 ok")
+		case "DATA":
+			tp.PrintfLine("354 go ahead")
+			b, err := tp.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			s.data = string(b)
+			tp.PrintfLine("250 queued")
+			sessions <- s
+		case "QUIT":
+			tp.PrintfLine("221 bye")
+			return
+		default:
+			tp.PrintfLine("250 ok")
+		}
+	}
+}
+
+func newTestSmtpChannel(t *testing.T, addr string) *smtpChannel {
+	host, port, _ := net.SplitHostPort(addr)
+	p, _ := strconv.Atoi(port)
+	c, err := newSmtpChannel(&g.SmtpConfig{
+		Enabled: true,
+		Host:    host,
+		Port:    p,
+		User:    "tollmon",
+		Pwd:     "secret",
+		From:    "tollmon@localhost",
+		Recipients: map[string][]string{
+			"*":                {"center@localhost"},
+			"1F01010400010000": {"station@localhost"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestSmtpSend(t *testing.T) {
+	addr, sessions := startSmtpServer(t)
+	c := newTestSmtpChannel(t, addr)
+	e := &Event{
+		StationID:   "1F01010400010000",
+		StationName: "测试站",
+		LaneID:      "1F010104000100000000000004",
+		LaneName:    "入口1",
+		Type:        5,
+		Description: "车道断开",
+		Level:       2,
+		Time:        "2026-10-19 08:00:00",
+		Content:     map[string]interface{}{"ip": "192.168.1.51"},
+	}
+	if err := c.send(e); err != nil {
+		t.Fatal(err)
+	}
+	s := <-sessions
+
+	if s.auth != "\x00tollmon\x00secret" {
+		t.Errorf("auth = %q", s.auth)
+	}
+	if len(s.to) != 2 || s.to[0] != "center@localhost" || s.to[1] != "station@localhost" {
+		t.Errorf("recipients = %v", s.to)
+	}
+
+	msg, err := textproto.NewReader(bufio.NewReader(strings.NewReader(s.data))).ReadMIMEHeader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw := msg.Get("Subject")
+	if !strings.HasPrefix(raw, "=?UTF-8?b?") {
+		t.Errorf("subject not B-encoded: %q", raw)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "[2级报警] 测试站 入口1 车道断开"; subject != want {
+		t.Errorf("subject = %q, want %q", subject, want)
+	}
+	if msg.Get("Content-Transfer-Encoding") != "base64" {
+		t.Errorf("transfer encoding = %q", msg.Get("Content-Transfer-Encoding"))
+	}
+	//ReadDotBytes 已将CRLF转换为LF
+	i := strings.Index(s.data, "\n\n")
+	body, err := base64.StdEncoding.DecodeString(strings.Replace(s.data[i+2:], "\n", "", -1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"收费站：测试站(1F01010400010000)", "报警等级：2", "ip：192.168.1.51"} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("body missing %q:\n%s", want, body)
+		}
+	}
+}
+
+func TestSmtpReportRecipients(t *testing.T) {
+	addr, sessions := startSmtpServer(t)
+	c := newTestSmtpChannel(t, addr)
+	r := &Report{StationID: "1F01010400020000", Subject: "日报", HTML: "<p>ok</p>"}
+	if err := c.report(r); err != nil {
+		t.Fatal(err)
+	}
+	s := <-sessions
+	if len(s.to) != 1 || s.to[0] != "center@localhost" {
+		t.Errorf("recipients = %v", s.to)
+	}
+	if !strings.Contains(s.data, "Content-Type: text/html; charset=UTF-8") {
+		t.Errorf("report is not html:\n%s", s.data)
+	}
+}
+
+func TestSmtpRejectedRecipientIsPermanent(t *testing.T) {
+	addr, _ := startSmtpServer(t)
+	c := newTestSmtpChannel(t, addr)
+	c.cfg.Recipients = map[string][]string{"*": {"reject@localhost"}}
+	err := c.report(&Report{StationID: "1F01010400010000", Subject: "日报", HTML: "<p>ok</p>"})
+	if !spool.IsPermanent(err) {
+		t.Fatalf("rejected recipient err = %v, want permanent", err)
+	}
+}
+
+func TestSmtpTimeout(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	//接受连接后不发送问候，模拟无响应的服务器
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		time.Sleep(5 * time.Second)
+	}()
+	defer func(d time.Duration) { smtpTimeout = d }(smtpTimeout)
+	smtpTimeout = 200 * time.Millisecond
+	c := newTestSmtpChannel(t, ln.Addr().String())
+	start := time.Now()
+	if err := c.report(&Report{StationID: "1F01010400010000", Subject: "日报", HTML: "<p>ok</p>"}); err == nil {
+		t.Fatal("hung server accepted mail")
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("send returned after %v", d)
+	}
+}
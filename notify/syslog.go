@@ -0,0 +1,115 @@
+package notify
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+	"tollsys/tollmon/g"
+)
+
+const defaultSyslogTemplate = "{{.StationName}} {{.LaneName}} {{.Description}} level={{.Level}} time={{.Time}}"
+
+//syslogChannel RFC5424 syslog通知通道，支持udp和tcp
+//tcp采用RFC6587 octet-counting分帧，连接断开后在下次发送时重连
+type syslogChannel struct {
+	cfg      *g.SyslogConfig
+	msg      *template.Template
+	hostname string
+	lock     sync.Mutex
+	conn     net.Conn
+}
+
+func newSyslogChannel(cfg *g.SyslogConfig) (*syslogChannel, error) {
+	if cfg.Addr == "" {
+		return nil, errors.New("syslog addr is blank")
+	}
+	if cfg.Network == "" {
+		cfg.Network = "udp"
+	}
+	if cfg.Network != "udp" && cfg.Network != "tcp" {
+		return nil, errors.New("syslog network must be udp or tcp")
+	}
+	if cfg.AppName == "" {
+		cfg.AppName = "tollmon"
+	}
+	msg, err := parseTemplate("syslog", cfg.Template, defaultSyslogTemplate)
+	if err != nil {
+		return nil, err
+	}
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+	return &syslogChannel{cfg: cfg, msg: msg, hostname: hostname}, nil
+}
+
+func (s *syslogChannel) name() string {
+	return "syslog"
+}
+func (s *syslogChannel) filter() *g.NotifyFilter {
+	return s.cfg.Filter
+}
+
+func (s *syslogChannel) send(e *Event) error {
+	msg, err := render(s.msg, e)
+	if err != nil {
+		return err
+	}
+	return s.write(s.format(severity(e.Level), "ALERT"+strconv.Itoa(e.Type), msg))
+}
+
+//report 发送报表摘要，severity为Informational
+func (s *syslogChannel) report(r *Report) error {
+	return s.write(s.format(6, "REPORT", r.Text))
+}
+
+//format 按RFC5424格式化：<PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID SD MSG
+func (s *syslogChannel) format(sev int, msgID string, msg string) []byte {
+	pri := s.cfg.Facility*8 + sev
+	msg = strings.Replace(msg, "\n", " ", -1)
+	return []byte(fmt.Sprintf("<%d>1 %s %s %s %d %s - %s", pri, time.Now().Format(time.RFC3339),
+		s.hostname, s.cfg.AppName, os.Getpid(), msgID, msg))
+}
+
+func (s *syslogChannel) write(b []byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.cfg.Network == "tcp" {
+		b = append([]byte(strconv.Itoa(len(b))+" "), b...)
+	}
+	var err error
+	for i := 0; i < 2; i++ {
+		if s.conn == nil {
+			s.conn, err = net.DialTimeout(s.cfg.Network, s.cfg.Addr, 5*time.Second)
+			if err != nil {
+				return err
+			}
+		}
+		s.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+		if _, err = s.conn.Write(b); err == nil {
+			return nil
+		}
+		s.conn.Close()
+		s.conn = nil
+	}
+	return err
+}
+
+//severity 报警等级转换为syslog severity，等级越高越严重
+func severity(level int) int {
+	switch {
+	case level >= 3:
+		return 2 //Critical
+	case level == 2:
+		return 3 //Error
+	case level == 1:
+		return 4 //Warning
+	}
+	return 5 //Notice
+}
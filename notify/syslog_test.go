@@ -0,0 +1,142 @@
+package notify
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"tollsys/tollmon/g"
+)
+
+//rfc5424 <PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID - MSG
+var rfc5424 = regexp.MustCompile(`^<(\d+)>1 (\S+) (\S+) (\S+) (\d+) (\S+) - (.*)$`)
+
+var testEvent = &Event{
+	StationID:   "1F01010400010000",
+	StationName: "测试站",
+	LaneName:    "入口1",
+	Type:        5,
+	Description: "车道断开",
+	Level:       2,
+	Time:        "2026-10-19 08:00:00",
+}
+
+func checkSyslogMessage(t *testing.T, msg string, pri int, msgID string, text string) {
+	t.Helper()
+	m := rfc5424.FindStringSubmatch(msg)
+	if m == nil {
+		t.Fatalf("not RFC5424: %q", msg)
+	}
+	if m[1] != strconv.Itoa(pri) {
+		t.Errorf("pri = %s, want %d", m[1], pri)
+	}
+	if _, err := time.Parse(time.RFC3339, m[2]); err != nil {
+		t.Errorf("timestamp %q: %v", m[2], err)
+	}
+	if m[4] != "tollmon" || m[5] != strconv.Itoa(os.Getpid()) {
+		t.Errorf("app/procid = %s/%s", m[4], m[5])
+	}
+	if m[6] != msgID {
+		t.Errorf("msgid = %s, want %s", m[6], msgID)
+	}
+	if m[7] != text {
+		t.Errorf("msg = %q, want %q", m[7], text)
+	}
+}
+
+func newTestSyslogChannel(t *testing.T, network string, addr string) *syslogChannel {
+	c, err := newSyslogChannel(&g.SyslogConfig{Enabled: true, Network: network, Addr: addr, Facility: 16})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestSyslogUDP(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	c := newTestSyslogChannel(t, "udp", pc.LocalAddr().String())
+	if err := c.send(testEvent); err != nil {
+		t.Fatal(err)
+	}
+	pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	b := make([]byte, 2048)
+	n, _, err := pc.ReadFrom(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//facility 16(local0)*8 + severity 3(Error，2级报警)
+	checkSyslogMessage(t, string(b[:n]), 131, "ALERT5", "测试站 入口1 车道断开 level=2 time=2026-10-19 08:00:00")
+}
+
+func TestSyslogTCPOctetCounting(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	frames := make(chan string, 2)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		for {
+			//MSG-LEN SP SYSLOG-MSG
+			s, err := r.ReadString(' ')
+			if err != nil {
+				return
+			}
+			n, err := strconv.Atoi(strings.TrimSuffix(s, " "))
+			if err != nil {
+				frames <- "bad length " + s
+				return
+			}
+			b := make([]byte, n)
+			if _, err := io.ReadFull(r, b); err != nil {
+				return
+			}
+			frames <- string(b)
+		}
+	}()
+	c := newTestSyslogChannel(t, "tcp", ln.Addr().String())
+	if err := c.send(testEvent); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.report(&Report{Text: "日报\n第二行"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []struct {
+		pri   int
+		msgID string
+		text  string
+	}{
+		{131, "ALERT5", "测试站 入口1 车道断开 level=2 time=2026-10-19 08:00:00"},
+		{134, "REPORT", "日报 第二行"},
+	} {
+		select {
+		case f := <-frames:
+			checkSyslogMessage(t, f, want.pri, want.msgID, want.text)
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for syslog frame")
+		}
+	}
+}
+
+func TestSeverity(t *testing.T) {
+	for level, want := range map[int]int{0: 5, 1: 4, 2: 3, 3: 2, 5: 2} {
+		if got := severity(level); got != want {
+			t.Errorf("severity(%d) = %d, want %d", level, got, want)
+		}
+	}
+}
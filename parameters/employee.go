@@ -0,0 +1,51 @@
+package parameters
+
+import (
+	"strconv"
+	"sync"
+	"tollsys/tollmon/redis"
+)
+
+const (
+	EMPLOYEE = "Employee" //员工工号 -> 姓名 redis hash
+)
+
+var (
+	employeeLock  = &sync.Mutex{}
+	employeeNames = make(map[int]string)
+)
+
+//loadEmployees 从redis加载员工姓名
+func loadEmployees() {
+	b := redis.HGetALL(EMPLOYEE)
+	employeeLock.Lock()
+	defer employeeLock.Unlock()
+	for index := 0; index+1 < len(b); index += 2 {
+		id, err := strconv.Atoi(string(b[index].([]byte)))
+		if err != nil {
+			continue
+		}
+		employeeNames[id] = string(b[index+1].([]byte))
+	}
+}
+
+//SetEmployeeName 登记上下班消息中的员工姓名，姓名变更时写入redis
+func SetEmployeeName(id int, name string) {
+	if id == 0 || name == "" {
+		return
+	}
+	employeeLock.Lock()
+	defer employeeLock.Unlock()
+	if employeeNames[id] == name {
+		return
+	}
+	employeeNames[id] = name
+	redis.HSet(EMPLOYEE, strconv.Itoa(id), []byte(name))
+}
+
+//GetEmployeeName 根据工号获取员工姓名，未登记时返回空字符串
+func GetEmployeeName(id int) string {
+	employeeLock.Lock()
+	defer employeeLock.Unlock()
+	return employeeNames[id]
+}
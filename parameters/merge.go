@@ -0,0 +1,115 @@
+package parameters
+
+import (
+	"sync"
+	"tollsys/tollmon/datastruct"
+)
+
+//合并下级tollmon上报的节点及车道数据
+//合并的收费站、广场、车道节点加入节点树，可通过GetNodeByID、GetStationTrees查询，并建立LaneInfo/CoreData；
+//不加入GetLanes车道列表及IP索引，不参与本地车道连接、可用率等统计
+
+//nodeLock 保护运行期间合并的节点树及节点索引
+var nodeLock = &sync.RWMutex{}
+
+//MergeStation 合并下级上报的收费站节点树，仅添加本地不存在的节点，返回新增的节点数
+func MergeStation(tree datastruct.Station) int {
+	if len(tree.Station.NodeID) < 16 {
+		return 0
+	}
+	added := make([]datastruct.Node, 0)
+	addedLanes := make([]datastruct.Node, 0)
+	nodeLock.Lock()
+	//节点树按写时复制更新，已由GetStationTrees返回的节点树不受影响
+	i := -1
+	for k, station := range StationNodes {
+		if station.Station.NodeID == tree.Station.NodeID {
+			i = k
+			break
+		}
+	}
+	station := datastruct.Station{Station: tree.Station}
+	if i >= 0 {
+		station = StationNodes[i]
+	}
+	if _, ok := idToNode[tree.Station.NodeID]; !ok {
+		idToNode[tree.Station.NodeID] = tree.Station
+		added = append(added, tree.Station)
+	}
+	plazas := append([]datastruct.Plaza{}, station.Plazas...)
+	for _, plaza := range tree.Plazas {
+		j := -1
+		for k, p := range plazas {
+			if p.Plaza.NodeID == plaza.Plaza.NodeID {
+				j = k
+				break
+			}
+		}
+		if j < 0 {
+			plazas = append(plazas, datastruct.Plaza{Plaza: plaza.Plaza})
+			j = len(plazas) - 1
+		}
+		if _, ok := idToNode[plaza.Plaza.NodeID]; !ok {
+			idToNode[plaza.Plaza.NodeID] = plaza.Plaza
+			added = append(added, plaza.Plaza)
+		}
+		lanes := append([]datastruct.Node{}, plazas[j].Lanes...)
+		for _, lane := range plaza.Lanes {
+			if _, ok := idToNode[lane.NodeID]; ok {
+				continue
+			}
+			lanes = append(lanes, lane)
+			idToNode[lane.NodeID] = lane
+			added = append(added, lane)
+			addedLanes = append(addedLanes, lane)
+		}
+		plazas[j].Lanes = lanes
+	}
+	station.Plazas = plazas
+	if i >= 0 {
+		StationNodes[i] = station
+	} else {
+		StationNodes = append(StationNodes, station)
+	}
+	nodeLock.Unlock()
+
+	lock.Lock()
+	defer lock.Unlock()
+	for _, node := range addedLanes {
+		if _, ok := laneInfo[node.NodeID]; !ok {
+			laneInfo[node.NodeID] = datastruct.NewLaneInfo(node)
+		}
+		if _, ok := coreInfo[node.NodeID]; !ok {
+			coreInfo[node.NodeID] = datastruct.NewCoreData(node)
+		}
+	}
+	return len(added)
+}
+
+//SetLaneInfo 以下级上报的车道数据更新车道信息，车道不存在时返回false
+func SetLaneInfo(info datastruct.LaneInfo) bool {
+	lock.Lock()
+	defer lock.Unlock()
+	l, ok := laneInfo[info.Node.NodeID]
+	if !ok {
+		return false
+	}
+	for k, v := range info.Info {
+		l.UpdateInfo(k, v)
+	}
+	return true
+}
+
+//SetCoreData 以下级上报的核心数据更新车道核心数据，车道不存在时返回false
+func SetCoreData(data datastruct.CoreData) bool {
+	lock.Lock()
+	defer lock.Unlock()
+	c, ok := coreInfo[data.Node.NodeID]
+	if !ok {
+		return false
+	}
+	for k, v := range data.CoreData {
+		c.UpdateData(k, v)
+	}
+	return true
+}
@@ -21,6 +21,7 @@ var (
 	PlazaNodes   []datastruct.Plaza
 
 	ipToNode       map[string]datastruct.Node
+	idToNode       map[string]datastruct.Node
 	laneInfo       map[string]datastruct.LaneInfo
 	strategyItems  []datastruct.StrategyItem
 	typeToStrategy map[int]datastruct.StrategyItem
@@ -31,11 +32,18 @@ var (
 )
 
 func GetStationTrees() []datastruct.Station {
-	return StationNodes
+	nodeLock.RLock()
+	defer nodeLock.RUnlock()
+	return append([]datastruct.Station{}, StationNodes...)
 }
 func GetPlazaTrees() []datastruct.Node {
 	return plazas
 }
+
+//GetLanes 获取全部车道节点
+func GetLanes() []datastruct.Node {
+	return lanes
+}
 func init() {
 	lock = &sync.Mutex{}
 	result = &db.QueryResultNodeList{}
@@ -46,6 +54,7 @@ func init() {
 	PlazaNodes = []datastruct.Plaza{}
 
 	ipToNode = make(map[string]datastruct.Node)
+	idToNode = make(map[string]datastruct.Node)
 	laneInfo = make(map[string]datastruct.LaneInfo)
 	coreInfo = make(map[string]datastruct.CoreData)
 
@@ -54,6 +63,14 @@ func init() {
 	laneQueue = make(map[string]time.Time)
 }
 
+//derivedStrategyItems 统计模块产生的报警类别的缺省策略项，与 config/strategyitems.json 一致
+var derivedStrategyItems = []datastruct.StrategyItem{
+	{Type: datastruct.MSGTYPE_CardForecast, Description: "通行卡存量预警", IsChecked: true, Level: 1},
+	{Type: datastruct.MSGTYPE_ReprintRate, Description: "票据重打率异常", IsChecked: true, Level: 1},
+	{Type: datastruct.MSGTYPE_ReaderMaintain, Description: "卡机维护报警", IsChecked: true, Level: 2},
+	{Type: datastruct.MSGTYPE_MotorcadeExceed, Description: "车队放行超限", IsChecked: true, Level: 2},
+}
+
 //初始化Parameters模块
 func InitParameters() {
 	g.LogInfo("Init Parameters...")
@@ -62,6 +79,8 @@ func InitParameters() {
 	loadLaneInfo()
 	loadCoreInfo()
 	loadStrategyItems()
+	loadStrategyProfiles()
+	loadEmployees()
 	g.LogInfo("Init Parameters OK...")
 }
 func loadStations() {
@@ -123,12 +142,15 @@ func loadNodeTree() {
 func loadNodeMap() {
 	for _, station := range stations {
 		ipToNode[station.NodeIP] = station
+		idToNode[station.NodeID] = station
 	}
 	for _, plaza := range plazas {
 		ipToNode[plaza.NodeIP] = plaza
+		idToNode[plaza.NodeID] = plaza
 	}
 	for _, lane := range lanes {
 		ipToNode[lane.NodeIP] = lane
+		idToNode[lane.NodeID] = lane
 	}
 }
 func loadCoreInfo() {
@@ -156,6 +178,14 @@ func loadStrategyItems() {
 	for _, v := range strategyItems {
 		typeToStrategy[v.Type] = v
 	}
+	//升级后未重新执行 -s 写入策略项时，补充统计模块产生的报警类别，否则这些报警不会推送及通知
+	for _, v := range derivedStrategyItems {
+		if _, ok := typeToStrategy[v.Type]; !ok {
+			g.LogError("strategy item ", v.Type, " missing in redis, use default:", v.Description)
+			strategyItems = append(strategyItems, v)
+			typeToStrategy[v.Type] = v
+		}
+	}
 }
 func GetNodeByIP(ip string) (*datastruct.Node, bool) {
 	if laneNode, ok := ipToNode[ip]; ok {
@@ -163,9 +193,28 @@ func GetNodeByIP(ip string) (*datastruct.Node, bool) {
 	}
 	return nil, false
 }
+//GetNodeByID 根据节点编码获取站、广场、车道节点，包括下级上报合并的节点
+//收费站节点编码允许使用16位前缀
+func GetNodeByID(id string) (*datastruct.Node, bool) {
+	nodeLock.RLock()
+	defer nodeLock.RUnlock()
+	if node, ok := idToNode[id]; ok {
+		return &node, true
+	}
+	if len(id) == 16 {
+		for _, station := range StationNodes {
+			if station.Station.NodeID[0:16] == id {
+				return &station.Station, true
+			}
+		}
+	}
+	return nil, false
+}
 func GetLaneInfoByIP(ip string) *datastruct.LaneInfo {
 	if laneNode, ok := ipToNode[ip]; ok {
+		lock.Lock()
 		info := laneInfo[laneNode.NodeID]
+		lock.Unlock()
 		return &info
 	}
 	return nil
@@ -191,12 +240,16 @@ func UpdateCoreInfo(id string, key string, val interface{}) {
 	lock.Unlock()
 }
 func GetCoreInfoById(id string) *datastruct.CoreData {
+	lock.Lock()
 	data := coreInfo[id]
+	lock.Unlock()
 	return &data
 }
 
 func GetLaneInfoByStationID(id string) []datastruct.LaneInfo {
 	list := make([]datastruct.LaneInfo, 0)
+	lock.Lock()
+	defer lock.Unlock()
 	for k, v := range laneInfo {
 		if k[0:16] == id[0:16] {
 			list = append(list, v)
@@ -206,6 +259,8 @@ func GetLaneInfoByStationID(id string) []datastruct.LaneInfo {
 }
 func GetCoreDataByStationID(id string) []datastruct.CoreData {
 	list := make([]datastruct.CoreData, 0)
+	lock.Lock()
+	defer lock.Unlock()
 	for k, v := range coreInfo {
 		if k[0:16] == id[0:16] {
 			list = append(list, v)
@@ -0,0 +1,132 @@
+package parameters
+
+import (
+	"errors"
+	"sort"
+	"tollsys/tollmon/datastruct"
+	"tollsys/tollmon/g"
+	"tollsys/tollmon/redis"
+)
+
+const (
+	PROFILE        = "StrategyProfile" //报警策略方案 redis hash
+	DefaultProfile = "default"         //默认方案名称，不允许删除
+)
+
+//loadStrategyProfiles 初始化报警策略方案
+//redis中不存在默认方案时，以全局报警策略创建默认方案
+func loadStrategyProfiles() {
+	if redis.HExists(PROFILE, DefaultProfile) {
+		return
+	}
+	p := datastruct.StrategyProfile{
+		Name:        DefaultProfile,
+		Description: "默认方案",
+		Items:       strategyItems,
+		Operators:   make([]string, 0),
+		Stations:    make([]string, 0),
+	}
+	b, _ := g.Json.Marshal(p)
+	redis.HSetNX(PROFILE, DefaultProfile, b)
+}
+
+//GetStrategyProfiles 获取全部报警策略方案，按方案名称排序
+func GetStrategyProfiles() []datastruct.StrategyProfile {
+	list := make([]datastruct.StrategyProfile, 0)
+	b := redis.HGetALL(PROFILE)
+	for index := 0; index+1 < len(b); index += 2 {
+		p := datastruct.StrategyProfile{}
+		err := g.Json.Unmarshal(b[index+1].([]byte), &p)
+		if err != nil {
+			g.LogError("parse strategy profile err:", string(b[index].([]byte)), err.Error())
+			continue
+		}
+		list = append(list, p)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+//GetStrategyProfile 根据名称获取报警策略方案
+func GetStrategyProfile(name string) (*datastruct.StrategyProfile, bool) {
+	if name == "" || !redis.HExists(PROFILE, name) {
+		return nil, false
+	}
+	p := datastruct.StrategyProfile{}
+	err := g.Json.Unmarshal(redis.HGet(PROFILE, name), &p)
+	if err != nil {
+		g.LogError("parse strategy profile err:", name, err.Error())
+		return nil, false
+	}
+	return &p, true
+}
+
+//SaveStrategyProfile 新建或更新报警策略方案
+//方案未配置报警策略时使用全局报警策略
+func SaveStrategyProfile(p datastruct.StrategyProfile) error {
+	if p.Name == "" {
+		return errors.New("profile name is blank")
+	}
+	if len(p.Items) == 0 {
+		p.Items = strategyItems
+	}
+	if p.Operators == nil {
+		p.Operators = make([]string, 0)
+	}
+	stations := make([]string, 0)
+	for _, id := range p.Stations {
+		if len(id) < 16 {
+			return errors.New("invalid station id " + id)
+		}
+		stations = append(stations, id[0:16])
+	}
+	p.Stations = stations
+	b, err := g.Json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	redis.HSet(PROFILE, p.Name, b)
+	return nil
+}
+
+//DeleteStrategyProfile 删除报警策略方案，默认方案不允许删除
+func DeleteStrategyProfile(name string) error {
+	if name == DefaultProfile {
+		return errors.New("default profile cannot be deleted")
+	}
+	if !redis.HExists(PROFILE, name) {
+		return errors.New(name + " is not exists")
+	}
+	redis.HDel(PROFILE, name)
+	return nil
+}
+
+//ResolveStrategyProfile 获取操作员或收费站适用的报警策略方案
+//优先级：指定给操作员的方案 > 指定给所请求收费站的方案 > 默认方案
+func ResolveStrategyProfile(operator string, stationIds []string) *datastruct.StrategyProfile {
+	profiles := GetStrategyProfiles()
+	if operator != "" {
+		for _, p := range profiles {
+			for _, o := range p.Operators {
+				if o == operator {
+					return &p
+				}
+			}
+		}
+	}
+	for _, p := range profiles {
+		for _, s := range p.Stations {
+			for _, id := range stationIds {
+				if len(id) >= 16 && id[0:16] == s {
+					return &p
+				}
+			}
+		}
+	}
+	if p, ok := GetStrategyProfile(DefaultProfile); ok {
+		return p
+	}
+	return &datastruct.StrategyProfile{Name: DefaultProfile, Items: strategyItems}
+}
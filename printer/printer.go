@@ -0,0 +1,373 @@
+package printer
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"tollsys/tollmon/datastruct"
+	"tollsys/tollmon/g"
+	"tollsys/tollmon/parameters"
+	"tollsys/tollmon/store"
+	"tollsys/tollmon/traffic"
+)
+
+//出口车道票据打印机状态
+//根据打印票存量、定额票余额、票据模式切换及票据重打报警，按车道统计当日打印机状态，
+//票据事件写入本地存储，服务重启时以当日事件恢复
+
+const (
+	KIND = "printer"
+
+	recentSize = 20
+
+	dateFormat = "2006-01-02"
+	timeFormat = "2006-01-02 15:04:05"
+)
+
+//Event 票据事件
+type Event struct {
+	LaneID      string `json:"laneID"`
+	Type        int    `json:"type"`
+	Time        string `json:"time"`
+	Shift       int    `json:"shift"`
+	EmpID       int    `json:"empID"`
+	Threshold   int    `json:"threshold,omitempty"`
+	Current     int    `json:"current,omitempty"`
+	OrigMode    int    `json:"origMode,omitempty"`
+	CurrMode    int    `json:"currMode,omitempty"`
+	PrintTimes  int    `json:"printTimes,omitempty"`
+	PrintNoteNo string `json:"printNoteNo,omitempty"`
+}
+
+//Stock 票据存量，Time为最近一次上报时间
+type Stock struct {
+	Threshold int    `json:"threshold"`
+	Current   int    `json:"current"`
+	Time      string `json:"time"`
+}
+
+//Reprint 票据重打记录
+type Reprint struct {
+	Time        string `json:"time"`
+	EmpID       int    `json:"empID"`
+	PrintTimes  int    `json:"printTimes"`
+	PrintNoteNo string `json:"printNoteNo"`
+}
+
+//Printer 车道当日打印机状态
+//HandMode 为当前是否为手工票模式；ReprintRate 为当日重打次数占出口流量的百分比；
+//Abnormal 为最近统计时长内重打率是否超过报警阈值
+type Printer struct {
+	LaneID       string    `json:"laneID"`
+	LaneName     string    `json:"laneName"`
+	Date         string    `json:"date"`
+	PrintStock   *Stock    `json:"printStock"`
+	HandStock    *Stock    `json:"handStock"`
+	Mode         int       `json:"mode"`
+	ModeTime     string    `json:"modeTime"`
+	HandMode     bool      `json:"handMode"`
+	ModeChanges  int       `json:"modeChanges"`
+	HandSwitches int       `json:"handSwitches"`
+	Reprints     int       `json:"reprints"`
+	ReprintNotes int       `json:"reprintNotes"`
+	Exits        int       `json:"exits"`
+	ReprintRate  float64   `json:"reprintRate"`
+	Abnormal     bool      `json:"abnormal"`
+	Recent       []Reprint `json:"recent"`
+
+	notes     map[string]bool
+	window    []time.Time
+	alertTime time.Time
+}
+
+//Fleet 打印机状态汇总
+type Fleet struct {
+	Lanes       int       `json:"lanes"`
+	Reprints    int       `json:"reprints"`
+	ModeChanges int       `json:"modeChanges"`
+	HandMode    int       `json:"handMode"`
+	LowStock    int       `json:"lowStock"`
+	Abnormal    int       `json:"abnormal"`
+	Printers    []Printer `json:"printers"`
+}
+
+var (
+	lock     = &sync.Mutex{}
+	printers = make(map[string]*Printer)
+)
+
+//InitPrinter 以本地存储中当日的票据事件恢复打印机状态
+func InitPrinter() {
+	now := time.Now()
+	lock.Lock()
+	defer lock.Unlock()
+	err := store.Load(KIND, now, now, func(b []byte) {
+		e := Event{}
+		if err := g.Json.Unmarshal(b, &e); err != nil {
+			g.LogError("parse printer event err:", err.Error())
+			return
+		}
+		apply(e, parseTime(e.Time))
+	})
+	if err != nil {
+		g.LogError("load printer event err:", err.Error())
+	}
+}
+
+//Handle 处理票据报警消息，返回需发布的重打率异常报警
+func Handle(msg datastruct.MsgSend) []datastruct.MsgSend {
+	e := Event{
+		LaneID:      msg.MsgLane,
+		Type:        msg.MsgType,
+		Time:        msg.MsgTime,
+		Shift:       msg.GetInt("Shift"),
+		EmpID:       msg.GetInt("EmpID"),
+		Threshold:   msg.GetInt("Threshold"),
+		Current:     msg.GetInt("Current"),
+		OrigMode:    msg.GetInt("OrigMode"),
+		CurrMode:    msg.GetInt("CurrMode"),
+		PrintTimes:  msg.GetInt("PrintTimes"),
+		PrintNoteNo: strings.TrimSpace(msg.GetString("PrintNoteNo")),
+	}
+	t := parseTime(e.Time)
+	if err := store.Append(KIND, t, e); err != nil {
+		g.LogError("save printer event err:", e.LaneID, err.Error())
+	}
+	lock.Lock()
+	p := apply(e, t)
+	if e.Type != datastruct.MSGTYPE_NoteAgain {
+		lock.Unlock()
+		return nil
+	}
+	reprints := windowCount(p, t)
+	lock.Unlock()
+	//流量统计需读取磁盘，不在lock内查询
+	exits := windowExits(e.LaneID, t)
+	lock.Lock()
+	defer lock.Unlock()
+	return checkRate(p, e, t, reprints, exits)
+}
+
+//apply 按票据事件更新打印机状态
+//调用方需持有lock
+func apply(e Event, t time.Time) *Printer {
+	p := getPrinter(e.LaneID, t.Format(dateFormat))
+	switch e.Type {
+	case datastruct.MSGTYPE_NotePrint:
+		p.PrintStock = &Stock{Threshold: e.Threshold, Current: e.Current, Time: e.Time}
+	case datastruct.MSGTYPE_NoteHand:
+		p.HandStock = &Stock{Threshold: e.Threshold, Current: e.Current, Time: e.Time}
+	case datastruct.MSGTYPE_NoteModeChange:
+		p.Mode = e.CurrMode
+		p.ModeTime = e.Time
+		p.ModeChanges++
+		p.HandMode = isHandMode(e.CurrMode)
+		if p.HandMode && !isHandMode(e.OrigMode) {
+			p.HandSwitches++
+		}
+	case datastruct.MSGTYPE_NoteAgain:
+		p.Reprints++
+		if e.PrintNoteNo != "" && !p.notes[e.PrintNoteNo] {
+			p.notes[e.PrintNoteNo] = true
+			p.ReprintNotes++
+		}
+		p.Recent = append(p.Recent, Reprint{Time: e.Time, EmpID: e.EmpID, PrintTimes: e.PrintTimes, PrintNoteNo: e.PrintNoteNo})
+		if len(p.Recent) > recentSize {
+			p.Recent = p.Recent[len(p.Recent)-recentSize:]
+		}
+		p.window = append(p.window, t)
+	}
+	return p
+}
+
+//getPrinter 获取车道打印机状态，日期变更时清零当日统计
+//调用方需持有lock
+func getPrinter(laneID string, date string) *Printer {
+	p, ok := printers[laneID]
+	if !ok {
+		p = &Printer{LaneID: laneID, Date: date, Recent: make([]Reprint, 0), notes: make(map[string]bool)}
+		if node, ok := parameters.GetNodeByID(laneID); ok {
+			p.LaneName = node.NodeName
+		}
+		printers[laneID] = p
+	}
+	if date > p.Date {
+		p.Date = date
+		p.ModeChanges, p.HandSwitches, p.Reprints, p.ReprintNotes = 0, 0, 0, 0
+		p.Recent = make([]Reprint, 0)
+		p.notes = make(map[string]bool)
+	}
+	return p
+}
+
+func isHandMode(mode int) bool {
+	if g.Config().Printer == nil {
+		return false
+	}
+	for _, m := range g.Config().Printer.HandModes {
+		if m == mode {
+			return true
+		}
+	}
+	return false
+}
+
+func settings() (time.Duration, int, float64) {
+	window, minReprints, maxRate := 60, 3, 5.0
+	if c := g.Config().Printer; c != nil {
+		if c.WindowMinutes > 0 {
+			window = c.WindowMinutes
+		}
+		if c.MinReprints > 0 {
+			minReprints = c.MinReprints
+		}
+		if c.MaxReprintRate > 0 {
+			maxRate = c.MaxReprintRate
+		}
+	}
+	return time.Duration(window) * time.Minute, minReprints, maxRate
+}
+
+//windowCount 清除统计时长以前的重打记录，返回最近统计时长内的重打次数
+//调用方需持有lock
+func windowCount(p *Printer, now time.Time) int {
+	window, _, _ := settings()
+	start := now.Add(-window)
+	for len(p.window) > 0 && p.window[0].Before(start) {
+		p.window = p.window[1:]
+	}
+	return len(p.window)
+}
+
+//windowExits 统计车道最近统计时长内的出口流量，调用方不可持有lock
+func windowExits(laneID string, now time.Time) int {
+	window, _, _ := settings()
+	return traffic.Sum(laneID, now.Add(-window), now).Exit
+}
+
+func rate(reprints int, exits int) float64 {
+	if exits == 0 {
+		if reprints == 0 {
+			return 0
+		}
+		return 100
+	}
+	return float64(reprints) * 100 / float64(exits)
+}
+
+//checkRate 以统计时长内的重打次数及出口流量计算重打率，超过阈值时生成报警，同一车道在统计时长内只报警一次
+//调用方需持有lock
+func checkRate(p *Printer, e Event, now time.Time, reprints int, exits int) []datastruct.MsgSend {
+	window, minReprints, maxRate := settings()
+	r := rate(reprints, exits)
+	p.Abnormal = reprints >= minReprints && r > maxRate
+	if !p.Abnormal || now.Sub(p.alertTime) < window {
+		return nil
+	}
+	p.alertTime = now
+	msg := datastruct.NewMsgSend()
+	msg.MsgCatalog = datastruct.MSGCATALOG_Alert
+	msg.MsgType = datastruct.MSGTYPE_ReprintRate
+	msg.MsgTime = e.Time
+	msg.MsgLane = p.LaneID
+	msg.MsgContent["Shift"] = e.Shift
+	msg.MsgContent["EmpID"] = e.EmpID
+	msg.MsgContent["Reprints"] = reprints
+	msg.MsgContent["Exits"] = exits
+	msg.MsgContent["Rate"] = strconv.FormatFloat(r, 'f', 1, 64)
+	g.LogDebug("票据重打率异常-[LaneID:", p.LaneID, " reprints:", reprints, " exits:", exits, "]")
+	return []datastruct.MsgSend{msg}
+}
+
+//GetFleet 汇总所选车道的打印机状态，仅包含当日有票据事件的车道
+func GetFleet(laneList []datastruct.Node, now time.Time) Fleet {
+	_, minReprints, maxRate := settings()
+	f := Fleet{Printers: make([]Printer, 0)}
+	date := now.Format(dateFormat)
+	start := store.Day(now)
+	type entry struct {
+		p        *Printer
+		reprints int
+	}
+	//在lock内复制打印机状态，流量统计在lock外查询后再更新
+	entries := make([]entry, 0)
+	lock.Lock()
+	for _, lane := range laneList {
+		p, ok := printers[lane.NodeID]
+		if !ok {
+			continue
+		}
+		p = getPrinter(lane.NodeID, date)
+		entries = append(entries, entry{p: p, reprints: windowCount(p, now)})
+		c := *p
+		c.Recent = append(make([]Reprint, 0, len(p.Recent)), p.Recent...)
+		c.notes, c.window = nil, nil
+		f.Printers = append(f.Printers, c)
+	}
+	lock.Unlock()
+	for i := range f.Printers {
+		c := &f.Printers[i]
+		reprints := entries[i].reprints
+		r := rate(reprints, windowExits(c.LaneID, now))
+		c.Abnormal = reprints >= minReprints && r > maxRate
+		c.Exits = traffic.Sum(c.LaneID, start, now).Exit
+		c.ReprintRate = rate(c.Reprints, c.Exits)
+		f.Reprints += c.Reprints
+		f.ModeChanges += c.ModeChanges
+		if c.HandMode {
+			f.HandMode++
+		}
+		if (c.PrintStock != nil && c.PrintStock.Current <= c.PrintStock.Threshold) ||
+			(c.HandStock != nil && c.HandStock.Current <= c.HandStock.Threshold) {
+			f.LowStock++
+		}
+		if c.Abnormal {
+			f.Abnormal++
+		}
+	}
+	lock.Lock()
+	for i, e := range entries {
+		if e.p.Date == f.Printers[i].Date {
+			e.p.Abnormal, e.p.Exits, e.p.ReprintRate = f.Printers[i].Abnormal, f.Printers[i].Exits, f.Printers[i].ReprintRate
+		}
+	}
+	lock.Unlock()
+	f.Lanes = len(f.Printers)
+	sort.Slice(f.Printers, func(i, j int) bool {
+		return f.Printers[i].LaneID < f.Printers[j].LaneID
+	})
+	return f
+}
+
+//GetReprints 查询车道[from,to]日期范围内的票据重打记录，printNoteNo 不为空时按票号过滤
+func GetReprints(laneList []datastruct.Node, from time.Time, to time.Time, printNoteNo string) []Event {
+	selected := make(map[string]bool)
+	for _, lane := range laneList {
+		selected[lane.NodeID] = true
+	}
+	list := make([]Event, 0)
+	err := store.Load(KIND, from, to, func(b []byte) {
+		e := Event{}
+		if err := g.Json.Unmarshal(b, &e); err != nil {
+			g.LogError("parse printer event err:", err.Error())
+			return
+		}
+		if e.Type == datastruct.MSGTYPE_NoteAgain && selected[e.LaneID] && (printNoteNo == "" || e.PrintNoteNo == printNoteNo) {
+			list = append(list, e)
+		}
+	})
+	if err != nil {
+		g.LogError("load printer event err:", err.Error())
+	}
+	return list
+}
+
+func parseTime(s string) time.Time {
+	t, err := time.ParseInLocation(timeFormat, s, time.Local)
+	if err != nil {
+		return time.Now()
+	}
+	return t
+}
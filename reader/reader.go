@@ -0,0 +1,283 @@
+package reader
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+	"tollsys/tollmon/datastruct"
+	"tollsys/tollmon/g"
+	"tollsys/tollmon/parameters"
+	"tollsys/tollmon/traffic"
+)
+
+//车道卡机健康度
+//按统计时长滚动统计各卡类型操作失败次数及卡机初始化失败、卡机故障次数，结合同期车道流量计算健康度(0-100)：
+//卡类型健康度 = 流量 / (流量 + 失败次数) * 100；车道健康度 = 各卡类型健康度最小值 - 卡机故障次数 * 20
+//车道健康度写入LaneInfo的readerHealth项，变化时以心跳消息推送；统计时长内已无失败记录的车道不再保留
+
+const (
+	KEY_Health = "readerHealth"
+
+	CardTypeReader = -1 //卡机初始化失败、卡机故障不区分卡类型
+	faultPenalty   = 20
+
+	timeFormat = "2006-01-02 15:04:05"
+)
+
+//CardHealth 卡类型健康度
+type CardHealth struct {
+	CardType int     `json:"cardType"`
+	Failures int     `json:"failures"`
+	FailRate float64 `json:"failRate"`
+	Score    int     `json:"score"`
+}
+
+//LaneHealth 车道卡机健康度
+//Traffic 为统计时长内车道出入口流量；Faults 为卡机初始化失败、卡机故障次数
+type LaneHealth struct {
+	LaneID    string       `json:"laneID"`
+	LaneName  string       `json:"laneName"`
+	Traffic   int          `json:"traffic"`
+	Faults    int          `json:"faults"`
+	Score     int          `json:"score"`
+	CardTypes []CardHealth `json:"cardTypes"`
+}
+
+type lane struct {
+	failures  map[int][]time.Time
+	faults    []time.Time
+	alertTime map[int]time.Time
+	health    LaneHealth
+}
+
+var (
+	lock  = &sync.Mutex{}
+	lanes = make(map[string]*lane)
+)
+
+//Handle 处理卡操作失败、卡机初始化失败、卡机故障报警，返回需发布的卡机维护报警
+func Handle(msg datastruct.MsgSend) []datastruct.MsgSend {
+	t, err := time.ParseInLocation(timeFormat, msg.MsgTime, time.Local)
+	if err != nil {
+		t = time.Now()
+	}
+	//流量统计需读取磁盘，不在lock内查询
+	volume := windowTraffic(msg.MsgLane, t)
+	lock.Lock()
+	defer lock.Unlock()
+	l := getLane(msg.MsgLane)
+	cardType := CardTypeReader
+	if msg.MsgType == datastruct.MSGTYPE_OpeCardFail {
+		cardType = msg.GetInt("CardType")
+		l.failures[cardType] = append(l.failures[cardType], t)
+	} else {
+		l.faults = append(l.faults, t)
+	}
+	list := make([]datastruct.MsgSend, 0)
+	if evaluate(msg.MsgLane, l, t, volume) {
+		list = append(list, healthMsg(msg.MsgLane, l.health.Score, msg.MsgTime))
+	}
+	if !exceeded(l, cardType) {
+		return list
+	}
+	window, _, _, _ := settings()
+	if t.Sub(l.alertTime[cardType]) < window {
+		return list
+	}
+	l.alertTime[cardType] = t
+	return append(list, maintainMsg(msg, l, cardType))
+}
+
+//getLane 调用方需持有lock
+func getLane(laneID string) *lane {
+	l, ok := lanes[laneID]
+	if !ok {
+		l = &lane{failures: make(map[int][]time.Time), alertTime: make(map[int]time.Time)}
+		l.health.LaneID = laneID
+		l.health.Score = 100
+		if node, ok := parameters.GetNodeByID(laneID); ok {
+			l.health.LaneName = node.NodeName
+		}
+		lanes[laneID] = l
+	}
+	return l
+}
+
+func settings() (time.Duration, int, float64, int) {
+	window, minFailures, maxRate, maxFaults := 60, 3, 5.0, 2
+	if c := g.Config().Reader; c != nil {
+		if c.WindowMinutes > 0 {
+			window = c.WindowMinutes
+		}
+		if c.MinFailures > 0 {
+			minFailures = c.MinFailures
+		}
+		if c.MaxFailRate > 0 {
+			maxRate = c.MaxFailRate
+		}
+		if c.MaxFaults > 0 {
+			maxFaults = c.MaxFaults
+		}
+	}
+	return time.Duration(window) * time.Minute, minFailures, maxRate, maxFaults
+}
+
+//windowTraffic 统计车道最近统计时长内的出入口流量，调用方不可持有lock
+func windowTraffic(laneID string, now time.Time) int {
+	window, _, _, _ := settings()
+	sum := traffic.Sum(laneID, now.Add(-window), now)
+	return sum.Entry + sum.Exit
+}
+
+//evaluate 移除统计时长外的记录，以统计时长内的流量volume重新计算车道健康度并更新LaneInfo，返回健康度是否变化
+//调用方需持有lock
+func evaluate(laneID string, l *lane, now time.Time, volume int) bool {
+	window, _, _, _ := settings()
+	start := now.Add(-window)
+	h := &l.health
+	score := h.Score
+	h.Traffic = volume
+	l.faults = expire(l.faults, start)
+	h.Faults = len(l.faults)
+	h.CardTypes = make([]CardHealth, 0, len(l.failures))
+	h.Score = 100
+	for cardType, list := range l.failures {
+		list = expire(list, start)
+		if len(list) == 0 {
+			delete(l.failures, cardType)
+			continue
+		}
+		l.failures[cardType] = list
+		c := CardHealth{CardType: cardType, Failures: len(list), FailRate: rate(len(list), h.Traffic)}
+		c.Score = h.Traffic * 100 / (h.Traffic + len(list))
+		if c.Score < h.Score {
+			h.Score = c.Score
+		}
+		h.CardTypes = append(h.CardTypes, c)
+	}
+	sort.Slice(h.CardTypes, func(i, j int) bool {
+		return h.CardTypes[i].CardType < h.CardTypes[j].CardType
+	})
+	h.Score -= h.Faults * faultPenalty
+	if h.Score < 0 {
+		h.Score = 0
+	}
+	parameters.UpdateLaneInfo(laneID, KEY_Health, h.Score)
+	return h.Score != score
+}
+
+func expire(list []time.Time, start time.Time) []time.Time {
+	i := 0
+	for i < len(list) && list[i].Before(start) {
+		i++
+	}
+	return list[i:]
+}
+
+func rate(failures int, volume int) float64 {
+	if volume == 0 {
+		if failures == 0 {
+			return 0
+		}
+		return 100
+	}
+	return float64(failures) * 100 / float64(volume)
+}
+
+//exceeded 判断卡类型失败率或卡机故障次数是否超过报警阈值
+//调用方需持有lock
+func exceeded(l *lane, cardType int) bool {
+	_, minFailures, maxRate, maxFaults := settings()
+	if cardType == CardTypeReader {
+		return l.health.Faults >= maxFaults
+	}
+	for _, c := range l.health.CardTypes {
+		if c.CardType == cardType {
+			return c.Failures >= minFailures && c.FailRate > maxRate
+		}
+	}
+	return false
+}
+
+func maintainMsg(src datastruct.MsgSend, l *lane, cardType int) datastruct.MsgSend {
+	msg := datastruct.NewMsgSend()
+	msg.MsgCatalog = datastruct.MSGCATALOG_Alert
+	msg.MsgType = datastruct.MSGTYPE_ReaderMaintain
+	msg.MsgTime = src.MsgTime
+	msg.MsgLane = src.MsgLane
+	msg.MsgContent["Shift"] = src.GetInt("Shift")
+	msg.MsgContent["EmpID"] = src.GetInt("EmpID")
+	msg.MsgContent["CardType"] = cardType
+	msg.MsgContent["Traffic"] = l.health.Traffic
+	msg.MsgContent["Faults"] = l.health.Faults
+	msg.MsgContent["Score"] = l.health.Score
+	for _, c := range l.health.CardTypes {
+		if c.CardType == cardType {
+			msg.MsgContent["Failures"] = c.Failures
+			msg.MsgContent["Rate"] = strconv.FormatFloat(c.FailRate, 'f', 1, 64)
+		}
+	}
+	g.LogDebug("卡机维护报警-[LaneID:", src.MsgLane, " cardType:", cardType, " score:", l.health.Score, "]")
+	return msg
+}
+
+//healthMsg 车道健康度变化的心跳消息
+func healthMsg(laneID string, score int, mTime string) datastruct.MsgSend {
+	msg := datastruct.NewMsgSend()
+	msg.MsgCatalog = datastruct.MSGCATALOG_Test
+	msg.MsgType = datastruct.MSGTYPE_Heart
+	msg.MsgTime = mTime
+	msg.MsgLane = laneID
+	msg.MsgContent[KEY_Health] = score
+	return msg
+}
+
+//Refresh 按当前时间重新计算各车道健康度，使统计时长外的失败记录不再影响健康度，返回健康度变化的心跳消息
+//统计时长内已无失败记录的车道健康度恢复为100后删除
+func Refresh(now time.Time) []datastruct.MsgSend {
+	lock.Lock()
+	ids := make([]string, 0, len(lanes))
+	for laneID := range lanes {
+		ids = append(ids, laneID)
+	}
+	lock.Unlock()
+	volumes := make(map[string]int, len(ids))
+	for _, laneID := range ids {
+		volumes[laneID] = windowTraffic(laneID, now)
+	}
+	list := make([]datastruct.MsgSend, 0)
+	lock.Lock()
+	defer lock.Unlock()
+	for _, laneID := range ids {
+		l, ok := lanes[laneID]
+		if !ok {
+			continue
+		}
+		if evaluate(laneID, l, now, volumes[laneID]) {
+			list = append(list, healthMsg(laneID, l.health.Score, now.Format(timeFormat)))
+		}
+		if len(l.failures) == 0 && len(l.faults) == 0 {
+			delete(lanes, laneID)
+		}
+	}
+	return list
+}
+
+//GetHealth 查询所选车道的卡机健康度，无失败记录的车道健康度为100
+func GetHealth(laneList []datastruct.Node) []LaneHealth {
+	list := make([]LaneHealth, 0, len(laneList))
+	lock.Lock()
+	defer lock.Unlock()
+	for _, node := range laneList {
+		l, ok := lanes[node.NodeID]
+		if !ok {
+			list = append(list, LaneHealth{LaneID: node.NodeID, LaneName: node.NodeName, Score: 100, CardTypes: make([]CardHealth, 0)})
+			continue
+		}
+		h := l.health
+		h.CardTypes = append(make([]CardHealth, 0, len(h.CardTypes)), h.CardTypes...)
+		list = append(list, h)
+	}
+	return list
+}
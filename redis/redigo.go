@@ -148,3 +148,65 @@ func HGetALL(key string)[]interface{}{
 	}
 	return b
 }
+
+//Publish Redis 发布消息至频道
+//要求字段channel：string 频道；message：[]byte 消息内容
+func Publish(channel string, message []byte) error {
+	c := pool.Get()
+	defer c.Close()
+	_, err := c.Do("PUBLISH", channel, message)
+	return err
+}
+
+//PSubscribe Redis 按模式订阅频道，收到消息时以handler处理
+//使用独立连接，定时ping检测连接，连接断开后间隔重连，不返回
+func PSubscribe(pattern string, handler func(channel string, data []byte)) {
+	for {
+		err := psubscribe(pattern, handler)
+		g.LogError("redis psubscribe ", pattern, " err:", err.Error())
+		time.Sleep(pubSubRetry)
+	}
+}
+
+const (
+	pubSubPing  = time.Minute
+	pubSubRetry = 5 * time.Second
+)
+
+func psubscribe(pattern string, handler func(channel string, data []byte)) error {
+	c, err := redis.Dial(g.Config().Redis.ConnectType, g.Config().Redis.Host, redis.DialReadTimeout(2*pubSubPing))
+	if err != nil {
+		return err
+	}
+	psc := redis.PubSubConn{Conn: c}
+	defer psc.Close()
+	if err := psc.PSubscribe(pattern); err != nil {
+		return err
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(pubSubPing)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := psc.Ping(""); err != nil {
+					return
+				}
+			}
+		}
+	}()
+	for {
+		switch v := psc.Receive().(type) {
+		case redis.Message:
+			handler(v.Channel, v.Data)
+		case redis.Subscription:
+			g.LogInfo("redis ", v.Kind, " ", v.Channel, " count:", v.Count)
+		case error:
+			return v
+		}
+	}
+}
@@ -0,0 +1,331 @@
+package revenue
+
+import (
+	"strconv"
+	"sync"
+	"time"
+	"tollsys/tollmon/datastruct"
+	"tollsys/tollmon/g"
+	"tollsys/tollmon/parameters"
+	"tollsys/tollmon/store"
+)
+
+//车道实时收入
+//按出口记录的时间累计车道当班、当日收入，变化后由定时检查按收入日期写入本地存储快照 {store.path}/revenue/20060102.json，
+//重启时以最近的快照恢复当班、当日收入；定时检查在日期变更时清零当日收入
+
+const (
+	KIND = "revenue"
+
+	KEY_Shift = "revenue.shift" //当班收入，核心数据项
+	KEY_Day   = "revenue.day"   //当日收入，核心数据项
+
+	KEY_ExitRecord = "SendStatus.ExitRecord" //车道上报的出口记录数
+
+	dateFormat = "2006-01-02"
+	timeFormat = "2006-01-02 15:04:05"
+)
+
+//Totals 收入合计：出口车辆数、通行费、借款、罚款
+type Totals struct {
+	Count   int `json:"count"`
+	Pass    int `json:"pass"`
+	Loan    int `json:"loan"`
+	Forfeit int `json:"forfeit"`
+}
+
+func (t *Totals) add(o Totals) {
+	t.Count += o.Count
+	t.Pass += o.Pass
+	t.Loan += o.Loan
+	t.Forfeit += o.Forfeit
+}
+
+//LaneTotals 车道当班、当日收入
+type LaneTotals struct {
+	LaneID   string `json:"laneID"`
+	LaneName string `json:"laneName"`
+	ShiftNo  int    `json:"shiftNo"`
+	Date     string `json:"date"`
+	Shift    Totals `json:"shift"`
+	Day      Totals `json:"day"`
+}
+
+//GroupTotals 广场、收费站收入汇总
+type GroupTotals struct {
+	NodeID   string `json:"nodeID"`
+	NodeName string `json:"nodeName"`
+	Shift    Totals `json:"shift"`
+	Day      Totals `json:"day"`
+}
+
+//Summary 收入查询结果
+type Summary struct {
+	Lanes    []LaneTotals  `json:"lanes"`
+	Plazas   []GroupTotals `json:"plazas"`
+	Stations []GroupTotals `json:"stations"`
+}
+
+//snapshot 车道收入快照
+type snapshot struct {
+	Time string `json:"time"`
+	LaneTotals
+}
+
+var (
+	lock  = &sync.Mutex{}
+	lanes = make(map[string]*LaneTotals)
+	dirty = make(map[string]bool) //收入变化后未写入快照的车道
+)
+
+//InitRevenue 以本地存储中前一日及当日各车道最近的快照恢复车道当班、当日收入
+//跨日的班次恢复当班收入，当日收入仅恢复当日的快照
+func InitRevenue() {
+	now := time.Now()
+	today := now.Format(dateFormat)
+	latest := make(map[string]snapshot)
+	err := store.Load(KIND, now.AddDate(0, 0, -1), now, func(b []byte) {
+		s := snapshot{}
+		if err := g.Json.Unmarshal(b, &s); err != nil {
+			g.LogError("parse revenue snapshot err:", err.Error())
+			return
+		}
+		if s.Time >= latest[s.LaneID].Time {
+			latest[s.LaneID] = s
+		}
+	})
+	if err != nil {
+		g.LogError("load revenue snapshot err:", err.Error())
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	for laneID, s := range latest {
+		l := getLane(laneID, today)
+		l.ShiftNo = s.ShiftNo
+		l.Shift = s.Shift
+		if s.Date == today {
+			l.Day = s.Day
+		}
+		parameters.UpdateCoreInfo(l.LaneID, KEY_Shift, l.Shift)
+		parameters.UpdateCoreInfo(l.LaneID, KEY_Day, l.Day)
+	}
+}
+
+//GetDay 以本地存储中day当日各车道最近的快照获取车道当日收入，按车道编号返回
+//当日收入按出口时间累计，跨日的班次分别计入各自日期
+func GetDay(day time.Time) map[string]Totals {
+	date := day.Format(dateFormat)
+	latest := make(map[string]snapshot)
+	err := store.Load(KIND, day, day, func(b []byte) {
+		s := snapshot{}
+		if err := g.Json.Unmarshal(b, &s); err != nil {
+			g.LogError("parse revenue snapshot err:", err.Error())
+			return
+		}
+		if s.Date == date && s.Time >= latest[s.LaneID].Time {
+			latest[s.LaneID] = s
+		}
+	})
+	if err != nil {
+		g.LogError("load revenue snapshot err:", err.Error())
+	}
+	m := make(map[string]Totals)
+	for laneID, s := range latest {
+		m[laneID] = s.Day
+	}
+	return m
+}
+
+//getLane 获取车道收入，日期变更时清零当日收入
+//调用方需持有lock
+func getLane(laneID string, date string) *LaneTotals {
+	l, ok := lanes[laneID]
+	if !ok {
+		l = &LaneTotals{LaneID: laneID, Date: date}
+		if node, ok := parameters.GetNodeByID(laneID); ok {
+			l.LaneName = node.NodeName
+		}
+		lanes[laneID] = l
+	}
+	if date > l.Date {
+		l.Date = date
+		l.Day = Totals{}
+	}
+	return l
+}
+
+func msgDate(msg datastruct.MsgSend) string {
+	if len(msg.MsgTime) >= len(dateFormat) {
+		return msg.MsgTime[0:len(dateFormat)]
+	}
+	return time.Now().Format(dateFormat)
+}
+
+//OnDuty 上班时清零车道当班收入，返回需推送的核心数据消息
+func OnDuty(msg datastruct.MsgSend) []datastruct.MsgSend {
+	lock.Lock()
+	defer lock.Unlock()
+	l := getLane(msg.MsgLane, msgDate(msg))
+	l.ShiftNo = msg.GetInt("Shift")
+	l.Shift = Totals{}
+	dirty[l.LaneID] = true
+	return coreDataMsg(msg.MsgTime, l)
+}
+
+//Exit 累计出口记录的通行费、借款、罚款，返回需推送的核心数据消息
+func Exit(msg datastruct.MsgSend) []datastruct.MsgSend {
+	lock.Lock()
+	defer lock.Unlock()
+	l := getLane(msg.MsgLane, msgDate(msg))
+	t := Totals{Count: 1, Pass: msg.GetInt("Pass"), Loan: msg.GetInt("Loan"), Forfeit: msg.GetInt("Forfeit")}
+	l.ShiftNo = msg.GetInt("Shift")
+	l.Shift.add(t)
+	l.Day.add(t)
+	dirty[l.LaneID] = true
+	return coreDataMsg(msg.MsgTime, l)
+}
+
+//Check 将收入变化的车道写入快照，日期变更时清零各车道当日收入，返回需推送的核心数据消息
+//清零前先写入前一日的快照，清零后的快照在下次检查时写入
+func Check(now time.Time) []datastruct.MsgSend {
+	today := now.Format(dateFormat)
+	mTime := now.Format(timeFormat)
+	list := make([]datastruct.MsgSend, 0)
+	snapshots := make([]snapshot, 0)
+	lock.Lock()
+	for laneID := range dirty {
+		if l, ok := lanes[laneID]; ok {
+			snapshots = append(snapshots, snapshot{Time: mTime, LaneTotals: *l})
+		}
+	}
+	dirty = make(map[string]bool)
+	for _, l := range lanes {
+		if today > l.Date {
+			getLane(l.LaneID, today)
+			dirty[l.LaneID] = true
+			list = append(list, coreDataMsg(mTime, l)...)
+		}
+	}
+	lock.Unlock()
+	for _, s := range snapshots {
+		day, err := time.ParseInLocation(dateFormat, s.Date, time.Local)
+		if err != nil {
+			day = now
+		}
+		if err := store.Append(KIND, day, s); err != nil {
+			g.LogError("save revenue snapshot err:", s.LaneID, err.Error())
+		}
+	}
+	return list
+}
+
+//coreDataMsg 更新车道核心数据中的收入项，并生成核心数据消息，由monitor按车道合并后周期推送
+//消息类别取自coredata.list配置，未配置的项不推送
+func coreDataMsg(mTime string, l *LaneTotals) []datastruct.MsgSend {
+	list := make([]datastruct.MsgSend, 0)
+	values := map[string]Totals{KEY_Shift: l.Shift, KEY_Day: l.Day}
+	for key, val := range values {
+		parameters.UpdateCoreInfo(l.LaneID, key, val)
+		msgType, ok := g.Config().CoreData.List[key]
+		if !ok {
+			continue
+		}
+		msg := datastruct.NewMsgSend()
+		msg.MsgCatalog = datastruct.MSGCATALOG_CoreData
+		msg.MsgType = msgType
+		msg.MsgTime = mTime
+		msg.MsgLane = l.LaneID
+		msg.MsgContent[key] = val
+		list = append(list, msg)
+	}
+	return list
+}
+
+//GetLaneTotals 获取车道当班、当日收入
+func GetLaneTotals(laneID string) LaneTotals {
+	lock.Lock()
+	defer lock.Unlock()
+	l := getLane(laneID, time.Now().Format(dateFormat))
+	return *l
+}
+
+//GetSummary 汇总所选车道的收入，并按广场、收费站合计
+func GetSummary(laneList []datastruct.Node) Summary {
+	s := Summary{Lanes: make([]LaneTotals, 0), Plazas: make([]GroupTotals, 0), Stations: make([]GroupTotals, 0)}
+	selected := make(map[string]LaneTotals)
+	for _, lane := range laneList {
+		l := GetLaneTotals(lane.NodeID)
+		selected[lane.NodeID] = l
+		s.Lanes = append(s.Lanes, l)
+	}
+	for _, station := range parameters.GetStationTrees() {
+		st := GroupTotals{NodeID: station.Station.NodeID, NodeName: station.Station.NodeName}
+		found := false
+		for _, plaza := range station.Plazas {
+			pt := GroupTotals{NodeID: plaza.Plaza.NodeID, NodeName: plaza.Plaza.NodeName}
+			plazaFound := false
+			for _, lane := range plaza.Lanes {
+				if l, ok := selected[lane.NodeID]; ok {
+					pt.Shift.add(l.Shift)
+					pt.Day.add(l.Day)
+					plazaFound = true
+				}
+			}
+			if plazaFound {
+				st.Shift.add(pt.Shift)
+				st.Day.add(pt.Day)
+				s.Plazas = append(s.Plazas, pt)
+				found = true
+			}
+		}
+		if found {
+			s.Stations = append(s.Stations, st)
+		}
+	}
+	return s
+}
+
+//ReconcileItem 车道出口记录核对结果
+//ExitCount 为本服务统计的当日出口记录数；Reported 为车道通过/push上报的SendStatus.ExitRecord
+type ReconcileItem struct {
+	LaneID    string      `json:"laneID"`
+	LaneName  string      `json:"laneName"`
+	ExitCount int         `json:"exitCount"`
+	Reported  interface{} `json:"reported"`
+	Diff      *float64    `json:"diff"`
+	Matched   bool        `json:"matched"`
+}
+
+//Reconcile 核对所选车道当日出口记录数与车道上报的出口记录数
+func Reconcile(laneList []datastruct.Node) []ReconcileItem {
+	list := make([]ReconcileItem, 0)
+	for _, lane := range laneList {
+		l := GetLaneTotals(lane.NodeID)
+		item := ReconcileItem{LaneID: lane.NodeID, LaneName: lane.NodeName, ExitCount: l.Day.Count}
+		if v, ok := parameters.GetCoreInfoById(lane.NodeID).GetData(KEY_ExitRecord); ok {
+			item.Reported = v
+			if f, ok := toFloat(v); ok {
+				diff := f - float64(l.Day.Count)
+				item.Diff = &diff
+				item.Matched = diff == 0
+			}
+		}
+		list = append(list, item)
+	}
+	return list
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch t := v.(type) {
+	case float64:
+		return t, true
+	case int:
+		return float64(t), true
+	case int64:
+		return float64(t), true
+	case string:
+		f, err := strconv.ParseFloat(t, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
@@ -0,0 +1,330 @@
+package shift
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+	"tollsys/tollmon/datastruct"
+	"tollsys/tollmon/g"
+	"tollsys/tollmon/parameters"
+	"tollsys/tollmon/store"
+)
+
+const (
+	KIND       = "shift"
+	timeFormat = "2006-01-02 15:04:05"
+)
+
+//Record 班次记录
+//上班时创建，班次期间累计出入口流量、通行费及报警，下班时关闭并写入本地存储
+//Inferred 为未收到上班消息、根据车道记录或报警中的工号推断的班次
+type Record struct {
+	ID          string      `json:"id"`
+	LaneID      string      `json:"laneID"`
+	LaneName    string      `json:"laneName"`
+	Shift       int         `json:"shift"`
+	EmpID       int         `json:"empID"`
+	EmpName     string      `json:"empName"`
+	OnDutyTime  string      `json:"onDutyTime"`
+	OffDutyTime string      `json:"offDutyTime"`
+	Open        bool        `json:"open"`
+	EntryCount  int         `json:"entryCount"`
+	ExitCount   int         `json:"exitCount"`
+	Pass        int         `json:"pass"`
+	Loan        int         `json:"loan"`
+	Forfeit     int         `json:"forfeit"`
+	AlertCount  int         `json:"alertCount"`
+	Alerts      map[int]int `json:"alerts"`
+	Inferred    bool        `json:"inferred"`
+}
+
+//Report 班次汇总报表，按车道或按员工汇总
+type Report struct {
+	Key         string      `json:"key"`
+	Name        string      `json:"name"`
+	Shifts      int         `json:"shifts"`
+	DutySeconds int64       `json:"dutySeconds"`
+	EntryCount  int         `json:"entryCount"`
+	ExitCount   int         `json:"exitCount"`
+	Pass        int         `json:"pass"`
+	Loan        int         `json:"loan"`
+	Forfeit     int         `json:"forfeit"`
+	AlertCount  int         `json:"alertCount"`
+	Alerts      map[int]int `json:"alerts"`
+}
+
+//Query 班次查询条件，字段为空值时不过滤
+type Query struct {
+	StationID string
+	LaneID    string
+	EmpID     int
+	From      time.Time
+	To        time.Time
+}
+
+var (
+	lock     = &sync.Mutex{}
+	openList = make(map[string]*Record) //车道 -> 当前班次
+)
+
+func newRecord(msg datastruct.MsgSend, empName string) *Record {
+	if empName == "" {
+		empName = parameters.GetEmployeeName(msg.GetInt("EmpID"))
+	}
+	r := &Record{
+		ID:         msg.MsgLane + "-" + parseTime(msg.MsgTime).Format("20060102150405"),
+		LaneID:     msg.MsgLane,
+		Shift:      msg.GetInt("Shift"),
+		EmpID:      msg.GetInt("EmpID"),
+		EmpName:    empName,
+		OnDutyTime: msg.MsgTime,
+		Open:       true,
+		Alerts:     make(map[int]int),
+	}
+	if node, ok := parameters.GetNodeByID(msg.MsgLane); ok {
+		r.LaneName = node.NodeName
+	}
+	return r
+}
+
+//OnDuty 上班，关闭该车道未关闭的班次并创建新班次
+func OnDuty(msg datastruct.MsgSend) {
+	lock.Lock()
+	defer lock.Unlock()
+	if r, ok := openList[msg.MsgLane]; ok {
+		closeRecord(r, msg.MsgTime)
+	}
+	openList[msg.MsgLane] = newRecord(msg, msg.GetString("EmpName"))
+}
+
+//OffDuty 下班，关闭该车道当前班次
+func OffDuty(msg datastruct.MsgSend) {
+	lock.Lock()
+	defer lock.Unlock()
+	r, ok := openList[msg.MsgLane]
+	if !ok {
+		g.LogDebug("offduty without open shift:", msg.MsgLane)
+		return
+	}
+	offDutyTime := msg.GetString("OffDutyTime")
+	if offDutyTime == "" {
+		offDutyTime = msg.MsgTime
+	}
+	closeRecord(r, offDutyTime)
+}
+
+//Entry 累计入口流量
+func Entry(msg datastruct.MsgSend) {
+	lock.Lock()
+	defer lock.Unlock()
+	current(msg).EntryCount++
+}
+
+//Exit 累计出口流量及通行费、借款、罚款金额
+func Exit(msg datastruct.MsgSend) {
+	lock.Lock()
+	defer lock.Unlock()
+	r := current(msg)
+	r.ExitCount++
+	r.Pass += msg.GetInt("Pass")
+	r.Loan += msg.GetInt("Loan")
+	r.Forfeit += msg.GetInt("Forfeit")
+}
+
+//Alert 累计报警次数
+func Alert(msg datastruct.MsgSend) {
+	lock.Lock()
+	defer lock.Unlock()
+	r := current(msg)
+	r.AlertCount++
+	r.Alerts[msg.MsgType]++
+}
+
+//current 获取车道当前班次
+//未收到上班消息时(如服务重启)以当前消息的班次、工号补建班次；
+//消息中的工号与当前班次不一致时(如漏收上下班消息)，关闭当前班次并补建班次
+func current(msg datastruct.MsgSend) *Record {
+	empID := msg.GetInt("EmpID")
+	if r, ok := openList[msg.MsgLane]; ok {
+		if empID == 0 || r.EmpID == empID {
+			return r
+		}
+		closeRecord(r, msg.MsgTime)
+	}
+	empName := ""
+	if info := parameters.GetLaneInfoByID(msg.MsgLane); info.Info != nil && info.Info["empID"] == empID {
+		empName, _ = info.Info["empName"].(string)
+	}
+	r := newRecord(msg, empName)
+	r.Inferred = true
+	openList[msg.MsgLane] = r
+	return r
+}
+
+//closeRecord 关闭班次并写入本地存储，以上班日期归档
+func closeRecord(r *Record, offDutyTime string) {
+	r.OffDutyTime = offDutyTime
+	r.Open = false
+	delete(openList, r.LaneID)
+	if err := store.Append(KIND, parseTime(r.OnDutyTime), r); err != nil {
+		g.LogError("save shift err:", r.ID, err.Error())
+	}
+	g.LogDebug("班次结束-", r.ID, " entry:", r.EntryCount, " exit:", r.ExitCount)
+}
+
+//GetShifts 查询班次记录，包含已归档班次及当前未关闭班次，按上班时间排序
+func GetShifts(q Query) []Record {
+	list := make([]Record, 0)
+	err := store.Load(KIND, q.From, q.To, func(b []byte) {
+		r := Record{}
+		if err := g.Json.Unmarshal(b, &r); err != nil {
+			g.LogError("parse shift err:", err.Error())
+			return
+		}
+		if q.match(&r) {
+			list = append(list, r)
+		}
+	})
+	if err != nil {
+		g.LogError("load shift err:", err.Error())
+	}
+	lock.Lock()
+	for _, r := range openList {
+		if q.match(r) {
+			c := *r
+			c.Alerts = make(map[int]int)
+			for k, v := range r.Alerts {
+				c.Alerts[k] = v
+			}
+			list = append(list, c)
+		}
+	}
+	lock.Unlock()
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].OnDutyTime < list[j].OnDutyTime
+	})
+	return list
+}
+
+func (q Query) match(r *Record) bool {
+	if q.StationID != "" && (len(r.LaneID) < 16 || r.LaneID[0:16] != q.StationID[0:16]) {
+		return false
+	}
+	if q.LaneID != "" && r.LaneID != q.LaneID {
+		return false
+	}
+	if q.EmpID != 0 && r.EmpID != q.EmpID {
+		return false
+	}
+	t := parseTime(r.OnDutyTime)
+	return !t.Before(store.Day(q.From)) && t.Before(store.Day(q.To).AddDate(0, 0, 1))
+}
+
+//Activity 员工上岗记录
+type Activity struct {
+	EmpID   int      `json:"empID"`
+	EmpName string   `json:"empName"`
+	Shifts  []Record `json:"shifts"`
+	Lanes   []Report `json:"lanes"`
+}
+
+//GetActivity 查询员工[from,to]日期范围内的班次及按车道汇总
+func GetActivity(empID int, from time.Time, to time.Time) Activity {
+	list := GetShifts(Query{EmpID: empID, From: from, To: to})
+	a := Activity{EmpID: empID, EmpName: parameters.GetEmployeeName(empID), Shifts: list}
+	for _, r := range list {
+		if r.EmpName != "" {
+			a.EmpName = r.EmpName
+		}
+	}
+	a.Lanes = summarize(list, func(r *Record) (string, string) {
+		return r.LaneID, r.LaneName
+	})
+	return a
+}
+
+//GetStaffing 查询车道在at时刻的在岗班次，按上班时间查询at前一日至当日的班次
+func GetStaffing(laneID string, at time.Time) []Record {
+	list := make([]Record, 0)
+	for _, r := range GetShifts(Query{LaneID: laneID, From: at.AddDate(0, 0, -1), To: at}) {
+		if parseTime(r.OnDutyTime).After(at) {
+			continue
+		}
+		if !r.Open && parseTime(r.OffDutyTime).Before(at) {
+			continue
+		}
+		list = append(list, r)
+	}
+	return list
+}
+
+//GetLaneReports 按车道汇总班次
+func GetLaneReports(q Query) []Report {
+	return summarize(GetShifts(q), func(r *Record) (string, string) {
+		return r.LaneID, r.LaneName
+	})
+}
+
+//GetEmployeeReports 按员工汇总班次
+func GetEmployeeReports(q Query) []Report {
+	return summarize(GetShifts(q), func(r *Record) (string, string) {
+		return strconv.Itoa(r.EmpID), r.EmpName
+	})
+}
+
+func summarize(list []Record, key func(r *Record) (string, string)) []Report {
+	reports := make(map[string]*Report)
+	keys := make([]string, 0)
+	for i := range list {
+		r := &list[i]
+		k, name := key(r)
+		rep, ok := reports[k]
+		if !ok {
+			rep = &Report{Key: k, Alerts: make(map[int]int)}
+			reports[k] = rep
+			keys = append(keys, k)
+		}
+		if name != "" {
+			rep.Name = name
+		}
+		rep.Shifts++
+		rep.DutySeconds += dutySeconds(r)
+		rep.EntryCount += r.EntryCount
+		rep.ExitCount += r.ExitCount
+		rep.Pass += r.Pass
+		rep.Loan += r.Loan
+		rep.Forfeit += r.Forfeit
+		rep.AlertCount += r.AlertCount
+		for t, n := range r.Alerts {
+			rep.Alerts[t] += n
+		}
+	}
+	sort.Strings(keys)
+	result := make([]Report, 0, len(keys))
+	for _, k := range keys {
+		result = append(result, *reports[k])
+	}
+	return result
+}
+
+//dutySeconds 班次时长，未关闭班次计算至当前时间
+func dutySeconds(r *Record) int64 {
+	end := time.Now()
+	if !r.Open {
+		end = parseTime(r.OffDutyTime)
+	}
+	d := int64(end.Sub(parseTime(r.OnDutyTime)).Seconds())
+	if d < 0 {
+		return 0
+	}
+	return d
+}
+
+func parseTime(s string) time.Time {
+	t, err := time.ParseInLocation(timeFormat, s, time.Local)
+	if err != nil {
+		return time.Now()
+	}
+	return t
+}
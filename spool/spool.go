@@ -0,0 +1,545 @@
+package spool
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"tollsys/tollmon/g"
+	"tollsys/tollmon/store"
+)
+
+//外发数据磁盘缓冲队列
+//上级转发、通知等外发通道的数据先写入各自的队列，由投递goroutine按顺序读取、投递，投递成功后确认；
+//链路中断期间数据保留在磁盘，恢复后按原顺序补发。
+//目录结构：{store.path}/spool/{name}/0000000000000001.json，每个分段文件每行一条记录，写入后fsync；
+//分段超过segmentSize时切换新分段，积压超过maxSize时丢弃最旧的分段；
+//读取位置保存在cursor文件中，至多每秒保存一次，进程异常退出后可能重复投递少量记录；
+//投递返回Permanent错误或连续失败maxAttempts次的记录写入死信文件 dead.json 后跳过，不阻塞后续记录
+
+const (
+	KIND = "spool"
+
+	defaultSegmentSize = 4
+	defaultMaxSize     = 256
+	defaultRetry       = 5
+	defaultMaxAttempts = 60
+
+	cursorFile = "cursor"
+	deadFile   = "dead.json"
+	segmentExt = ".json"
+	saveWait   = time.Second
+)
+
+//Stats 队列积压统计
+//Segments 为分段文件数；Bytes、Records 为未投递的字节数及记录数；Pushed、Delivered、Dropped 为启动以来写入、投递及因超过maxSize丢弃的记录数；
+//Dead 为启动以来投递失败转入死信文件的记录数
+type Stats struct {
+	Name      string `json:"name"`
+	Segments  int    `json:"segments"`
+	Bytes     int64  `json:"bytes"`
+	Records   int64  `json:"records"`
+	Pushed    int64  `json:"pushed"`
+	Delivered int64  `json:"delivered"`
+	Dropped   int64  `json:"dropped"`
+	Dead      int64  `json:"dead"`
+}
+
+//deadRecord 死信文件中的记录，Record 为原始记录
+type deadRecord struct {
+	Time     string `json:"time"`
+	Attempts int    `json:"attempts"`
+	Error    string `json:"error"`
+	Record   string `json:"record"`
+}
+
+//permanentError 不可重试的投递错误
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+//Permanent 标记投递错误不可重试(如收件人被拒绝)，Run 不再重试该记录而直接写入死信文件
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+//IsPermanent 判断投递错误是否不可重试
+func IsPermanent(err error) bool {
+	_, ok := err.(*permanentError)
+	return ok
+}
+
+//Queue 磁盘缓冲队列，可并发写入，仅允许一个投递goroutine读取
+type Queue struct {
+	lock       *sync.Mutex
+	name       string
+	dir        string
+	ready      chan struct{}
+	segments   []int64
+	writer     *os.File
+	writeSize  int64
+	reader     *os.File
+	buffered   *bufio.Reader
+	readOffset int64
+	next       []byte
+	size       int64
+	saved      time.Time
+	stats      Stats
+}
+
+var (
+	lock   = &sync.Mutex{}
+	queues = make(map[string]*Queue)
+)
+
+func segmentSize() int64 {
+	size := defaultSegmentSize
+	if g.Config().Spool != nil && g.Config().Spool.SegmentSize > 0 {
+		size = g.Config().Spool.SegmentSize
+	}
+	return int64(size) << 20
+}
+
+func maxSize() int64 {
+	size := defaultMaxSize
+	if g.Config().Spool != nil && g.Config().Spool.MaxSize > 0 {
+		size = g.Config().Spool.MaxSize
+	}
+	return int64(size) << 20
+}
+
+//Retry 投递失败后的重试间隔
+func Retry() time.Duration {
+	retry := defaultRetry
+	if g.Config().Spool != nil && g.Config().Spool.Retry > 0 {
+		retry = g.Config().Spool.Retry
+	}
+	return time.Duration(retry) * time.Second
+}
+
+//maxAttempts 单条记录的最大投递次数
+func maxAttempts() int {
+	if g.Config().Spool != nil && g.Config().Spool.MaxAttempts > 0 {
+		return g.Config().Spool.MaxAttempts
+	}
+	return defaultMaxAttempts
+}
+
+//Open 打开名为name的队列，恢复上次未投递的记录；同名队列仅打开一次
+func Open(name string) (*Queue, error) {
+	lock.Lock()
+	defer lock.Unlock()
+	if q, ok := queues[name]; ok {
+		return q, nil
+	}
+	q := &Queue{
+		lock:  &sync.Mutex{},
+		name:  name,
+		dir:   filepath.Join(store.Dir(KIND), name),
+		ready: make(chan struct{}, 1),
+		stats: Stats{Name: name},
+	}
+	if err := q.load(); err != nil {
+		return nil, err
+	}
+	queues[name] = q
+	if q.stats.Records > 0 {
+		g.LogInfo("spool ", name, " backlog records:", q.stats.Records, " bytes:", q.size-q.readOffset)
+		q.signal()
+	}
+	return q, nil
+}
+
+//GetStats 获取全部队列的积压统计
+func GetStats() []Stats {
+	lock.Lock()
+	list := make([]*Queue, 0, len(queues))
+	for _, q := range queues {
+		list = append(list, q)
+	}
+	lock.Unlock()
+	stats := make([]Stats, 0, len(list))
+	for _, q := range list {
+		stats = append(stats, q.Stats())
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Name < stats[j].Name
+	})
+	return stats
+}
+
+//Stats 获取队列积压统计
+func (q *Queue) Stats() Stats {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	s := q.stats
+	s.Segments = len(q.segments)
+	s.Bytes = q.size - q.readOffset
+	return s
+}
+
+//Ready 有新记录写入时可读
+func (q *Queue) Ready() <-chan struct{} {
+	return q.ready
+}
+
+func (q *Queue) signal() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+//Push 写入一条记录并fsync，记录中不能包含换行
+func (q *Queue) Push(b []byte) error {
+	if bytes.IndexByte(b, '\n') >= 0 {
+		return errors.New("spool record contains newline")
+	}
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.writer == nil || q.writeSize >= segmentSize() {
+		if err := q.rotate(); err != nil {
+			return err
+		}
+	}
+	line := make([]byte, len(b)+1)
+	copy(line, b)
+	line[len(b)] = '\n'
+	n, err := q.writer.Write(line)
+	q.writeSize += int64(n)
+	q.size += int64(n)
+	if err != nil {
+		return err
+	}
+	if err := q.writer.Sync(); err != nil {
+		return err
+	}
+	q.stats.Records++
+	q.stats.Pushed++
+	q.limit()
+	q.signal()
+	return nil
+}
+
+//Peek 读取下一条未投递的记录，无记录时返回false；重复调用返回同一记录直至Commit
+func (q *Queue) Peek() ([]byte, bool, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.next != nil {
+		return q.next[:len(q.next)-1], true, nil
+	}
+	for len(q.segments) != 0 {
+		if q.reader == nil {
+			if err := q.openReader(); err != nil {
+				return nil, false, err
+			}
+		}
+		line, err := q.buffered.ReadBytes('\n')
+		if err == nil {
+			q.next = line
+			return line[:len(line)-1], true, nil
+		}
+		if err != io.EOF {
+			return nil, false, err
+		}
+		//未读完整的行在写入分段中为正在写入，否则为异常退出时残留的不完整记录
+		if len(q.segments) == 1 {
+			q.buffered = bufio.NewReader(io.MultiReader(bytes.NewReader(line), q.reader))
+			return nil, false, nil
+		}
+		if len(line) != 0 {
+			g.LogError("spool ", q.name, " skip incomplete record in segment ", q.segments[0])
+		}
+		q.removeHead()
+	}
+	return nil, false, nil
+}
+
+//Commit 确认Peek读取的记录已投递
+func (q *Queue) Commit() {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.next == nil {
+		return
+	}
+	q.advance()
+	q.stats.Delivered++
+}
+
+//discard 将Peek读取的记录写入死信文件并跳过
+func (q *Queue) discard(b []byte, attempts int, cause error) {
+	g.LogError("spool ", q.name, " discard record after ", attempts, " attempts:", cause.Error())
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.next == nil {
+		return
+	}
+	if err := q.writeDead(b, attempts, cause); err != nil {
+		g.LogError("spool ", q.name, " write dead letter err:", err.Error())
+	}
+	q.advance()
+	q.stats.Dead++
+}
+
+//advance 移过Peek读取的记录，调用方需持有lock
+func (q *Queue) advance() {
+	q.readOffset += int64(len(q.next))
+	q.next = nil
+	q.stats.Records--
+	if time.Since(q.saved) >= saveWait {
+		q.saveCursor()
+	}
+}
+
+//writeDead 追加死信记录，死信文件超过maxSize时改名为 dead.json.old 后重新写入，调用方需持有lock
+func (q *Queue) writeDead(b []byte, attempts int, cause error) error {
+	line, err := g.Json.Marshal(deadRecord{
+		Time:     time.Now().Format("2006-01-02 15:04:05"),
+		Attempts: attempts,
+		Error:    cause.Error(),
+		Record:   string(b),
+	})
+	if err != nil {
+		return err
+	}
+	name := filepath.Join(q.dir, deadFile)
+	if info, err := os.Stat(name); err == nil && info.Size() >= maxSize() {
+		os.Rename(name, name+".old")
+	}
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
+
+//Run 按顺序投递队列中的记录，不返回
+//投递失败时间隔Retry后重试同一记录；返回Permanent错误或失败maxAttempts次后写入死信文件并投递下一条
+func (q *Queue) Run(deliver func(b []byte) error) {
+	failed := false
+	attempts := 0
+	for {
+		b, ok, err := q.Peek()
+		if err != nil {
+			g.LogError("spool ", q.name, " read err:", err.Error())
+			time.Sleep(Retry())
+			continue
+		}
+		if !ok {
+			<-q.ready
+			continue
+		}
+		if err := deliver(b); err != nil {
+			attempts++
+			if IsPermanent(err) || attempts >= maxAttempts() {
+				q.discard(b, attempts, err)
+				attempts = 0
+				continue
+			}
+			if !failed {
+				g.LogError("spool ", q.name, " deliver err:", err.Error())
+				failed = true
+			}
+			time.Sleep(Retry())
+			continue
+		}
+		if failed {
+			g.LogInfo("spool ", q.name, " deliver recovered, backlog records:", q.Stats().Records)
+			failed = false
+		}
+		attempts = 0
+		q.Commit()
+	}
+}
+
+//load 读取分段文件及读取位置，截断写入分段末尾不完整的记录
+func (q *Queue) load() error {
+	if err := os.MkdirAll(q.dir, 0755); err != nil {
+		return err
+	}
+	files, err := ioutil.ReadDir(q.dir)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if !strings.HasSuffix(f.Name(), segmentExt) {
+			continue
+		}
+		seq, err := strconv.ParseInt(strings.TrimSuffix(f.Name(), segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		q.segments = append(q.segments, seq)
+		q.size += f.Size()
+	}
+	sort.Slice(q.segments, func(i, j int) bool {
+		return q.segments[i] < q.segments[j]
+	})
+	if len(q.segments) == 0 {
+		return nil
+	}
+	q.loadCursor()
+	last := q.segments[len(q.segments)-1]
+	b, err := ioutil.ReadFile(q.segmentName(last))
+	if err != nil {
+		return err
+	}
+	if i := bytes.LastIndexByte(b, '\n'); i+1 != len(b) {
+		q.size -= int64(len(b) - i - 1)
+		if err := os.Truncate(q.segmentName(last), int64(i+1)); err != nil {
+			return err
+		}
+		g.LogError("spool ", q.name, " truncate incomplete record in segment ", last)
+	}
+	for i, seq := range q.segments {
+		b, err := ioutil.ReadFile(q.segmentName(seq))
+		if err != nil {
+			return err
+		}
+		if i == 0 && q.readOffset <= int64(len(b)) {
+			b = b[q.readOffset:]
+		}
+		q.stats.Records += int64(bytes.Count(b, []byte{'\n'}))
+	}
+	return nil
+}
+
+//loadCursor 读取读取位置，cursor文件中的分段不存在时从最早的分段开始
+func (q *Queue) loadCursor() {
+	b, err := ioutil.ReadFile(filepath.Join(q.dir, cursorFile))
+	if err != nil {
+		return
+	}
+	var seq, offset int64
+	if _, err := fmt.Sscan(string(b), &seq, &offset); err != nil {
+		return
+	}
+	//cursor之前的分段已投递完成，删除后异常退出时残留
+	for len(q.segments) > 1 && q.segments[0] < seq {
+		name := q.segmentName(q.segments[0])
+		if info, err := os.Stat(name); err == nil {
+			q.size -= info.Size()
+		}
+		os.Remove(name)
+		q.segments = q.segments[1:]
+	}
+	if q.segments[0] == seq {
+		q.readOffset = offset
+	}
+}
+
+//saveCursor 保存读取位置，调用方需持有lock
+func (q *Queue) saveCursor() {
+	q.saved = time.Now()
+	if len(q.segments) == 0 {
+		return
+	}
+	name := filepath.Join(q.dir, cursorFile)
+	s := strconv.FormatInt(q.segments[0], 10) + " " + strconv.FormatInt(q.readOffset, 10)
+	if err := ioutil.WriteFile(name+".tmp", []byte(s), 0644); err != nil {
+		g.LogError("spool ", q.name, " save cursor err:", err.Error())
+		return
+	}
+	if err := os.Rename(name+".tmp", name); err != nil {
+		g.LogError("spool ", q.name, " save cursor err:", err.Error())
+	}
+}
+
+func (q *Queue) segmentName(seq int64) string {
+	return filepath.Join(q.dir, fmt.Sprintf("%016d", seq)+segmentExt)
+}
+
+//rotate 切换新的写入分段，调用方需持有lock
+func (q *Queue) rotate() error {
+	seq := int64(1)
+	if len(q.segments) != 0 {
+		seq = q.segments[len(q.segments)-1]
+		if q.writer != nil {
+			q.writer.Close()
+			seq++
+		}
+	}
+	f, err := os.OpenFile(q.segmentName(seq), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if len(q.segments) == 0 || q.segments[len(q.segments)-1] != seq {
+		q.segments = append(q.segments, seq)
+	}
+	q.writer = f
+	q.writeSize = info.Size()
+	return nil
+}
+
+//openReader 打开最早的分段并定位至读取位置，调用方需持有lock
+func (q *Queue) openReader() error {
+	f, err := os.Open(q.segmentName(q.segments[0]))
+	if err != nil {
+		return err
+	}
+	if _, err := f.Seek(q.readOffset, io.SeekStart); err != nil {
+		f.Close()
+		return err
+	}
+	q.reader = f
+	q.buffered = bufio.NewReader(f)
+	return nil
+}
+
+//removeHead 删除最早的分段，未投递的记录计入丢弃数，调用方需持有lock
+func (q *Queue) removeHead() {
+	seq := q.segments[0]
+	name := q.segmentName(seq)
+	if q.reader != nil {
+		q.reader.Close()
+		q.reader = nil
+		q.buffered = nil
+	}
+	q.next = nil
+	if b, err := ioutil.ReadFile(name); err == nil {
+		if q.readOffset <= int64(len(b)) {
+			n := int64(bytes.Count(b[q.readOffset:], []byte{'\n'}))
+			q.stats.Records -= n
+			q.stats.Dropped += n
+		}
+		q.size -= int64(len(b))
+	}
+	if err := os.Remove(name); err != nil {
+		g.LogError("spool ", q.name, " remove segment err:", err.Error())
+	}
+	q.segments = q.segments[1:]
+	q.readOffset = 0
+	q.saveCursor()
+}
+
+//limit 积压超过maxSize时丢弃最旧的分段，写入分段不丢弃，调用方需持有lock
+func (q *Queue) limit() {
+	for q.size-q.readOffset > maxSize() && len(q.segments) > 1 {
+		dropped := q.stats.Dropped
+		q.removeHead()
+		g.LogError("spool ", q.name, " backlog exceeds max size, dropped records:", q.stats.Dropped-dropped)
+	}
+}
@@ -0,0 +1,256 @@
+package spool
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+	"tollsys/tollmon/g"
+)
+
+//setup 以临时目录作为存储目录，分段大小1MB，最大积压3MB，重试间隔1秒，最大投递次数2
+func setup(t *testing.T) {
+	dir := t.TempDir()
+	cfg := `{"store": {"path": "` + filepath.ToSlash(dir) + `"}, "spool": {"segmentSize": 1, "maxSize": 3, "retry": 1, "maxAttempts": 2}}`
+	name := filepath.Join(dir, "config.json")
+	if err := ioutil.WriteFile(name, []byte(cfg), 0644); err != nil {
+		t.Fatal(err)
+	}
+	g.ParseConfig(name)
+	lock.Lock()
+	queues = make(map[string]*Queue)
+	lock.Unlock()
+}
+
+//reopen 模拟进程重启：关闭文件，保存读取位置后重新打开队列
+func reopen(t *testing.T, q *Queue, save bool) *Queue {
+	q.lock.Lock()
+	if save {
+		q.saveCursor()
+	}
+	if q.writer != nil {
+		q.writer.Close()
+	}
+	if q.reader != nil {
+		q.reader.Close()
+	}
+	q.lock.Unlock()
+	lock.Lock()
+	delete(queues, q.name)
+	lock.Unlock()
+	r, err := Open(q.name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+//record 生成序号为i、长度为size的记录
+func record(i int, size int) []byte {
+	b := []byte(strconv.Itoa(i) + ":")
+	return append(b, bytes.Repeat([]byte("x"), size-len(b))...)
+}
+
+func recordSeq(t *testing.T, b []byte) int {
+	i, err := strconv.Atoi(string(b[:bytes.IndexByte(b, ':')]))
+	if err != nil {
+		t.Fatalf("bad record %q", b)
+	}
+	return i
+}
+
+//drain 读取并确认全部记录，返回记录序号
+func drain(t *testing.T, q *Queue) []int {
+	seqs := make([]int, 0)
+	for {
+		b, ok, err := q.Peek()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			return seqs
+		}
+		again, _, _ := q.Peek()
+		if !bytes.Equal(b, again) {
+			t.Fatalf("peek before commit returned %q then %q", b, again)
+		}
+		seqs = append(seqs, recordSeq(t, b))
+		q.Commit()
+	}
+}
+
+func checkSeqs(t *testing.T, got []int, from int, to int) {
+	t.Helper()
+	if len(got) != to-from {
+		t.Fatalf("got %d records, want %d (%v...)", len(got), to-from, got[:minInt(len(got), 5)])
+	}
+	for k, i := range got {
+		if i != from+k {
+			t.Fatalf("record %d is %d, want %d", k, i, from+k)
+		}
+	}
+}
+
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func TestQueue(t *testing.T) {
+	const size = 64 << 10 //每个分段16条记录，积压48条时超过最大积压
+	cases := []struct {
+		name     string
+		push     int  //写入记录数
+		consume  int  //重启前确认的记录数
+		restart  bool //是否模拟重启
+		save     bool //重启前是否保存读取位置
+		from     int  //重启后读取的第一条记录
+		segments int  //写入后的分段数，已读完的分段被删除
+		dropped  int64
+	}{
+		{name: "single segment", push: 10, from: 0, segments: 1},
+		{name: "segment rollover", push: 40, from: 0, segments: 3},
+		{name: "restart with cursor", push: 40, consume: 20, restart: true, save: true, from: 20, segments: 2},
+		{name: "restart at segment end", push: 40, consume: 16, restart: true, save: true, from: 16, segments: 3},
+		{name: "restart without cursor replays segment", push: 40, consume: 20, restart: true, from: 16, segments: 2},
+		{name: "evict oldest segment", push: 48, from: 16, segments: 2, dropped: 16},
+		{name: "evict after partial consume", push: 52, consume: 4, from: 16, segments: 3, dropped: 12},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			setup(t)
+			q, err := Open("test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			consumed := make([]int, 0)
+			for i := 0; i < c.push; i++ {
+				if err := q.Push(record(i, size)); err != nil {
+					t.Fatal(err)
+				}
+				//在写入过程中确认，覆盖读写同一分段的情况
+				if len(consumed) < c.consume {
+					b, ok, err := q.Peek()
+					if err != nil || !ok {
+						t.Fatalf("peek after push %d: %v %v", i, ok, err)
+					}
+					consumed = append(consumed, recordSeq(t, b))
+					q.Commit()
+				}
+			}
+			checkSeqs(t, consumed, 0, c.consume)
+			s := q.Stats()
+			if s.Segments != c.segments || s.Dropped != c.dropped {
+				t.Fatalf("stats %+v, want segments %d dropped %d", s, c.segments, c.dropped)
+			}
+			if c.restart {
+				q = reopen(t, q, c.save)
+			}
+			if s := q.Stats(); s.Records != int64(c.push-c.from) || s.Bytes != int64(c.push-c.from)*(size+1) {
+				t.Fatalf("backlog %+v, want %d records", s, c.push-c.from)
+			}
+			checkSeqs(t, drain(t, q), c.from, c.push)
+			if s := q.Stats(); s.Records != 0 || s.Bytes != 0 || s.Segments != 1 {
+				t.Fatalf("after drain %+v", s)
+			}
+		})
+	}
+}
+
+func TestQueueTruncatesIncompleteRecord(t *testing.T) {
+	setup(t)
+	q, _ := Open("test")
+	for i := 0; i < 3; i++ {
+		q.Push(record(i, 16))
+	}
+	q.lock.Lock()
+	q.writer.Write([]byte("3:partial"))
+	q.lock.Unlock()
+	q = reopen(t, q, true)
+	if s := q.Stats(); s.Records != 3 {
+		t.Fatalf("stats %+v", s)
+	}
+	q.Push(record(3, 16))
+	checkSeqs(t, drain(t, q), 0, 4)
+}
+
+func TestQueueRejectsNewline(t *testing.T) {
+	setup(t)
+	q, _ := Open("test")
+	if err := q.Push([]byte("a\nb")); err == nil {
+		t.Fatal("record with newline accepted")
+	}
+}
+
+func TestRunDeadLetter(t *testing.T) {
+	setup(t)
+	q, _ := Open("test")
+	attempts := make(map[int]int)
+	delivered := make(chan int, 4)
+	go q.Run(func(b []byte) error {
+		i := recordSeq(t, b)
+		attempts[i]++
+		switch i {
+		case 0:
+			return Permanent(errors.New("550 no such user"))
+		case 1:
+			return errors.New("connection refused")
+		}
+		delivered <- i
+		return nil
+	})
+	for i := 0; i < 3; i++ {
+		q.Push(record(i, 16))
+	}
+	select {
+	case i := <-delivered:
+		if i != 2 {
+			t.Fatalf("delivered %d, want 2", i)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("record after failed records not delivered, stats %+v", q.Stats())
+	}
+	if attempts[0] != 1 || attempts[1] != 2 {
+		t.Errorf("attempts %v, want permanent 1, temporary 2", attempts)
+	}
+	//投递函数返回后才确认记录
+	for k := 0; k < 100 && q.Stats().Delivered == 0; k++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if s := q.Stats(); s.Records != 0 || s.Delivered != 1 || s.Dead != 2 {
+		t.Errorf("stats %+v", s)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(q.dir, deadFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := bytes.Split(bytes.TrimSuffix(b, []byte{'\n'}), []byte{'\n'})
+	if len(lines) != 2 {
+		t.Fatalf("dead letters %q", b)
+	}
+	for k, line := range lines {
+		d := deadRecord{}
+		if err := g.Json.Unmarshal(line, &d); err != nil {
+			t.Fatal(err)
+		}
+		if recordSeq(t, []byte(d.Record)) != k || d.Attempts != k+1 || d.Error == "" {
+			t.Errorf("dead letter %d: %+v", k, d)
+		}
+	}
+}
+
+func TestGetStats(t *testing.T) {
+	setup(t)
+	b, _ := Open("b")
+	Open("a")
+	b.Push([]byte("1"))
+	stats := GetStats()
+	if len(stats) != 2 || stats[0].Name != "a" || stats[1].Name != "b" || stats[1].Records != 1 || stats[1].Pushed != 1 {
+		t.Fatalf("stats %+v", stats)
+	}
+}
@@ -0,0 +1,109 @@
+package store
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+	"tollsys/tollmon/g"
+)
+
+//按日存储的本地记录文件，每类记录一个目录，每天一个文件，每行一条json记录
+//目录结构：{store.path}/{kind}/20060102.json
+//追加写按记录类别加锁，读取时不加锁：文件仅追加，每条记录以一次写入完成，读取时忽略末尾未写完的记录
+
+var (
+	lock  = &sync.Mutex{}
+	locks = make(map[string]*sync.Mutex) //记录类别 -> 追加写锁
+)
+
+func kindLock(kind string) *sync.Mutex {
+	lock.Lock()
+	defer lock.Unlock()
+	l, ok := locks[kind]
+	if !ok {
+		l = &sync.Mutex{}
+		locks[kind] = l
+	}
+	return l
+}
+
+//Dir 获取某类记录的存储目录
+func Dir(kind string) string {
+	path := "./data"
+	if g.Config().Store != nil && g.Config().Store.Path != "" {
+		path = g.Config().Store.Path
+	}
+	return filepath.Join(path, kind)
+}
+
+func fileName(kind string, day time.Time) string {
+	return filepath.Join(Dir(kind), day.Format("20060102")+".json")
+}
+
+//Append 追加一条记录至该类记录day当日的文件
+func Append(kind string, day time.Time, v interface{}) error {
+	b, err := g.Json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	l := kindLock(kind)
+	l.Lock()
+	defer l.Unlock()
+	if err := os.MkdirAll(Dir(kind), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(fileName(kind, day), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(b, '\n'))
+	return err
+}
+
+//Load 按日期顺序读取该类记录[from,to]日期范围内的全部记录，每条记录回调一次
+//不存在的日期文件将被忽略
+func Load(kind string, from time.Time, to time.Time, fn func(b []byte)) error {
+	from = Day(from)
+	for day := from; !day.After(to); day = day.AddDate(0, 0, 1) {
+		f, err := os.Open(fileName(kind, day))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		err = readLines(f, fn)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//readLines 逐行回调以换行结尾的记录，末尾无换行的记录为正在写入，不回调
+func readLines(r io.Reader, fn func(b []byte)) error {
+	reader := bufio.NewReaderSize(r, 64*1024)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if len(line) > 1 {
+			fn(line[:len(line)-1])
+		}
+	}
+}
+
+//Day 获取t当日零点
+func Day(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
@@ -0,0 +1,346 @@
+package summary
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"tollsys/tollmon/datastruct"
+	"tollsys/tollmon/events"
+	"tollsys/tollmon/g"
+	"tollsys/tollmon/notify"
+	"tollsys/tollmon/parameters"
+	"tollsys/tollmon/revenue"
+	"tollsys/tollmon/shift"
+	"tollsys/tollmon/store"
+	"tollsys/tollmon/timeline"
+	"tollsys/tollmon/traffic"
+
+	"github.com/robfig/cron"
+)
+
+//收费站每日汇总报表
+//按计划生成各收费站前一日的流量、通行费、报警、车道可用率及报警最多的员工汇总，
+//以json、html格式保存于 {store.path}/summary/20060102/{stationID}.json(.html)，并通过已配置的通知通道发送
+
+const (
+	KIND = "summary"
+
+	dateFormat = "2006-01-02"
+	timeFormat = "2006-01-02 15:04:05"
+)
+
+//AlertCount 报警类别次数
+type AlertCount struct {
+	Type        int    `json:"type"`
+	Description string `json:"description"`
+	Level       int    `json:"level"`
+	Count       int    `json:"count"`
+}
+
+//Summary 收费站单日汇总
+//Entry/Exit 为出入口流量；Pass/Loan/Forfeit 为按出口时间统计的当日通行费、借款、罚款，Shifts 为当日上班的班次数；
+//Availability 为收费站可用率，Lanes 为各车道可用率；Employees 为报警次数最多的员工
+type Summary struct {
+	StationID    string         `json:"stationID"`
+	StationName  string         `json:"stationName"`
+	Date         string         `json:"date"`
+	Entry        int            `json:"entry"`
+	Exit         int            `json:"exit"`
+	Pass         int            `json:"pass"`
+	Loan         int            `json:"loan"`
+	Forfeit      int            `json:"forfeit"`
+	Shifts       int            `json:"shifts"`
+	AlertCount   int            `json:"alertCount"`
+	Alerts       []AlertCount   `json:"alerts"`
+	Availability timeline.SLA   `json:"availability"`
+	Lanes        []timeline.SLA `json:"lanes"`
+	Employees    []shift.Report `json:"employees"`
+	GenerateTime string         `json:"generateTime"`
+}
+
+var (
+	lock = &sync.Mutex{}
+)
+
+func settings() (string, int, int) {
+	schedule, top, keepDays := "30 0 * * *", 10, 0
+	if c := g.Config().Summary; c != nil {
+		if c.Schedule != "" {
+			schedule = c.Schedule
+		}
+		if c.Top > 0 {
+			top = c.Top
+		}
+		keepDays = c.KeepDays
+	}
+	return schedule, top, keepDays
+}
+
+//InitSummary 按配置的计划启动每日汇总报表生成，未启用时不启动
+func InitSummary() {
+	if c := g.Config().Summary; c == nil || !c.Enabled {
+		return
+	}
+	spec, _, _ := settings()
+	schedule, err := cron.ParseStandard(spec)
+	if err != nil {
+		g.LogError("parse summary schedule err:", spec, err.Error())
+		return
+	}
+	c := cron.New()
+	c.Schedule(schedule, cron.FuncJob(run))
+	c.Start()
+	g.LogInfo("Init Summary OK...", spec)
+}
+
+//run 生成前一日全部收费站的汇总报表并发送，清理过期报表
+func run() {
+	day := store.Day(time.Now()).AddDate(0, 0, -1)
+	for _, stationID := range stations() {
+		s, err := Generate(stationID, day)
+		if err != nil {
+			g.LogError("generate summary err:", stationID, err.Error())
+			continue
+		}
+		Deliver(s)
+	}
+	cleanup()
+}
+
+//stations 由车道节点获取全部收费站节点(16位)
+func stations() []string {
+	list := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, lane := range parameters.GetLanes() {
+		id := lane.NodeID[0:16]
+		if !seen[id] {
+			seen[id] = true
+			list = append(list, id)
+		}
+	}
+	return list
+}
+
+func stationLanes(stationID string) []datastruct.Node {
+	list := make([]datastruct.Node, 0)
+	for _, lane := range parameters.GetLanes() {
+		if lane.NodeID[0:16] == stationID {
+			list = append(list, lane)
+		}
+	}
+	return list
+}
+
+//stationKey 校验收费站节点，返回用于报表文件名的16位收费站编号
+//编号含路径分隔符或不是已知节点时返回错误，避免请求参数拼接出存储目录以外的路径
+func stationKey(stationID string) (string, error) {
+	if len(stationID) < 16 || strings.ContainsAny(stationID[0:16], `/\.`) {
+		return "", errors.New("invalid stationId " + stationID)
+	}
+	id := stationID[0:16]
+	if _, ok := parameters.GetNodeByID(id); !ok {
+		return "", errors.New("unknown stationId " + stationID)
+	}
+	return id, nil
+}
+
+//Generate 生成收费站day当日的汇总报表并保存，已存在时覆盖
+func Generate(stationID string, day time.Time) (Summary, error) {
+	id, err := stationKey(stationID)
+	if err != nil {
+		return Summary{}, err
+	}
+	s := build(id, store.Day(day))
+	return s, save(s)
+}
+
+func build(stationID string, day time.Time) Summary {
+	_, top, _ := settings()
+	end := day.AddDate(0, 0, 1)
+	s := Summary{StationID: stationID, Date: day.Format(dateFormat), Alerts: make([]AlertCount, 0), Employees: make([]shift.Report, 0)}
+	if node, ok := parameters.GetNodeByID(stationID); ok {
+		s.StationName = node.NodeName
+	}
+	lanes := stationLanes(stationID)
+	totals := revenue.GetDay(day)
+	for _, lane := range lanes {
+		for _, p := range traffic.Range(traffic.Hour, lane.NodeID, day, end) {
+			s.Entry += p.Entry
+			s.Exit += p.Exit
+		}
+		t := totals[lane.NodeID]
+		s.Pass += t.Pass
+		s.Loan += t.Loan
+		s.Forfeit += t.Forfeit
+	}
+
+	q := shift.Query{StationID: stationID, From: day, To: day}
+	for _, r := range shift.GetLaneReports(q) {
+		s.Shifts += r.Shifts
+	}
+	employees := shift.GetEmployeeReports(q)
+	sort.SliceStable(employees, func(i, j int) bool {
+		return employees[i].AlertCount > employees[j].AlertCount
+	})
+	for _, r := range employees {
+		if r.AlertCount == 0 || len(s.Employees) >= top {
+			break
+		}
+		s.Employees = append(s.Employees, r)
+	}
+
+	counts := make(map[int]int)
+	for _, e := range events.GetEvents(events.Query{StationID: stationID, From: day, To: day}) {
+		counts[e.Type]++
+		s.AlertCount++
+	}
+	for t, n := range counts {
+		a := AlertCount{Type: t, Count: n}
+		if item, ok := parameters.GetTypeToStrategyItems()[t]; ok {
+			a.Description = item.Description
+			a.Level = item.Level
+		}
+		s.Alerts = append(s.Alerts, a)
+	}
+	sort.Slice(s.Alerts, func(i, j int) bool {
+		if s.Alerts[i].Count != s.Alerts[j].Count {
+			return s.Alerts[i].Count > s.Alerts[j].Count
+		}
+		return s.Alerts[i].Type < s.Alerts[j].Type
+	})
+
+	r := timeline.GetSLA(lanes, day, end)
+	s.Lanes = r.Lanes
+	if len(r.Stations) > 0 {
+		s.Availability = r.Stations[0]
+	} else {
+		s.Availability = timeline.SLA{NodeID: stationID, NodeName: s.StationName}
+	}
+	s.GenerateTime = time.Now().Format(timeFormat)
+	return s
+}
+
+func fileName(stationID string, date string, ext string) string {
+	day, err := time.ParseInLocation(dateFormat, date, time.Local)
+	if err != nil {
+		day = time.Now()
+	}
+	return filepath.Join(store.Dir(KIND), day.Format("20060102"), stationID+ext)
+}
+
+//save 保存汇总报表的json及html文件
+func save(s Summary) error {
+	b, err := g.Json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	html, err := renderHTML(&s)
+	if err != nil {
+		return err
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	name := fileName(s.StationID, s.Date, ".json")
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(name, b, 0644); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(fileName(s.StationID, s.Date, ".html"), []byte(html), 0644)
+}
+
+//Deliver 通过通知通道发送汇总报表
+func Deliver(s Summary) {
+	html, err := renderHTML(&s)
+	if err != nil {
+		g.LogError("render summary err:", s.StationID, err.Error())
+		return
+	}
+	notify.SendReport(&notify.Report{
+		StationID: s.StationID,
+		Subject:   "[日报] " + s.StationName + " " + s.Date,
+		HTML:      html,
+		Text:      text(&s),
+	})
+}
+
+//text 单行摘要
+func text(s *Summary) string {
+	return s.StationName + " " + s.Date +
+		" entry=" + strconv.Itoa(s.Entry) +
+		" exit=" + strconv.Itoa(s.Exit) +
+		" pass=" + strconv.Itoa(s.Pass) +
+		" alerts=" + strconv.Itoa(s.AlertCount) +
+		" availability=" + strconv.FormatFloat(s.Availability.Availability, 'f', 2, 64)
+}
+
+//GetSummaries 查询已保存的汇总报表，stationID为空时查询全部收费站，按日期、收费站排序
+func GetSummaries(stationID string, from time.Time, to time.Time) []Summary {
+	list := make([]Summary, 0)
+	lock.Lock()
+	defer lock.Unlock()
+	for day := store.Day(from); !day.After(to); day = day.AddDate(0, 0, 1) {
+		files, err := ioutil.ReadDir(filepath.Join(store.Dir(KIND), day.Format("20060102")))
+		if err != nil {
+			continue
+		}
+		for _, f := range files {
+			name := f.Name()
+			if filepath.Ext(name) != ".json" || (stationID != "" && name != stationID[0:16]+".json") {
+				continue
+			}
+			b, err := ioutil.ReadFile(filepath.Join(store.Dir(KIND), day.Format("20060102"), name))
+			if err != nil {
+				g.LogError("read summary err:", name, err.Error())
+				continue
+			}
+			s := Summary{}
+			if err := g.Json.Unmarshal(b, &s); err != nil {
+				g.LogError("parse summary err:", name, err.Error())
+				continue
+			}
+			list = append(list, s)
+		}
+	}
+	return list
+}
+
+//GetHTML 获取已保存的汇总报表html文件
+func GetHTML(stationID string, day time.Time) ([]byte, error) {
+	id, err := stationKey(stationID)
+	if err != nil {
+		return nil, err
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	return ioutil.ReadFile(fileName(id, day.Format(dateFormat), ".html"))
+}
+
+//cleanup 删除超过保留天数的报表目录
+func cleanup() {
+	_, _, keepDays := settings()
+	if keepDays <= 0 {
+		return
+	}
+	expire := store.Day(time.Now()).AddDate(0, 0, -keepDays).Format("20060102")
+	lock.Lock()
+	defer lock.Unlock()
+	dirs, err := ioutil.ReadDir(store.Dir(KIND))
+	if err != nil {
+		return
+	}
+	for _, d := range dirs {
+		if d.IsDir() && len(d.Name()) == 8 && d.Name() < expire {
+			if err := os.RemoveAll(filepath.Join(store.Dir(KIND), d.Name())); err != nil {
+				g.LogError("remove summary err:", d.Name(), err.Error())
+			}
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package summary
+
+import (
+	"bytes"
+	"html/template"
+)
+
+const htmlTemplate = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.StationName}} {{.Date}} 日报</title>
+<style>
+body{font-family:sans-serif;font-size:14px;}
+table{border-collapse:collapse;margin-bottom:16px;}
+th,td{border:1px solid #999;padding:4px 8px;text-align:right;}
+th{background:#eee;}
+td.name{text-align:left;}
+</style>
+</head>
+<body>
+<h2>{{.StationName}}({{.StationID}}) {{.Date}} 日报</h2>
+<h3>流量及通行费</h3>
+<table>
+<tr><th>入口流量</th><th>出口流量</th><th>班次数</th><th>通行费</th><th>借款</th><th>罚款</th></tr>
+<tr><td>{{.Entry}}</td><td>{{.Exit}}</td><td>{{.Shifts}}</td><td>{{.Pass}}</td><td>{{.Loan}}</td><td>{{.Forfeit}}</td></tr>
+</table>
+<h3>报警统计(共{{.AlertCount}}次)</h3>
+<table>
+<tr><th>报警类别</th><th>报警描述</th><th>报警级别</th><th>次数</th></tr>
+{{range .Alerts}}<tr><td>{{.Type}}</td><td class="name">{{.Description}}</td><td>{{.Level}}</td><td>{{.Count}}</td></tr>
+{{end}}</table>
+<h3>车道可用率</h3>
+<table>
+<tr><th>车道</th><th>可用率(%)</th><th>中断次数</th><th>平均恢复秒数</th><th>不可用秒数</th></tr>
+<tr><td class="name">全站</td><td>{{.Availability.Availability}}</td><td>{{.Availability.Outages}}</td><td>{{.Availability.MTTR}}</td><td>{{.Availability.Downtime}}</td></tr>
+{{range .Lanes}}<tr><td class="name">{{.NodeName}}</td><td>{{.Availability}}</td><td>{{.Outages}}</td><td>{{.MTTR}}</td><td>{{.Downtime}}</td></tr>
+{{end}}</table>
+<h3>报警最多的员工</h3>
+<table>
+<tr><th>工号</th><th>姓名</th><th>班次数</th><th>报警次数</th></tr>
+{{range .Employees}}<tr><td>{{.Key}}</td><td class="name">{{.Name}}</td><td>{{.Shifts}}</td><td>{{.AlertCount}}</td></tr>
+{{end}}</table>
+<p>生成时间：{{.GenerateTime}}</p>
+</body>
+</html>
+`
+
+var page = template.Must(template.New("summary").Parse(htmlTemplate))
+
+//renderHTML 生成汇总报表html
+func renderHTML(s *Summary) (string, error) {
+	var buffer bytes.Buffer
+	if err := page.Execute(&buffer, s); err != nil {
+		return "", err
+	}
+	return buffer.String(), nil
+}
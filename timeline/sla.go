@@ -0,0 +1,130 @@
+package timeline
+
+import (
+	"time"
+	"tollsys/tollmon/datastruct"
+	"tollsys/tollmon/parameters"
+)
+
+//SLA 车道、收费站可用率统计
+//车道可用指通讯连接且车道开启(无开闭记录视为开启)；Known 为有通讯连接记录的秒数，
+//Outages 为不可用次数，Downtime 为不可用总秒数，MTTR 为平均恢复时间(秒)
+type SLA struct {
+	NodeID       string  `json:"nodeID"`
+	NodeName     string  `json:"nodeName"`
+	Lanes        int     `json:"lanes"`
+	Known        int64   `json:"known"`
+	Uptime       int64   `json:"uptime"`
+	Downtime     int64   `json:"downtime"`
+	Availability float64 `json:"availability"`
+	Outages      int     `json:"outages"`
+	MTTR         int64   `json:"mttr"`
+}
+
+//SLAReport 可用率报表
+type SLAReport struct {
+	From     string `json:"from"`
+	To       string `json:"to"`
+	Lanes    []SLA  `json:"lanes"`
+	Stations []SLA  `json:"stations"`
+}
+
+//available 合并通讯连接、车道开闭区间为可用区间，On为可用，Off为不可用，通讯连接无记录时为Unknown
+func available(connect []Segment, status []Segment) []Segment {
+	list := make([]Segment, 0)
+	for _, c := range connect {
+		if c.State != On {
+			list = appendSegment(list, c.Start, c.End, c.State)
+			continue
+		}
+		cs, ce := parseTime(c.Start), parseTime(c.End)
+		for _, s := range status {
+			start, end := parseTime(s.Start), parseTime(s.End)
+			if start.Before(cs) {
+				start = cs
+			}
+			if end.After(ce) {
+				end = ce
+			}
+			if !end.After(start) {
+				continue
+			}
+			state := On
+			if s.State == Off {
+				state = Off
+			}
+			list = appendSegment(list, start.Format(timeFormat), end.Format(timeFormat), state)
+		}
+	}
+	return list
+}
+
+//appendSegment 追加区间，与前一区间状态相同时合并
+func appendSegment(list []Segment, start string, end string, state int) []Segment {
+	seconds := int64(parseTime(end).Sub(parseTime(start)).Seconds())
+	if n := len(list); n > 0 && list[n-1].State == state && list[n-1].End == start {
+		list[n-1].End = end
+		list[n-1].Seconds += seconds
+		return list
+	}
+	return append(list, Segment{Start: start, End: end, State: state, Seconds: seconds})
+}
+
+func (s *SLA) add(o SLA) {
+	s.Lanes += o.Lanes
+	s.Known += o.Known
+	s.Uptime += o.Uptime
+	s.Downtime += o.Downtime
+	s.Outages += o.Outages
+}
+
+func (s *SLA) finish() {
+	s.Availability = percent(s.Uptime, s.Known)
+	if s.Outages > 0 {
+		s.MTTR = s.Downtime / int64(s.Outages)
+	}
+}
+
+//GetSLA 统计所选车道[from,to)时段内的可用率，并按收费站汇总
+func GetSLA(laneList []datastruct.Node, from time.Time, to time.Time) SLAReport {
+	if now := time.Now(); to.After(now) {
+		to = now
+	}
+	r := SLAReport{From: from.Format(timeFormat), To: to.Format(timeFormat), Lanes: make([]SLA, 0), Stations: make([]SLA, 0)}
+	events := load(laneList, from, to)
+	index := make(map[string]int)
+	for _, lane := range laneList {
+		m := events[lane.NodeID]
+		l := SLA{NodeID: lane.NodeID, NodeName: lane.NodeName, Lanes: 1}
+		for _, seg := range available(segments(m[Connect], from, to), segments(m[Status], from, to)) {
+			switch seg.State {
+			case On:
+				l.Known += seg.Seconds
+				l.Uptime += seg.Seconds
+			case Off:
+				l.Known += seg.Seconds
+				l.Downtime += seg.Seconds
+				l.Outages++
+			}
+		}
+		l.finish()
+		r.Lanes = append(r.Lanes, l)
+
+		stationID := lane.NodeID[0:16]
+		i, ok := index[stationID]
+		if !ok {
+			st := SLA{NodeID: stationID}
+			if node, ok := parameters.GetNodeByID(stationID); ok {
+				st.NodeName = node.NodeName
+			}
+			r.Stations = append(r.Stations, st)
+			i = len(r.Stations) - 1
+			index[stationID] = i
+		}
+		r.Stations[i].add(l)
+	}
+	for i := range r.Stations {
+		r.Stations[i].finish()
+	}
+	return r
+}
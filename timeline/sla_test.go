@@ -0,0 +1,103 @@
+package timeline
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+	"tollsys/tollmon/datastruct"
+	"tollsys/tollmon/g"
+	"tollsys/tollmon/store"
+)
+
+func TestAvailable(t *testing.T) {
+	from := time.Date(2020, 3, 1, 8, 0, 0, 0, time.Local)
+	at := func(minutes int) string {
+		return from.Add(time.Duration(minutes) * time.Minute).Format(timeFormat)
+	}
+	seg := func(start int, end int, state int) Segment {
+		return Segment{Start: at(start), End: at(end), State: state, Seconds: int64((end - start) * 60)}
+	}
+	cases := []struct {
+		name    string
+		connect []Segment
+		status  []Segment
+		want    []Segment
+	}{
+		{"unknown connect kept unknown", []Segment{seg(0, 10, Unknown), seg(10, 60, On)}, []Segment{seg(0, 60, On)}, []Segment{seg(0, 10, Unknown), seg(10, 60, On)}},
+		{"unknown status counted as open", []Segment{seg(0, 60, On)}, []Segment{seg(0, 60, Unknown)}, []Segment{seg(0, 60, On)}},
+		{"closed lane is an outage", []Segment{seg(0, 60, On)}, []Segment{seg(0, 20, On), seg(20, 40, Off), seg(40, 60, On)}, []Segment{seg(0, 20, On), seg(20, 40, Off), seg(40, 60, On)}},
+		{"adjacent outages merged", []Segment{seg(0, 20, Off), seg(20, 60, On)}, []Segment{seg(0, 40, Off), seg(40, 60, On)}, []Segment{seg(0, 40, Off), seg(40, 60, On)}},
+		{"outage open at period end", []Segment{seg(0, 60, On)}, []Segment{seg(0, 40, On), seg(40, 60, Off)}, []Segment{seg(0, 40, On), seg(40, 60, Off)}},
+	}
+	for _, c := range cases {
+		got := available(c.connect, c.status)
+		if len(got) != len(c.want) {
+			t.Errorf("%s: got %v, want %v", c.name, got, c.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != c.want[i] {
+				t.Errorf("%s: segment %d got %v, want %v", c.name, i, got[i], c.want[i])
+			}
+		}
+	}
+}
+
+func TestGetSLA(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "config.json")
+	if err := ioutil.WriteFile(name, []byte(`{"log": {}, "store": {"path": "`+filepath.ToSlash(dir)+`"}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	g.ParseConfig(name)
+
+	from := store.Day(time.Now()).AddDate(0, 0, -2).Add(8 * time.Hour)
+	to := from.Add(time.Hour)
+	at := func(minutes int) string {
+		return from.Add(time.Duration(minutes) * time.Minute).Format(timeFormat)
+	}
+	cases := []struct {
+		name     string
+		laneID   string
+		events   []Event
+		known    int64
+		downtime int64
+		outages  int
+	}{
+		{"period starts before first event", "1F010104000100000001", []Event{{Kind: Connect, State: On, Time: at(10)}}, 3000, 0, 0},
+		{"unknown status counted as open", "1F010104000100000002", []Event{{Kind: Connect, State: On, Time: at(-10)}}, 3600, 0, 0},
+		{"adjacent outages merged", "1F010104000100000003", []Event{{Kind: Connect, State: Off, Time: at(-10)}, {Kind: Status, State: Off, Time: at(-10)}, {Kind: Connect, State: On, Time: at(20)}, {Kind: Status, State: On, Time: at(40)}}, 3600, 2400, 1},
+		{"outage open at period end", "1F010104000100000004", []Event{{Kind: Connect, State: On, Time: at(-10)}, {Kind: Status, State: On, Time: at(-10)}, {Kind: Status, State: Off, Time: at(40)}}, 3600, 1200, 1},
+	}
+	laneList := make([]datastruct.Node, 0)
+	for _, c := range cases {
+		for _, e := range c.events {
+			e.LaneID = c.laneID
+			if err := store.Append(KIND, parseTime(e.Time), e); err != nil {
+				t.Fatal(err)
+			}
+		}
+		laneList = append(laneList, datastruct.Node{NodeID: c.laneID})
+	}
+
+	r := GetSLA(laneList, from, to)
+	if len(r.Lanes) != len(cases) {
+		t.Fatalf("got %d lanes, want %d", len(r.Lanes), len(cases))
+	}
+	var station SLA
+	for i, c := range cases {
+		l := r.Lanes[i]
+		if l.Known != c.known || l.Downtime != c.downtime || l.Outages != c.outages || l.Uptime != c.known-c.downtime {
+			t.Errorf("%s: got %+v, want known %d downtime %d outages %d", c.name, l, c.known, c.downtime, c.outages)
+		}
+		station.add(l)
+	}
+	station.finish()
+	if len(r.Stations) != 1 {
+		t.Fatalf("got %d stations, want 1", len(r.Stations))
+	}
+	if s := r.Stations[0]; s.Lanes != len(cases) || s.Known != station.Known || s.Outages != 2 || s.MTTR != 1800 || s.Availability != station.Availability {
+		t.Errorf("station got %+v, want %+v", s, station)
+	}
+}
@@ -0,0 +1,318 @@
+package timeline
+
+import (
+	"sort"
+	"sync"
+	"time"
+	"tollsys/tollmon/datastruct"
+	"tollsys/tollmon/g"
+	"tollsys/tollmon/parameters"
+	"tollsys/tollmon/store"
+)
+
+//车道状态时间线
+//记录车道通讯连接(心跳检测)、车道开闭(车道状态消息)、上下班的每次变更，
+//查询时按时间段生成状态区间，用于甘特图展示及可用率计算
+
+const (
+	KIND = "timeline"
+
+	Connect = "connect" //通讯连接，On为已连接
+	Status  = "status"  //车道开闭，On为开启(laneStatus为1)
+	Duty    = "duty"    //上下班，On为上班
+
+	Unknown = -1
+	Off     = 0
+	On      = 1
+
+	lookbackDays = 7 //查询时向前加载的天数，用于确定查询起始时刻的状态
+
+	timeFormat = "2006-01-02 15:04:05"
+)
+
+//Event 状态变更记录
+type Event struct {
+	LaneID string `json:"laneID"`
+	Kind   string `json:"kind"`
+	State  int    `json:"state"`
+	Time   string `json:"time"`
+}
+
+//Segment 状态区间[Start,End)，State为Unknown时表示无记录
+type Segment struct {
+	Start   string `json:"start"`
+	End     string `json:"end"`
+	State   int    `json:"state"`
+	Seconds int64  `json:"seconds"`
+}
+
+//LaneTimeline 车道状态时间线
+type LaneTimeline struct {
+	LaneID   string    `json:"laneID"`
+	LaneName string    `json:"laneName"`
+	Connect  []Segment `json:"connect"`
+	Status   []Segment `json:"status"`
+	Duty     []Segment `json:"duty"`
+}
+
+//Availability 车道单日可用率(%)，按有记录的时长计算
+//Known 为有通讯连接记录的秒数；Available 为通讯连接且车道开启(无开闭记录视为开启)的时长占比
+type Availability struct {
+	LaneID    string  `json:"laneID"`
+	LaneName  string  `json:"laneName"`
+	Date      string  `json:"date"`
+	Known     int64   `json:"known"`
+	Connected float64 `json:"connected"`
+	Open      float64 `json:"open"`
+	Available float64 `json:"available"`
+}
+
+var (
+	lock = &sync.Mutex{}
+	last = make(map[string]map[string]int) //车道 -> 状态类别 -> 最近一次状态
+)
+
+//InitTimeline 以本地存储恢复各车道最近一次状态
+//服务启动时车道均视为未连接，与LaneInfo的ConnectStatus一致
+func InitTimeline() {
+	now := time.Now()
+	lock.Lock()
+	err := store.Load(KIND, now.AddDate(0, 0, -lookbackDays), now, func(b []byte) {
+		e := Event{}
+		if err := g.Json.Unmarshal(b, &e); err != nil {
+			g.LogError("parse timeline err:", err.Error())
+			return
+		}
+		setLast(e)
+	})
+	lock.Unlock()
+	if err != nil {
+		g.LogError("load timeline err:", err.Error())
+	}
+	for _, lane := range parameters.GetLanes() {
+		record(lane.NodeID, Connect, Off, now.Format(timeFormat))
+	}
+}
+
+//setLast 调用方需持有lock
+func setLast(e Event) {
+	m, ok := last[e.LaneID]
+	if !ok {
+		m = make(map[string]int)
+		last[e.LaneID] = m
+	}
+	m[e.Kind] = e.State
+}
+
+//record 状态变更时写入本地存储
+func record(laneID string, kind string, state int, mTime string) {
+	lock.Lock()
+	defer lock.Unlock()
+	if s, ok := last[laneID][kind]; ok && s == state {
+		return
+	}
+	e := Event{LaneID: laneID, Kind: kind, State: state, Time: mTime}
+	if err := store.Append(KIND, parseTime(mTime), e); err != nil {
+		g.LogError("save timeline err:", laneID, err.Error())
+	}
+	setLast(e)
+}
+
+func boolState(b bool) int {
+	if b {
+		return On
+	}
+	return Off
+}
+
+//Heartbeat 记录心跳检测产生的通讯连接状态变更
+func Heartbeat(msg datastruct.MsgSend) {
+	if v, ok := msg.MsgContent["ConnectStatus"].(bool); ok {
+		record(msg.MsgLane, Connect, boolState(v), msg.MsgTime)
+	}
+}
+
+//LaneStatus 记录车道开闭状态变更
+func LaneStatus(msg datastruct.MsgSend) {
+	if v, ok := msg.MsgContent["Status"].(int); ok {
+		record(msg.MsgLane, Status, boolState(v == 1), msg.MsgTime)
+	}
+}
+
+//OnDuty 记录上班
+func OnDuty(msg datastruct.MsgSend) {
+	record(msg.MsgLane, Duty, On, msg.MsgTime)
+}
+
+//OffDuty 记录下班
+func OffDuty(msg datastruct.MsgSend) {
+	record(msg.MsgLane, Duty, Off, msg.MsgTime)
+}
+
+//load 加载所选车道[from,to)时段内及之前lookbackDays天的状态变更，按车道、状态类别分组并按时间排序
+func load(laneList []datastruct.Node, from time.Time, to time.Time) map[string]map[string][]Event {
+	result := make(map[string]map[string][]Event)
+	for _, lane := range laneList {
+		result[lane.NodeID] = make(map[string][]Event)
+	}
+	end := to.Format(timeFormat)
+	err := store.Load(KIND, from.AddDate(0, 0, -lookbackDays), to, func(b []byte) {
+		e := Event{}
+		if err := g.Json.Unmarshal(b, &e); err != nil {
+			g.LogError("parse timeline err:", err.Error())
+			return
+		}
+		if m, ok := result[e.LaneID]; ok && e.Time < end {
+			m[e.Kind] = append(m[e.Kind], e)
+		}
+	})
+	if err != nil {
+		g.LogError("load timeline err:", err.Error())
+	}
+	for _, m := range result {
+		for _, list := range m {
+			sort.SliceStable(list, func(i, j int) bool {
+				return list[i].Time < list[j].Time
+			})
+		}
+	}
+	return result
+}
+
+//segments 由按时间排序的状态变更生成[from,to)时段内的状态区间，合并相邻的相同状态
+func segments(events []Event, from time.Time, to time.Time) []Segment {
+	list := make([]Segment, 0)
+	state := Unknown
+	start := from
+	add := func(end time.Time, next int) {
+		if !end.After(start) {
+			state = next
+			return
+		}
+		if n := len(list); n > 0 && list[n-1].State == state {
+			list[n-1].End = end.Format(timeFormat)
+			list[n-1].Seconds += int64(end.Sub(start).Seconds())
+		} else {
+			list = append(list, Segment{Start: start.Format(timeFormat), End: end.Format(timeFormat), State: state, Seconds: int64(end.Sub(start).Seconds())})
+		}
+		start = end
+		state = next
+	}
+	for _, e := range events {
+		t := parseTime(e.Time)
+		if t.Before(from) {
+			state = e.State
+			continue
+		}
+		if !t.Before(to) {
+			break
+		}
+		add(t, e.State)
+	}
+	add(to, state)
+	return list
+}
+
+//GetTimelines 查询所选车道[from,to)时段内的状态时间线，to晚于当前时间时截止至当前时间
+func GetTimelines(laneList []datastruct.Node, from time.Time, to time.Time) []LaneTimeline {
+	if now := time.Now(); to.After(now) {
+		to = now
+	}
+	events := load(laneList, from, to)
+	list := make([]LaneTimeline, 0, len(laneList))
+	for _, lane := range laneList {
+		m := events[lane.NodeID]
+		list = append(list, LaneTimeline{
+			LaneID:   lane.NodeID,
+			LaneName: lane.NodeName,
+			Connect:  segments(m[Connect], from, to),
+			Status:   segments(m[Status], from, to),
+			Duty:     segments(m[Duty], from, to),
+		})
+	}
+	return list
+}
+
+//GetAvailability 按日统计所选车道[from,to]日期范围内的可用率
+func GetAvailability(laneList []datastruct.Node, from time.Time, to time.Time) []Availability {
+	from = store.Day(from)
+	end := store.Day(to).AddDate(0, 0, 1)
+	if now := time.Now(); end.After(now) {
+		end = now
+	}
+	events := load(laneList, from, end)
+	list := make([]Availability, 0)
+	for _, lane := range laneList {
+		m := events[lane.NodeID]
+		for day := from; day.Before(end); day = day.AddDate(0, 0, 1) {
+			dayEnd := day.AddDate(0, 0, 1)
+			if dayEnd.After(end) {
+				dayEnd = end
+			}
+			a := Availability{LaneID: lane.NodeID, LaneName: lane.NodeName, Date: day.Format("2006-01-02")}
+			connect := segments(m[Connect], day, dayEnd)
+			status := segments(m[Status], day, dayEnd)
+			var connected, open, statusKnown, available int64
+			for _, c := range connect {
+				if c.State == Unknown {
+					continue
+				}
+				a.Known += c.Seconds
+				if c.State == On {
+					connected += c.Seconds
+					available += overlap(c, status)
+				}
+			}
+			for _, s := range status {
+				if s.State != Unknown {
+					statusKnown += s.Seconds
+				}
+				if s.State == On {
+					open += s.Seconds
+				}
+			}
+			a.Connected = percent(connected, a.Known)
+			a.Open = percent(open, statusKnown)
+			a.Available = percent(available, a.Known)
+			list = append(list, a)
+		}
+	}
+	return list
+}
+
+//overlap 计算区间c与车道开启区间重叠的秒数，无车道开闭记录的区间视为开启
+func overlap(c Segment, list []Segment) int64 {
+	cs, ce := parseTime(c.Start), parseTime(c.End)
+	var sum int64
+	for _, s := range list {
+		if s.State == Off {
+			continue
+		}
+		start, end := parseTime(s.Start), parseTime(s.End)
+		if start.Before(cs) {
+			start = cs
+		}
+		if end.After(ce) {
+			end = ce
+		}
+		if end.After(start) {
+			sum += int64(end.Sub(start).Seconds())
+		}
+	}
+	return sum
+}
+
+func percent(n int64, total int64) float64 {
+	if total <= 0 {
+		return 0
+	}
+	return float64(n*10000/total) / 100
+}
+
+func parseTime(s string) time.Time {
+	t, err := time.ParseInLocation(timeFormat, s, time.Local)
+	if err != nil {
+		return time.Now()
+	}
+	return t
+}
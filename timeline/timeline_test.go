@@ -0,0 +1,38 @@
+package timeline
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSegments(t *testing.T) {
+	from := time.Date(2020, 3, 1, 8, 0, 0, 0, time.Local)
+	to := from.Add(time.Hour)
+	at := func(minutes int) string {
+		return from.Add(time.Duration(minutes) * time.Minute).Format(timeFormat)
+	}
+	cases := []struct {
+		name   string
+		events []Event
+		want   []Segment
+	}{
+		{"no events", nil, []Segment{{at(0), at(60), Unknown, 3600}}},
+		{"period starts before first event", []Event{{State: On, Time: at(10)}}, []Segment{{at(0), at(10), Unknown, 600}, {at(10), at(60), On, 3000}}},
+		{"state carried from before period", []Event{{State: Off, Time: at(-60)}, {State: On, Time: at(30)}}, []Segment{{at(0), at(30), Off, 1800}, {at(30), at(60), On, 1800}}},
+		{"repeated state merged", []Event{{State: On, Time: at(-5)}, {State: On, Time: at(20)}}, []Segment{{at(0), at(60), On, 3600}}},
+		{"event at period start", []Event{{State: Off, Time: at(-5)}, {State: On, Time: at(0)}}, []Segment{{at(0), at(60), On, 3600}}},
+		{"events after period ignored", []Event{{State: On, Time: at(0)}, {State: Off, Time: at(60)}}, []Segment{{at(0), at(60), On, 3600}}},
+	}
+	for _, c := range cases {
+		got := segments(c.events, from, to)
+		if len(got) != len(c.want) {
+			t.Errorf("%s: got %v, want %v", c.name, got, c.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != c.want[i] {
+				t.Errorf("%s: segment %d got %v, want %v", c.name, i, got[i], c.want[i])
+			}
+		}
+	}
+}
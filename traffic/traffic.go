@@ -0,0 +1,350 @@
+package traffic
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+	"tollsys/tollmon/datastruct"
+	"tollsys/tollmon/g"
+	"tollsys/tollmon/store"
+)
+
+//车道流量时间序列
+//出入口记录按分钟统计，写入时同步汇总至小时、天，按车道分文件存储：
+//分钟级 {store.path}/traffic/minute/20060102/{laneID}.json
+//小时级 {store.path}/traffic/hour/200601/{laneID}.json
+//天级   {store.path}/traffic/day/2006/{laneID}.json
+
+const (
+	KIND   = "traffic"
+	Minute = "minute"
+	Hour   = "hour"
+	Day    = "day"
+
+	timeFormat = "2006-01-02 15:04:05"
+)
+
+var resolutions = []string{Minute, Hour, Day}
+
+//maxSpans 各统计粒度单次查询的最大时间范围
+var maxSpans = map[string]time.Duration{
+	Minute: 2 * 24 * time.Hour,
+	Hour:   93 * 24 * time.Hour,
+	Day:    3 * 366 * 24 * time.Hour,
+}
+
+//MaxSpan 获取统计粒度单次查询的最大时间范围
+func MaxSpan(res string) time.Duration {
+	return maxSpans[res]
+}
+
+//Point 统计点，Time为统计时段起始时间(unix秒)
+//EnClass/ExClass 车型分布；EnType/ExType 车种分布
+type Point struct {
+	Time    int64       `json:"t"`
+	Entry   int         `json:"en"`
+	Exit    int         `json:"ex"`
+	EnClass map[int]int `json:"enClass,omitempty"`
+	ExClass map[int]int `json:"exClass,omitempty"`
+	EnType  map[int]int `json:"enType,omitempty"`
+	ExType  map[int]int `json:"exType,omitempty"`
+}
+
+//LaneSeries 单车道时间序列
+type LaneSeries struct {
+	LaneID string  `json:"laneID"`
+	Points []Point `json:"points"`
+}
+
+//series 单个存储文件在内存中的缓存
+type series struct {
+	points  map[int64]*Point
+	dirty   bool
+	lastUse time.Time
+}
+
+var (
+	lock  = &sync.Mutex{}
+	cache = make(map[string]*series) //文件路径 -> 缓存
+)
+
+//InitTraffic 以goroutine启动流量数据落盘及过期数据清理
+func InitTraffic() {
+	go serve()
+}
+
+//Entry 统计入口记录
+func Entry(msg datastruct.MsgSend) {
+	record(msg, true)
+}
+
+//Exit 统计出口记录
+func Exit(msg datastruct.MsgSend) {
+	record(msg, false)
+}
+
+func record(msg datastruct.MsgSend, entry bool) {
+	t, err := time.ParseInLocation(timeFormat, msg.MsgTime, time.Local)
+	if err != nil {
+		t = time.Now()
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	for _, res := range resolutions {
+		s := getSeries(fileName(res, msg.MsgLane, t))
+		start := Truncate(res, t).Unix()
+		p, ok := s.points[start]
+		if !ok {
+			p = &Point{Time: start}
+			s.points[start] = p
+		}
+		if entry {
+			p.Entry++
+			p.EnClass = inc(p.EnClass, msg.GetInt("EnClass"))
+			p.EnType = inc(p.EnType, msg.GetInt("EnType"))
+		} else {
+			p.Exit++
+			p.ExClass = inc(p.ExClass, msg.GetInt("ExClass"))
+			p.ExType = inc(p.ExType, msg.GetInt("ExType"))
+		}
+		s.dirty = true
+	}
+}
+
+func inc(m map[int]int, key int) map[int]int {
+	if m == nil {
+		m = make(map[int]int)
+	}
+	m[key]++
+	return m
+}
+
+//Truncate 获取t所在统计时段的起始时间
+func Truncate(res string, t time.Time) time.Time {
+	switch res {
+	case Minute:
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, t.Location())
+	case Hour:
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, t.Location())
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+//period 获取t所在存储文件的时段及下一时段起始时间
+func period(res string, t time.Time) (string, time.Time) {
+	switch res {
+	case Minute:
+		d := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+		return d.Format("20060102"), d.AddDate(0, 0, 1)
+	case Hour:
+		m := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+		return m.Format("200601"), m.AddDate(0, 1, 0)
+	}
+	y := time.Date(t.Year(), 1, 1, 0, 0, 0, 0, t.Location())
+	return y.Format("2006"), y.AddDate(1, 0, 0)
+}
+
+func fileName(res string, laneID string, t time.Time) string {
+	p, _ := period(res, t)
+	return filepath.Join(store.Dir(KIND), res, p, laneID+".json")
+}
+
+//getSeries 获取存储文件缓存，缓存中不存在时从文件加载
+//调用方需持有lock
+func getSeries(name string) *series {
+	s, ok := cache[name]
+	if !ok {
+		s = &series{points: make(map[int64]*Point)}
+		points := loadPoints(name)
+		for i := range points {
+			s.points[points[i].Time] = &points[i]
+		}
+		cache[name] = s
+	}
+	s.lastUse = time.Now()
+	return s
+}
+
+//loadPoints 读取存储文件，文件不存在时返回空
+func loadPoints(name string) []Point {
+	points := make([]Point, 0)
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			g.LogError("read traffic file err:", name, err.Error())
+		}
+		return points
+	}
+	if err := g.Json.Unmarshal(b, &points); err != nil {
+		g.LogError("parse traffic file err:", name, err.Error())
+	}
+	return points
+}
+
+//Range 查询车道[from,to)时段内的时间序列
+//已缓存的文件从缓存读取；未缓存的文件在锁外直接读取且不加入缓存，不阻塞流量统计
+func Range(res string, laneID string, from time.Time, to time.Time) []Point {
+	list := make([]Point, 0)
+	for t := from; t.Before(to); {
+		_, next := period(res, t)
+		name := fileName(res, laneID, t)
+		lock.Lock()
+		s, ok := cache[name]
+		if ok {
+			for start, p := range s.points {
+				if start >= from.Unix() && start < to.Unix() {
+					list = append(list, copyPoint(p))
+				}
+			}
+		}
+		lock.Unlock()
+		if !ok {
+			for _, p := range loadPoints(name) {
+				if p.Time >= from.Unix() && p.Time < to.Unix() {
+					list = append(list, p)
+				}
+			}
+		}
+		t = next
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Time < list[j].Time
+	})
+	return list
+}
+
+//Merge 按统计时段合并多个车道的时间序列
+func Merge(list []LaneSeries) []Point {
+	points := make(map[int64]*Point)
+	for _, s := range list {
+		for _, p := range s.Points {
+			m, ok := points[p.Time]
+			if !ok {
+				m = &Point{Time: p.Time}
+				points[p.Time] = m
+			}
+			add(m, &p)
+		}
+	}
+	result := make([]Point, 0, len(points))
+	for _, p := range points {
+		result = append(result, *p)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Time < result[j].Time
+	})
+	return result
+}
+
+//Sum 统计车道[from,to)时段内的流量合计，按分钟级数据统计
+func Sum(laneID string, from time.Time, to time.Time) Point {
+	sum := Point{Time: from.Unix()}
+	for _, p := range Range(Minute, laneID, Truncate(Minute, from), to) {
+		add(&sum, &p)
+	}
+	return sum
+}
+
+func add(dst *Point, src *Point) {
+	dst.Entry += src.Entry
+	dst.Exit += src.Exit
+	dst.EnClass = addMap(dst.EnClass, src.EnClass)
+	dst.ExClass = addMap(dst.ExClass, src.ExClass)
+	dst.EnType = addMap(dst.EnType, src.EnType)
+	dst.ExType = addMap(dst.ExType, src.ExType)
+}
+
+func addMap(dst map[int]int, src map[int]int) map[int]int {
+	if len(src) == 0 {
+		return dst
+	}
+	if dst == nil {
+		dst = make(map[int]int)
+	}
+	for k, v := range src {
+		dst[k] += v
+	}
+	return dst
+}
+
+func copyPoint(p *Point) Point {
+	c := Point{Time: p.Time}
+	add(&c, p)
+	return c
+}
+
+//serve 每分钟将变更数据写入文件并释放长时间未使用的缓存，每小时清理过期分钟级数据
+func serve() {
+	g.LogInfo("goroutine start - traffic flush")
+	lastClean := time.Time{}
+	for {
+		time.Sleep(time.Minute)
+		flush()
+		if time.Since(lastClean) > time.Hour {
+			clean()
+			lastClean = time.Now()
+		}
+	}
+}
+
+func flush() {
+	lock.Lock()
+	defer lock.Unlock()
+	for name, s := range cache {
+		if s.dirty {
+			if err := save(name, s); err != nil {
+				g.LogError("save traffic file err:", name, err.Error())
+				continue
+			}
+			s.dirty = false
+		}
+		if time.Since(s.lastUse) > 10*time.Minute {
+			delete(cache, name)
+		}
+	}
+}
+
+//save 先写临时文件再替换，避免写入中断导致文件损坏
+func save(name string, s *series) error {
+	points := make([]Point, 0, len(s.points))
+	for _, p := range s.points {
+		points = append(points, *p)
+	}
+	sort.Slice(points, func(i, j int) bool {
+		return points[i].Time < points[j].Time
+	})
+	b, err := g.Json.Marshal(points)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(name+".tmp", b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(name+".tmp", name)
+}
+
+//clean 删除超过保留天数的分钟级数据
+func clean() {
+	days := 7
+	if g.Config().Traffic != nil && g.Config().Traffic.MinuteDays > 0 {
+		days = g.Config().Traffic.MinuteDays
+	}
+	expire := time.Now().AddDate(0, 0, -days).Format("20060102")
+	dir := filepath.Join(store.Dir(KIND), Minute)
+	list, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, f := range list {
+		if f.IsDir() && f.Name() < expire {
+			g.LogInfo("remove expired traffic data:", f.Name())
+			os.RemoveAll(filepath.Join(dir, f.Name()))
+		}
+	}
+}